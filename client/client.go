@@ -5,10 +5,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 
+	"github.com/giantswarm/mayu-infopusher/machinedata"
 	"github.com/giantswarm/microerror"
 
 	"github.com/giantswarm/mayu/hostmgr"
@@ -27,6 +30,10 @@ type Client struct {
 
 	// Port is used to connect to mayu over network.
 	Port uint16
+
+	// Cluster names the additional cluster to manage. The default cluster is
+	// managed in case it is empty.
+	Cluster string
 }
 
 // New creates a new configured client to interact with mayu over its network
@@ -41,6 +48,49 @@ func New(scheme, host string, port uint16) (*Client, error) {
 	return client, nil
 }
 
+// rootURL returns the URL the API endpoints shared by all clusters are
+// relative to.
+func (c *Client) rootURL() string {
+	return fmt.Sprintf("%s://%s:%d", c.Scheme, c.Host, c.Port)
+}
+
+// baseURL returns the URL the API endpoints of the managed cluster are
+// relative to.
+func (c *Client) baseURL() string {
+	u := c.rootURL()
+	if c.Cluster != "" {
+		u += "/clusters/" + url.PathEscape(c.Cluster)
+	}
+	return u
+}
+
+// Clusters lists the names of the additional clusters served by mayu.
+func (c *Client) Clusters() ([]string, error) {
+	var names []string
+
+	resp, err := http.Get(c.rootURL() + "/admin/clusters")
+	if err != nil {
+		return names, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return names, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return names, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &names)
+	if err != nil {
+		return names, microerror.Mask(err)
+	}
+
+	return names, nil
+}
+
 func (c *Client) BootComplete(serial string, host hostmgr.Host) error {
 	data, err := json.Marshal(host)
 
@@ -48,11 +98,16 @@ func (c *Client) BootComplete(serial string, host hostmgr.Host) error {
 		return microerror.Mask(err)
 	}
 
-	resp, err := httputil.Put(fmt.Sprintf("%s://%s:%d/admin/host/%s/boot_complete", c.Scheme, c.Host, c.Port, serial), "application/json", bytes.NewBuffer(data))
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/boot_complete", c.baseURL(), serial), "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		return microerror.Mask(err)
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
 	return nil
 }
 
@@ -65,7 +120,7 @@ func (c *Client) SetProviderId(serial, value string) error {
 		return microerror.Mask(err)
 	}
 
-	resp, err := httputil.Put(fmt.Sprintf("%s://%s:%d/admin/host/%s/set_provider_id", c.Scheme, c.Host, c.Port, serial), contentType, bytes.NewBuffer(data))
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/set_provider_id", c.baseURL(), serial), contentType, bytes.NewBuffer(data))
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -87,7 +142,7 @@ func (c *Client) SetIPMIAddr(serial, value string) error {
 		return microerror.Mask(err)
 	}
 
-	resp, err := httputil.Put(fmt.Sprintf("%s://%s:%d/admin/host/%s/set_ipmi_addr", c.Scheme, c.Host, c.Port, serial), contentType, bytes.NewBuffer(data))
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/set_ipmi_addr", c.baseURL(), serial), contentType, bytes.NewBuffer(data))
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -109,7 +164,7 @@ func (c *Client) SetEtcdClusterToken(serial, value string) error {
 		return microerror.Mask(err)
 	}
 
-	resp, err := httputil.Put(fmt.Sprintf("%s://%s:%d/admin/host/%s/set_etcd_cluster_token", c.Scheme, c.Host, c.Port, serial), contentType, bytes.NewBuffer(data))
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/set_etcd_cluster_token", c.baseURL(), serial), contentType, bytes.NewBuffer(data))
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -122,6 +177,248 @@ func (c *Client) SetEtcdClusterToken(serial, value string) error {
 	return nil
 }
 
+// SetInventory reports the hardware inventory given by data for a node given
+// by serial. Unknown nodes are registered with the given inventory.
+func (c *Client) SetInventory(serial string, data machinedata.HostData) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/set_inventory", c.baseURL(), serial), contentType, bytes.NewBuffer(body))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	return nil
+}
+
+// Inventory fetches the hardware inventory stored for a node given by serial.
+func (c *Client) Inventory(serial string) (hostmgr.Inventory, error) {
+	var inventory hostmgr.Inventory
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/host/%s/inventory", c.baseURL(), serial))
+	if err != nil {
+		return inventory, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return inventory, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return inventory, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &inventory)
+	if err != nil {
+		return inventory, microerror.Mask(err)
+	}
+
+	return inventory, nil
+}
+
+// TemplatesEnv fetches the template variables of a node given by serial,
+// explaining which layer of the configuration gives their value.
+func (c *Client) TemplatesEnv(serial string) (map[string]hostmgr.TemplateVar, error) {
+	vars := map[string]hostmgr.TemplateVar{}
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/host/%s/templates_env", c.baseURL(), serial))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return nil, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &vars)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return vars, nil
+}
+
+// Ignition renders the Ignition config a node given by serial would receive,
+// without changing its state. profile replaces the profile of the node,
+// unless it is empty. In case diff is set, the changes against the config the
+// node received last are returned instead.
+func (c *Client) Ignition(serial, profile string, diff bool) (string, error) {
+	query := url.Values{}
+	query.Set("dry_run", "true")
+	if profile != "" {
+		query.Set("profile", profile)
+	}
+	if diff {
+		query.Set("diff", "true")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/host/%s/ignition?%s", c.baseURL(), serial, query.Encode()))
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	if resp.StatusCode > 399 {
+		return "", microerror.Mask(fmt.Errorf("invalid status code '%d': %s", resp.StatusCode, body))
+	}
+
+	return string(body), nil
+}
+
+// History fetches the recorded revisions of a node given by serial, newest
+// first.
+func (c *Client) History(serial string) ([]hostmgr.Revision, error) {
+	revisions := []hostmgr.Revision{}
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/host/%s/history", c.baseURL(), serial))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return nil, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &revisions)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return revisions, nil
+}
+
+// Diff fetches the unified diff of a node given by serial between the
+// revisions from and to. In case to is empty, from is compared against the
+// current state of the node.
+func (c *Client) Diff(serial, from, to string) (string, error) {
+	query := url.Values{}
+	query.Set("from", from)
+	if to != "" {
+		query.Set("to", to)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/host/%s/diff?%s", c.baseURL(), serial, query.Encode()))
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return "", microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return string(body), nil
+}
+
+// Rollback restores a node given by serial as it was at the given revision.
+func (c *Client) Rollback(serial, revision string) error {
+	data, err := json.Marshal(struct {
+		Revision string
+	}{
+		Revision: revision,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/rollback", c.baseURL(), serial), contentType, bytes.NewBuffer(data))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	return nil
+}
+
+// Export downloads an archive of the whole cluster state and writes it to w.
+func (c *Client) Export(w io.Writer) error {
+	resp, err := http.Get(fmt.Sprintf("%s/admin/cluster/export", c.baseURL()))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// Import uploads the cluster archive read from r, replacing the whole cluster
+// state. In case dryRun is true, nothing is changed. The returned diff lists
+// the changes the import made or would make.
+func (c *Client) Import(r io.Reader, dryRun bool) (hostmgr.ArchiveDiff, error) {
+	var diff hostmgr.ArchiveDiff
+
+	query := url.Values{}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/cluster/import?%s", c.baseURL(), query.Encode()), "application/gzip", r)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return diff, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &diff)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	return diff, nil
+}
+
 // SetState sets the machine state for a node given by serial.
 func (c *Client) SetState(serial, value string) error {
 	state, err := hostmgr.HostState(value)
@@ -136,7 +433,7 @@ func (c *Client) SetState(serial, value string) error {
 		return microerror.Mask(err)
 	}
 
-	resp, err := httputil.Put(fmt.Sprintf("%s://%s:%d/admin/host/%s/set_state", c.Scheme, c.Host, c.Port, serial), contentType, bytes.NewBuffer(data))
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/set_state", c.baseURL(), serial), contentType, bytes.NewBuffer(data))
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -158,7 +455,7 @@ func (c *Client) Override(serial, property, value string) error {
 		return microerror.Mask(err)
 	}
 
-	resp, err := httputil.Put(fmt.Sprintf("%s://%s:%d/admin/host/%s/override", c.Scheme, c.Host, c.Port, serial), contentType, bytes.NewBuffer(data))
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/host/%s/override", c.baseURL(), serial), contentType, bytes.NewBuffer(data))
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -175,7 +472,7 @@ func (c *Client) Override(serial, property, value string) error {
 func (c *Client) List() ([]hostmgr.Host, error) {
 	list := []hostmgr.Host{}
 
-	resp, err := http.Get(fmt.Sprintf("%s://%s:%d/admin/hosts", c.Scheme, c.Host, c.Port))
+	resp, err := http.Get(fmt.Sprintf("%s/admin/hosts", c.baseURL()))
 	if err != nil {
 		return list, microerror.Mask(err)
 	}
@@ -201,7 +498,7 @@ func (c *Client) List() ([]hostmgr.Host, error) {
 func (c *Client) Status(serial string) (hostmgr.Host, error) {
 	var host hostmgr.Host
 
-	resp, err := http.Get(fmt.Sprintf("%s://%s:%d/admin/hosts", c.Scheme, c.Host, c.Port))
+	resp, err := http.Get(fmt.Sprintf("%s/admin/hosts", c.baseURL()))
 	if err != nil {
 		return host, microerror.Mask(err)
 	}
@@ -229,3 +526,118 @@ func (c *Client) Status(serial string) (hostmgr.Host, error) {
 
 	return host, microerror.Mask(fmt.Errorf("host %s not found", serial))
 }
+
+// EtcdTokens lists the tokens of the internal etcd discovery together with
+// their members and the hosts using them. Tokens are shared by all clusters.
+func (c *Client) EtcdTokens() ([]hostmgr.EtcdToken, error) {
+	var tokens []hostmgr.EtcdToken
+
+	resp, err := http.Get(c.rootURL() + "/admin/etcd/tokens")
+	if err != nil {
+		return tokens, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return tokens, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return tokens, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return tokens, microerror.Mask(err)
+	}
+
+	return tokens, nil
+}
+
+// EtcdToken returns the etcd discovery token given by token.
+func (c *Client) EtcdToken(token string) (hostmgr.EtcdToken, error) {
+	var etcdToken hostmgr.EtcdToken
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/etcd/tokens/%s", c.rootURL(), url.PathEscape(token)))
+	if err != nil {
+		return etcdToken, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return etcdToken, microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return etcdToken, microerror.Mask(err)
+	}
+
+	err = json.Unmarshal(body, &etcdToken)
+	if err != nil {
+		return etcdToken, microerror.Mask(err)
+	}
+
+	return etcdToken, nil
+}
+
+// SetEtcdTokenSize changes the size of the etcd cluster bootstrapped using
+// the etcd discovery token given by token.
+func (c *Client) SetEtcdTokenSize(token string, size int) error {
+	data, err := json.Marshal(hostmgr.EtcdToken{
+		Size: size,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	resp, err := httputil.Put(fmt.Sprintf("%s/admin/etcd/tokens/%s/size", c.rootURL(), url.PathEscape(token)), contentType, bytes.NewBuffer(data))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	return nil
+}
+
+// RemoveEtcdTokenMember removes the member given by id from the etcd discovery
+// token given by token, eg. after the member died.
+func (c *Client) RemoveEtcdTokenMember(token, id string) error {
+	resp, err := httputil.Delete(fmt.Sprintf("%s/admin/etcd/tokens/%s/members/%s", c.rootURL(), url.PathEscape(token), url.PathEscape(id)))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	return nil
+}
+
+// DeleteEtcdToken removes the etcd discovery token given by token. Tokens
+// still used by hosts or clusters are only removed in case force is true.
+func (c *Client) DeleteEtcdToken(token string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "true")
+	}
+
+	resp, err := httputil.Delete(fmt.Sprintf("%s/admin/etcd/tokens/%s?%s", c.rootURL(), url.PathEscape(token), query.Encode()))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return microerror.Mask(fmt.Errorf("invalid status code '%d'", resp.StatusCode))
+	}
+
+	return nil
+}
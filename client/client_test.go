@@ -10,8 +10,11 @@ import (
 	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/giantswarm/mayu-infopusher/machinedata"
+
 	"github.com/giantswarm/mayu/client"
 	"github.com/giantswarm/mayu/hostmgr"
 )
@@ -381,3 +384,651 @@ func Test_Client_018(t *testing.T) {
 		t.Fatalf("Client.Status NOT returned error")
 	}
 }
+
+//
+// Client.SetInventory
+//
+
+// Test_Client_019 checks for Client.SetInventory to provide proper information
+// to the server as expected.
+func Test_Client_019(t *testing.T) {
+	var response testResponse
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = testResponse{
+			Body:   body,
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	hostData := machinedata.HostData{
+		Serial: "serial",
+		NetDevs: []machinedata.NetDev{
+			{Name: "eth0", MacAddress: "00:11:22:33:44:55"},
+		},
+		ConnectedNIC: "eth0",
+	}
+	err := newClient.SetInventory("serial", hostData)
+	if err != nil {
+		t.Fatalf("Client.SetInventory returned error: %#v", err)
+	}
+
+	data, err := json.Marshal(hostData)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %#v", err)
+	}
+	if string(response.Body) != string(data) {
+		t.Fatalf("expected response body to be '%s', got '%s'", string(data), string(response.Body))
+	}
+
+	assertHeader(t, response, "content-type", []string{"application/json"})
+	assertMethod(t, response, "PUT")
+	assertPath(t, response, fmt.Sprintf("/admin/host/%s/set_inventory", "serial"))
+}
+
+// Test_Client_020 checks for Client.SetInventory to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_020(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("internal server error"))
+	}))
+	defer ts.Close()
+
+	err := newClient.SetInventory("serial", machinedata.HostData{})
+	if err == nil {
+		t.Fatalf("Client.SetInventory NOT returned error")
+	}
+}
+
+//
+// Client.Inventory
+//
+
+// Test_Client_021 checks for Client.Inventory to provide proper information
+// to the server as expected.
+func Test_Client_021(t *testing.T) {
+	var response testResponse
+	expectedInventory := hostmgr.Inventory{
+		Version:  hostmgr.InventoryVersion,
+		Revision: 2,
+		HostData: machinedata.HostData{
+			Serial:      "serial",
+			IPMIAddress: net.ParseIP("10.0.0.5"),
+		},
+	}
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		if err := json.NewEncoder(w).Encode(expectedInventory); err != nil {
+			t.Fatalf("json.NewEncoder(w).Encode returned error: %#v", err)
+		}
+	}))
+	defer ts.Close()
+
+	inventory, err := newClient.Inventory("serial")
+	if err != nil {
+		t.Fatalf("Client.Inventory returned error: %#v", err)
+	}
+
+	if !reflect.DeepEqual(inventory, expectedInventory) {
+		t.Fatalf("expected %#v got %#v", expectedInventory, inventory)
+	}
+
+	assertMethod(t, response, "GET")
+	assertPath(t, response, "/admin/host/serial/inventory")
+}
+
+// Test_Client_022 checks for Client.Inventory to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_022(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("host has no inventory"))
+	}))
+	defer ts.Close()
+
+	_, err := newClient.Inventory("serial")
+	if err == nil {
+		t.Fatalf("Client.Inventory NOT returned error")
+	}
+}
+
+//
+// Client.History
+//
+
+// Test_Client_023 checks for Client.History to provide proper information
+// to the server as expected.
+func Test_Client_023(t *testing.T) {
+	var response testResponse
+	expectedRevisions := []hostmgr.Revision{
+		{ID: "b", Author: "mayu", Message: "set_provider_id: set provider id of host serial (by 10.0.0.1)"},
+		{ID: "a", Author: "mayu", Message: "ignition: create host serial (by 10.0.0.2)"},
+	}
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		if err := json.NewEncoder(w).Encode(expectedRevisions); err != nil {
+			t.Fatalf("json.NewEncoder(w).Encode returned error: %#v", err)
+		}
+	}))
+	defer ts.Close()
+
+	revisions, err := newClient.History("serial")
+	if err != nil {
+		t.Fatalf("Client.History returned error: %#v", err)
+	}
+
+	if !reflect.DeepEqual(revisions, expectedRevisions) {
+		t.Fatalf("expected %#v got %#v", expectedRevisions, revisions)
+	}
+
+	assertMethod(t, response, "GET")
+	assertPath(t, response, "/admin/host/serial/history")
+}
+
+//
+// Client.Rollback
+//
+
+// Test_Client_024 checks for Client.Rollback to provide proper information
+// to the server as expected.
+func Test_Client_024(t *testing.T) {
+	var response testResponse
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = testResponse{
+			Body:   body,
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	err := newClient.Rollback("serial", "abcdef")
+	if err != nil {
+		t.Fatalf("Client.Rollback returned error: %#v", err)
+	}
+
+	if string(response.Body) != `{"Revision":"abcdef"}` {
+		t.Fatalf("unexpected request body '%s'", string(response.Body))
+	}
+
+	assertHeader(t, response, "content-type", []string{"application/json"})
+	assertMethod(t, response, "PUT")
+	assertPath(t, response, "/admin/host/serial/rollback")
+}
+
+// Test_Client_025 checks for Client.Rollback to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_025(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotImplemented)
+		_, _ = w.Write([]byte("git history is disabled for this cluster"))
+	}))
+	defer ts.Close()
+
+	err := newClient.Rollback("serial", "abcdef")
+	if err == nil {
+		t.Fatalf("Client.Rollback NOT returned error")
+	}
+}
+
+//
+// Client.Import
+//
+
+// Test_Client_026 checks for Client.Import to provide proper information to
+// the server as expected.
+func Test_Client_026(t *testing.T) {
+	var response testResponse
+	var query url.Values
+	expectedDiff := hostmgr.ArchiveDiff{
+		Added:   []string{"serial-2/conf.json"},
+		Changed: []string{"cluster.json"},
+		Removed: []string{},
+	}
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = testResponse{
+			Body:   body,
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+		query = r.URL.Query()
+
+		if err := json.NewEncoder(w).Encode(expectedDiff); err != nil {
+			t.Fatalf("json.NewEncoder(w).Encode returned error: %#v", err)
+		}
+	}))
+	defer ts.Close()
+
+	diff, err := newClient.Import(strings.NewReader("archive"), true)
+	if err != nil {
+		t.Fatalf("Client.Import returned error: %#v", err)
+	}
+
+	if !reflect.DeepEqual(diff, expectedDiff) {
+		t.Fatalf("expected %#v got %#v", expectedDiff, diff)
+	}
+	if string(response.Body) != "archive" {
+		t.Fatalf("unexpected request body '%s'", string(response.Body))
+	}
+	if query.Get("dry_run") != "true" {
+		t.Fatalf("expected dry run, got query '%v'", query)
+	}
+
+	assertHeader(t, response, "content-type", []string{"application/gzip"})
+	assertMethod(t, response, "PUT")
+	assertPath(t, response, "/admin/cluster/import")
+}
+
+// Test_Client_027 checks for Client.Import to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_027(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("unable to read cluster archive"))
+	}))
+	defer ts.Close()
+
+	_, err := newClient.Import(strings.NewReader("archive"), false)
+	if err == nil {
+		t.Fatalf("Client.Import NOT returned error")
+	}
+}
+
+//
+// Client.Cluster
+//
+
+// Test_Client_028 checks for requests of a client managing an additional
+// cluster to be sent to the endpoints of that cluster.
+func Test_Client_028(t *testing.T) {
+	var response testResponse
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = testResponse{
+			Body:   body,
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+	}))
+	defer ts.Close()
+
+	newClient.Cluster = "lab"
+	err := newClient.SetProviderId("serial", "provider-id")
+	if err != nil {
+		t.Fatalf("Client.SetProviderId returned error: %#v", err)
+	}
+
+	assertMethod(t, response, "PUT")
+	assertPath(t, response, "/clusters/lab/admin/host/serial/set_provider_id")
+}
+
+//
+// Client.Clusters
+//
+
+// Test_Client_029 checks for Client.Clusters to list the clusters returned by
+// the server, independent of the cluster managed by the client.
+func Test_Client_029(t *testing.T) {
+	var response testResponse
+	expectedNames := []string{"lab", "staging"}
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		if err := json.NewEncoder(w).Encode(expectedNames); err != nil {
+			t.Fatalf("json.NewEncoder(w).Encode returned error: %#v", err)
+		}
+	}))
+	defer ts.Close()
+
+	newClient.Cluster = "lab"
+	names, err := newClient.Clusters()
+	if err != nil {
+		t.Fatalf("Client.Clusters returned error: %#v", err)
+	}
+
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("expected %#v got %#v", expectedNames, names)
+	}
+
+	assertMethod(t, response, "GET")
+	assertPath(t, response, "/admin/clusters")
+}
+
+//
+// Client.EtcdTokens
+//
+
+// Test_Client_030 checks for Client.EtcdTokens to return the tokens listed by
+// the server, independent of the cluster managed by the client.
+func Test_Client_030(t *testing.T) {
+	var response testResponse
+	expectedTokens := []hostmgr.EtcdToken{
+		{
+			Token:      "0123456789abcdef0123456789abcdef",
+			Size:       3,
+			Members:    []hostmgr.EtcdTokenMember{{ID: "1a2b", Value: "node-1=http://10.0.0.1:2380"}},
+			Registered: true,
+			Default:    []string{""},
+			Hosts:      []hostmgr.EtcdTokenHost{{Cluster: "lab", Serial: "serial"}},
+		},
+	}
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		if err := json.NewEncoder(w).Encode(expectedTokens); err != nil {
+			t.Fatalf("json.NewEncoder(w).Encode returned error: %#v", err)
+		}
+	}))
+	defer ts.Close()
+
+	newClient.Cluster = "lab"
+	tokens, err := newClient.EtcdTokens()
+	if err != nil {
+		t.Fatalf("Client.EtcdTokens returned error: %#v", err)
+	}
+
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Fatalf("expected %#v got %#v", expectedTokens, tokens)
+	}
+
+	assertMethod(t, response, "GET")
+	assertPath(t, response, "/admin/etcd/tokens")
+}
+
+//
+// Client.SetEtcdTokenSize
+//
+
+// Test_Client_031 checks for Client.SetEtcdTokenSize to provide proper
+// information to the server as expected.
+func Test_Client_031(t *testing.T) {
+	var response testResponse
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = testResponse{
+			Body:   body,
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	err := newClient.SetEtcdTokenSize("0123456789abcdef0123456789abcdef", 5)
+	if err != nil {
+		t.Fatalf("Client.SetEtcdTokenSize returned error: %#v", err)
+	}
+
+	var token hostmgr.EtcdToken
+	if err := json.Unmarshal(response.Body, &token); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %#v", err)
+	}
+	if token.Size != 5 {
+		t.Fatalf("expected size 5, got %d", token.Size)
+	}
+
+	assertMethod(t, response, "PUT")
+	assertPath(t, response, "/admin/etcd/tokens/0123456789abcdef0123456789abcdef/size")
+	assertHeader(t, response, "content-type", []string{"application/json"})
+}
+
+//
+// Client.RemoveEtcdTokenMember
+//
+
+// Test_Client_032 checks for Client.RemoveEtcdTokenMember to provide proper
+// information to the server as expected.
+func Test_Client_032(t *testing.T) {
+	var response testResponse
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	err := newClient.RemoveEtcdTokenMember("0123456789abcdef0123456789abcdef", "1a2b")
+	if err != nil {
+		t.Fatalf("Client.RemoveEtcdTokenMember returned error: %#v", err)
+	}
+
+	assertMethod(t, response, "DELETE")
+	assertPath(t, response, "/admin/etcd/tokens/0123456789abcdef0123456789abcdef/members/1a2b")
+}
+
+//
+// Client.DeleteEtcdToken
+//
+
+// Test_Client_033 checks for Client.DeleteEtcdToken to provide proper
+// information to the server as expected.
+func Test_Client_033(t *testing.T) {
+	var response testResponse
+	var query url.Values
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Header: r.Header,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+		query = r.URL.Query()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	err := newClient.DeleteEtcdToken("0123456789abcdef0123456789abcdef", true)
+	if err != nil {
+		t.Fatalf("Client.DeleteEtcdToken returned error: %#v", err)
+	}
+
+	assertMethod(t, response, "DELETE")
+	assertPath(t, response, "/admin/etcd/tokens/0123456789abcdef0123456789abcdef")
+	if query.Get("force") != "true" {
+		t.Fatalf("expected force=true, got %#v", query)
+	}
+}
+
+// Test_Client_034 checks for Client.DeleteEtcdToken to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_034(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte("etcd token is used by 1 hosts and 0 clusters"))
+	}))
+	defer ts.Close()
+
+	err := newClient.DeleteEtcdToken("0123456789abcdef0123456789abcdef", false)
+	if err == nil {
+		t.Fatalf("Client.DeleteEtcdToken NOT returned error")
+	}
+}
+
+//
+// Client.TemplatesEnv
+//
+
+// Test_Client_035 checks for Client.TemplatesEnv to provide proper
+// information to the server as expected.
+func Test_Client_035(t *testing.T) {
+	var response testResponse
+	expectedVars := map[string]hostmgr.TemplateVar{
+		"docker_args": {
+			Value:  "--debug",
+			Source: hostmgr.TemplateVarSourceHost,
+			Overridden: []hostmgr.TemplateVarValue{
+				{Source: hostmgr.TemplateVarSourceGlobal, Value: ""},
+			},
+		},
+		"mayu_api_ip": {Value: "10.0.0.1", Source: hostmgr.TemplateVarSourceGlobal},
+	}
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		if err := json.NewEncoder(w).Encode(expectedVars); err != nil {
+			t.Fatalf("json.NewEncoder(w).Encode returned error: %#v", err)
+		}
+	}))
+	defer ts.Close()
+
+	vars, err := newClient.TemplatesEnv("serial")
+	if err != nil {
+		t.Fatalf("Client.TemplatesEnv returned error: %#v", err)
+	}
+
+	if !reflect.DeepEqual(vars, expectedVars) {
+		t.Fatalf("expected %#v got %#v", expectedVars, vars)
+	}
+
+	assertMethod(t, response, "GET")
+	assertPath(t, response, "/admin/host/serial/templates_env")
+}
+
+// Test_Client_036 checks for Client.TemplatesEnv to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_036(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("host doesn't exist"))
+	}))
+	defer ts.Close()
+
+	_, err := newClient.TemplatesEnv("serial")
+	if err == nil {
+		t.Fatalf("Client.TemplatesEnv NOT returned error")
+	}
+}
+
+// Test_Client_037 checks for Client.Ignition to provide proper information to
+// the server as expected.
+func Test_Client_037(t *testing.T) {
+	var response testResponse
+	var query url.Values
+	expectedConfig := `{"ignition":{"version":"3.3.0"}}` + "\n"
+
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response = testResponse{
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+		query = r.URL.Query()
+
+		_, _ = w.Write([]byte(expectedConfig))
+	}))
+	defer ts.Close()
+
+	config, err := newClient.Ignition("serial", "worker", true)
+	if err != nil {
+		t.Fatalf("Client.Ignition returned error: %#v", err)
+	}
+
+	if config != expectedConfig {
+		t.Fatalf("expected %s got %s", expectedConfig, config)
+	}
+
+	assertMethod(t, response, "GET")
+	assertPath(t, response, "/admin/host/serial/ignition")
+	if query.Get("dry_run") != "true" || query.Get("profile") != "worker" || query.Get("diff") != "true" {
+		t.Fatalf("unexpected query %#v", query)
+	}
+}
+
+// Test_Client_038 checks for Client.Ignition to provide proper error
+// information to the client as expected, when there are errors returned from
+// the server.
+func Test_Client_038(t *testing.T) {
+	newClient, ts := newClientAndServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"Errors":[{"Path":"$.systemd.units.1","Message":"duplicate entry defined"}]}`))
+	}))
+	defer ts.Close()
+
+	_, err := newClient.Ignition("serial", "", false)
+	if err == nil {
+		t.Fatalf("Client.Ignition NOT returned error")
+	}
+	if !strings.Contains(err.Error(), "duplicate entry defined") {
+		t.Fatalf("expected error to contain the report, got %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+	"github.com/spf13/cobra"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+var (
+	clusterCmd = &cobra.Command{
+		Use:   "cluster",
+		Short: "Back up and restore the cluster directory",
+	}
+
+	clusterExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Write the cluster state to an archive",
+		Long: `Write the cluster state to an archive.
+
+The archive holds cluster.json, all host configurations and their
+inventories, together with a manifest of checksums. While mayu is running,
+use the /admin/cluster/export API endpoint instead.`,
+		Args: cobra.NoArgs,
+		Run:  clusterExportRun,
+	}
+
+	clusterImportCmd = &cobra.Command{
+		Use:   "import <archive>",
+		Short: "Replace the cluster state with the content of an archive",
+		Long: `Replace the cluster state with the content of an archive.
+
+Hosts which are not part of the archive are removed. Use --dry-run to list
+the changes without applying them. Stop mayu before importing or use the
+/admin/cluster/import API endpoint instead.`,
+		Args: cobra.ExactArgs(1),
+		Run:  clusterImportRun,
+	}
+
+	clusterExportFlags = struct {
+		output string
+	}{}
+
+	clusterImportFlags = struct {
+		dryRun bool
+	}{}
+)
+
+func init() {
+	clusterExportCmd.Flags().StringVarP(&clusterExportFlags.output, "output", "o", "-", "File to write the archive to, - for stdout")
+	clusterImportCmd.Flags().BoolVar(&clusterImportFlags.dryRun, "dry-run", false, "List the changes of the import without applying them")
+
+	clusterCmd.AddCommand(clusterExportCmd)
+	clusterCmd.AddCommand(clusterImportCmd)
+	mainCmd.AddCommand(clusterCmd)
+}
+
+func clusterExportRun(cmd *cobra.Command, args []string) {
+	storage, err := hostmgr.NewStorage(globalFlags.storage, globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer storage.Close()
+
+	if !hostmgr.ClusterExists(storage) {
+		log.Fatalf("no cluster found in '%s' storage of %s", globalFlags.storage, globalFlags.clusterDir)
+	}
+
+	var w io.Writer = os.Stdout
+	if clusterExportFlags.output != "-" {
+		f, err := os.Create(clusterExportFlags.output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = hostmgr.ExportCluster(storage, w)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func clusterImportRun(cmd *cobra.Command, args []string) {
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	entries, manifest, err := hostmgr.ReadArchive(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lock, err := hostmgr.LockClusterDir(globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var diff hostmgr.ArchiveDiff
+	if clusterImportFlags.dryRun {
+		storage, err := hostmgr.NewStorage(globalFlags.storage, globalFlags.clusterDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer storage.Close()
+
+		diff, err = hostmgr.DiffImport(storage, entries, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		cluster, err := openCluster(globalFlags.storage, globalFlags.clusterDir, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		msg := fmt.Sprintf("import: import cluster archive of %s (by %s)", manifest.CreatedAt.Format(time.RFC3339), localActor())
+		diff, err = cluster.Import(entries, msg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	printArchiveDiff(diff)
+	if clusterImportFlags.dryRun {
+		log.Printf("dry run, no changes applied")
+	}
+}
+
+func printArchiveDiff(diff hostmgr.ArchiveDiff) {
+	if diff.Empty() {
+		fmt.Println("no changes")
+		return
+	}
+	for _, name := range diff.Added {
+		fmt.Printf("+ %s\n", name)
+	}
+	for _, name := range diff.Changed {
+		fmt.Printf("~ %s\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Printf("- %s\n", name)
+	}
+}
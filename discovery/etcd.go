@@ -0,0 +1,374 @@
+package discovery
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+)
+
+// EtcdConfig configures an EtcdRegistry.
+type EtcdConfig struct {
+	// Endpoints are the client URLs of the etcd cluster.
+	Endpoints []string
+	// CAFile verifies the certificates of etcd, if it is not signed by a
+	// trusted root CA.
+	CAFile string
+	// CertFile and KeyFile authenticate mayu using a client certificate.
+	CertFile string
+	KeyFile  string
+	// Username and Password authenticate mayu as etcd user.
+	Username string
+	Password string
+
+	Logger micrologger.Logger
+}
+
+// EtcdRegistry keeps the discovery registry in etcd using the v3 API. The
+// registry index is the etcd revision.
+type EtcdRegistry struct {
+	client *clientv3.Client
+	logger micrologger.Logger
+}
+
+// NewEtcdRegistry connects to the etcd cluster given by config.
+func NewEtcdRegistry(config EtcdConfig) (*EtcdRegistry, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, microerror.Maskf(invalidConfigError, "etcd endpoints must not be empty")
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "logger must not be empty")
+	}
+
+	clientConfig := clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: dialTimeout,
+		Username:    config.Username,
+		Password:    config.Password,
+	}
+	if config.CAFile != "" || config.CertFile != "" || config.KeyFile != "" {
+		if (config.CertFile == "") != (config.KeyFile == "") {
+			return nil, microerror.Maskf(invalidConfigError, "etcd client certificate and key must be given together")
+		}
+		tlsInfo := transport.TLSInfo{
+			CertFile:      config.CertFile,
+			KeyFile:       config.KeyFile,
+			TrustedCAFile: config.CAFile,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "etcd tls: %s", err)
+		}
+		clientConfig.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "connecting to etcd at %s: %s", strings.Join(config.Endpoints, ","), err)
+	}
+
+	r := &EtcdRegistry{
+		client: client,
+		logger: config.Logger,
+	}
+
+	return r, nil
+}
+
+func (r *EtcdRegistry) Tokens(ctx context.Context) ([]string, uint64, error) {
+	resp, err := r.client.Get(ctx, RegistryPrefix+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, 0, microerror.Mask(err)
+	}
+
+	tokens := []string{}
+	for _, kv := range resp.Kvs {
+		key := strings.TrimPrefix(string(kv.Key), RegistryPrefix+"/")
+		if strings.HasSuffix(key, "/"+SizeKey) {
+			tokens = append(tokens, strings.TrimSuffix(key, "/"+SizeKey))
+		}
+	}
+
+	return tokens, uint64(resp.Header.Revision), nil
+}
+
+func (r *EtcdRegistry) CreateToken(ctx context.Context, token string, size int) error {
+	key := sizeKey(token)
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, strconv.Itoa(size))).
+		Commit()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !resp.Succeeded {
+		return microerror.Maskf(alreadyExistsError, "token %s", token)
+	}
+
+	return nil
+}
+
+func (r *EtcdRegistry) DeleteToken(ctx context.Context, token string) error {
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(sizeKey(token)), ">", 0)).
+		Then(clientv3.OpDelete(tokenKey(token)+"/", clientv3.WithPrefix())).
+		Commit()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !resp.Succeeded {
+		return microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	return nil
+}
+
+func (r *EtcdRegistry) Size(ctx context.Context, token string) (Node, uint64, error) {
+	resp, err := r.client.Get(ctx, sizeKey(token))
+	if err != nil {
+		return Node{}, 0, microerror.Mask(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return Node{}, uint64(resp.Header.Revision), microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	return toNode(token, resp.Kvs[0]), uint64(resp.Header.Revision), nil
+}
+
+func (r *EtcdRegistry) SetSize(ctx context.Context, token string, size int) (Event, error) {
+	key := sizeKey(token)
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(clientv3.OpPut(key, strconv.Itoa(size), clientv3.WithPrevKV())).
+		Commit()
+	if err != nil {
+		return Event{}, microerror.Mask(err)
+	}
+	if !resp.Succeeded {
+		return Event{}, microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	return putEvent(token, key, strconv.Itoa(size), resp.Header.Revision, resp.Responses[0].GetResponsePut().PrevKv), nil
+}
+
+func (r *EtcdRegistry) Members(ctx context.Context, token string) ([]Node, uint64, error) {
+	resp, err := r.client.Txn(ctx).
+		Then(
+			clientv3.OpGet(sizeKey(token)),
+			clientv3.OpGet(tokenKey(token)+"/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend)),
+		).
+		Commit()
+	if err != nil {
+		return nil, 0, microerror.Mask(err)
+	}
+	if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+		return nil, uint64(resp.Header.Revision), microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	members := []Node{}
+	for _, kv := range resp.Responses[1].GetResponseRange().Kvs {
+		node := toNode(token, kv)
+		if isConfigKey(node.Key) {
+			continue
+		}
+		members = append(members, node)
+	}
+
+	return members, uint64(resp.Header.Revision), nil
+}
+
+func (r *EtcdRegistry) Member(ctx context.Context, token, id string) (Node, uint64, error) {
+	resp, err := r.client.Get(ctx, memberKey(token, id))
+	if err != nil {
+		return Node{}, 0, microerror.Mask(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return Node{}, uint64(resp.Header.Revision), microerror.Maskf(notFoundError, "member %s of token %s", id, token)
+	}
+
+	return toNode(token, resp.Kvs[0]), uint64(resp.Header.Revision), nil
+}
+
+func (r *EtcdRegistry) Register(ctx context.Context, token, id, value string, mustCreate bool) (Event, error) {
+	key := memberKey(token, id)
+	cmps := []clientv3.Cmp{
+		clientv3.Compare(clientv3.CreateRevision(sizeKey(token)), ">", 0),
+	}
+	if mustCreate {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	}
+
+	resp, err := r.client.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpPut(key, value, clientv3.WithPrevKV())).
+		Else(clientv3.OpGet(sizeKey(token))).
+		Commit()
+	if err != nil {
+		return Event{}, microerror.Mask(err)
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+			return Event{}, microerror.Maskf(notFoundError, "token %s", token)
+		}
+		return Event{}, microerror.Maskf(alreadyExistsError, "member %s of token %s", id, token)
+	}
+
+	return putEvent(token, key, value, resp.Header.Revision, resp.Responses[0].GetResponsePut().PrevKv), nil
+}
+
+func (r *EtcdRegistry) Unregister(ctx context.Context, token, id string) (Event, error) {
+	resp, err := r.client.Delete(ctx, memberKey(token, id), clientv3.WithPrevKV())
+	if err != nil {
+		return Event{}, microerror.Mask(err)
+	}
+	if len(resp.PrevKvs) == 0 {
+		return Event{}, microerror.Maskf(notFoundError, "member %s of token %s", id, token)
+	}
+
+	prev := toNode(token, resp.PrevKvs[0])
+	event := Event{
+		Action: ActionDelete,
+		Node: Node{
+			Key:           prev.Key,
+			CreatedIndex:  prev.CreatedIndex,
+			ModifiedIndex: uint64(resp.Header.Revision),
+		},
+		PrevNode: &prev,
+		Index:    uint64(resp.Header.Revision),
+	}
+
+	return event, nil
+}
+
+func (r *EtcdRegistry) Watch(ctx context.Context, token, id string, waitIndex uint64) (Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	key := tokenKey(token) + "/"
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if id != "" {
+		key = memberKey(token, id)
+	} else {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	if waitIndex > 0 {
+		opts = append(opts, clientv3.WithRev(int64(waitIndex)))
+	}
+
+	for resp := range r.client.Watch(ctx, key, opts...) {
+		if resp.CompactRevision != 0 {
+			return Event{}, microerror.Maskf(outdatedError, "index %d is outdated, the oldest index is %d", waitIndex, resp.CompactRevision)
+		}
+		if err := resp.Err(); err != nil {
+			return Event{}, microerror.Mask(err)
+		}
+
+		for _, ev := range resp.Events {
+			node := toNode(token, ev.Kv)
+			if isConfigKey(node.Key) {
+				continue
+			}
+
+			var prev *Node
+			if ev.PrevKv != nil {
+				n := toNode(token, ev.PrevKv)
+				prev = &n
+			}
+
+			event := Event{
+				Node:     node,
+				PrevNode: prev,
+				Index:    uint64(ev.Kv.ModRevision),
+			}
+			switch {
+			case ev.Type == mvccpb.DELETE:
+				event.Action = ActionDelete
+				event.Node.Value = ""
+				if prev != nil {
+					event.Node.CreatedIndex = prev.CreatedIndex
+				}
+			case ev.IsCreate():
+				event.Action = ActionCreate
+			default:
+				event.Action = ActionSet
+			}
+
+			return event, nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return Event{}, microerror.Mask(ctx.Err())
+	}
+	return Event{}, microerror.Maskf(executionFailedError, "watching token %s: watch closed", token)
+}
+
+func (r *EtcdRegistry) Close() error {
+	err := r.client.Close()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func tokenKey(token string) string {
+	return path.Join(RegistryPrefix, token)
+}
+
+func sizeKey(token string) string {
+	return path.Join(tokenKey(token), SizeKey)
+}
+
+func memberKey(token, id string) string {
+	return path.Join(tokenKey(token), id)
+}
+
+func isConfigKey(key string) bool {
+	return strings.HasPrefix(key, path.Dir(SizeKey)+"/")
+}
+
+// toNode converts the etcd key value given by kv to a node relative to the
+// token.
+func toNode(token string, kv *mvccpb.KeyValue) Node {
+	return Node{
+		Key:           strings.TrimPrefix(string(kv.Key), tokenKey(token)+"/"),
+		Value:         string(kv.Value),
+		CreatedIndex:  uint64(kv.CreateRevision),
+		ModifiedIndex: uint64(kv.ModRevision),
+	}
+}
+
+// putEvent describes setting key to value at revision rev. prev is the
+// key value before, if any.
+func putEvent(token, key, value string, rev int64, prev *mvccpb.KeyValue) Event {
+	event := Event{
+		Action: ActionCreate,
+		Node: Node{
+			Key:           strings.TrimPrefix(key, tokenKey(token)+"/"),
+			Value:         value,
+			CreatedIndex:  uint64(rev),
+			ModifiedIndex: uint64(rev),
+		},
+		Index: uint64(rev),
+	}
+	if prev != nil {
+		p := toNode(token, prev)
+		event.Action = ActionSet
+		event.Node.CreatedIndex = p.CreatedIndex
+		event.PrevNode = &p
+	}
+
+	return event
+}
@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+	"github.com/giantswarm/micrologger"
+)
+
+const testToken = "0123456789abcdef0123456789abcdef"
+
+// startEtcd starts an embedded etcd server and returns its client URL.
+func startEtcd(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "discovery_etcd_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, _ := url.Parse("http://127.0.0.1:0")
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LCUrls = []url.URL{*local}
+	cfg.ACUrls = []url.URL{*local}
+	cfg.LPUrls = []url.URL{*local}
+	cfg.APUrls = []url.URL{*local}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("starting etcd: %s", err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("timed out starting etcd")
+	}
+
+	return "http://" + e.Clients[0].Addr().String(), func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestRegistry(t *testing.T) (*EtcdRegistry, func()) {
+	endpoint, stop := startEtcd(t)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewEtcdRegistry(EtcdConfig{
+		Endpoints: []string{endpoint},
+		Logger:    logger,
+	})
+	if err != nil {
+		stop()
+		t.Fatalf("creating registry: %s", err)
+	}
+
+	return r, func() {
+		r.Close()
+		stop()
+	}
+}
+
+func TestEtcdRegistry(t *testing.T) {
+	r, cleanup := newTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if _, _, err := r.Size(ctx, testToken); !IsNotFound(err) {
+		t.Fatalf("expected unknown token not to be found, got %v", err)
+	}
+	if _, err := r.Register(ctx, testToken, "member-1", "a=http://10.0.0.1:2380", true); !IsNotFound(err) {
+		t.Fatalf("expected registering with unknown token to fail, got %v", err)
+	}
+
+	if err := r.CreateToken(ctx, testToken, 3); err != nil {
+		t.Fatalf("creating token: %s", err)
+	}
+	if err := r.CreateToken(ctx, testToken, 3); !IsAlreadyExists(err) {
+		t.Fatalf("expected duplicate token to be rejected, got %v", err)
+	}
+
+	size, _, err := r.Size(ctx, testToken)
+	if err != nil || size.Key != SizeKey || size.Value != "3" {
+		t.Fatalf("unexpected size %#v: %v", size, err)
+	}
+
+	created, err := r.Register(ctx, testToken, "member-1", "a=http://10.0.0.1:2380", true)
+	if err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+	if created.Action != ActionCreate || created.Node.Key != "member-1" || created.Node.CreatedIndex != created.Index {
+		t.Fatalf("unexpected event %#v", created)
+	}
+	if _, err := r.Register(ctx, testToken, "member-1", "a=http://10.0.0.1:2380", true); !IsAlreadyExists(err) {
+		t.Fatalf("expected duplicate member to be rejected, got %v", err)
+	}
+	if _, err := r.Register(ctx, testToken, "member-2", "b=http://10.0.0.2:2380", true); err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+
+	members, index, err := r.Members(ctx, testToken)
+	if err != nil {
+		t.Fatalf("listing members: %s", err)
+	}
+	if len(members) != 2 || members[0].Key != "member-1" || members[1].Key != "member-2" {
+		t.Fatalf("unexpected members %#v", members)
+	}
+
+	// waiting for the next change returns the registration of the third
+	// member
+	events := make(chan Event)
+	errs := make(chan error)
+	go func() {
+		event, err := r.Watch(ctx, testToken, "", index+1)
+		if err != nil {
+			errs <- err
+			return
+		}
+		events <- event
+	}()
+	if _, err := r.Register(ctx, testToken, "member-3", "c=http://10.0.0.3:2380", true); err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+	select {
+	case event := <-events:
+		if event.Action != ActionCreate || event.Node.Key != "member-3" || event.Node.Value != "c=http://10.0.0.3:2380" {
+			t.Fatalf("unexpected event %#v", event)
+		}
+	case err := <-errs:
+		t.Fatalf("watching token: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for member")
+	}
+
+	// past changes are returned right away
+	event, err := r.Watch(ctx, testToken, "member-1", created.Node.CreatedIndex)
+	if err != nil || event.Node.Key != "member-1" {
+		t.Fatalf("unexpected event %#v: %v", event, err)
+	}
+
+	changed, err := r.SetSize(ctx, testToken, 5)
+	if err != nil || changed.Action != ActionSet || changed.PrevNode.Value != "3" {
+		t.Fatalf("unexpected event %#v: %v", changed, err)
+	}
+
+	deleted, err := r.Unregister(ctx, testToken, "member-2")
+	if err != nil || deleted.Action != ActionDelete || deleted.PrevNode.Value != "b=http://10.0.0.2:2380" {
+		t.Fatalf("unexpected event %#v: %v", deleted, err)
+	}
+	if _, _, err := r.Member(ctx, testToken, "member-2"); !IsNotFound(err) {
+		t.Fatalf("expected removed member not to be found, got %v", err)
+	}
+	if _, err := r.Unregister(ctx, testToken, "member-2"); !IsNotFound(err) {
+		t.Fatalf("expected removing unknown member to fail, got %v", err)
+	}
+
+	tokens, _, err := r.Tokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0] != testToken {
+		t.Fatalf("unexpected tokens %#v: %v", tokens, err)
+	}
+	if err := r.DeleteToken(ctx, testToken); err != nil {
+		t.Fatalf("deleting token: %s", err)
+	}
+	if _, _, err := r.Members(ctx, testToken); !IsNotFound(err) {
+		t.Fatalf("expected deleted token not to be found, got %v", err)
+	}
+	if err := r.DeleteToken(ctx, testToken); !IsNotFound(err) {
+		t.Fatalf("expected deleting unknown token to fail, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Package discovery keeps the registry of the internal etcd discovery. Booting
+// etcd members register themselves below a discovery token and wait for the
+// other members of their cluster using the etcd v2 discovery protocol, which
+// pxemgr serves on top of a Registry.
+package discovery
+
+import (
+	"context"
+)
+
+const (
+	// RegistryPrefix is the prefix of the keys reported by the discovery
+	// protocol. The etcd registry keeps its keys below the prefix as well,
+	// like the etcd v2 keys API formerly accessed by mayu did.
+	RegistryPrefix = "/_etcd/registry"
+
+	// SizeKey is the key of the cluster size below a token.
+	SizeKey = "_config/size"
+
+	// ActionGet, ActionCreate, ActionSet and ActionDelete are the actions
+	// reported by the etcd v2 discovery protocol.
+	ActionGet    = "get"
+	ActionCreate = "create"
+	ActionSet    = "set"
+	ActionDelete = "delete"
+)
+
+// Node is a key below a discovery token, ie. the cluster size or a member.
+// Keys are relative to the token.
+type Node struct {
+	Key           string
+	Value         string
+	CreatedIndex  uint64
+	ModifiedIndex uint64
+}
+
+// Event describes a change of a node.
+type Event struct {
+	Action   string
+	Node     Node
+	PrevNode *Node
+	// Index is the registry index after the change.
+	Index uint64
+}
+
+// Registry keeps discovery tokens together with the cluster size and the
+// members registered below them. Every change increments the index of the
+// registry. Lookups return the current index.
+type Registry interface {
+	// Tokens returns all tokens of the registry in lexical order.
+	Tokens(ctx context.Context) ([]string, uint64, error)
+	// CreateToken adds a token for a cluster of the given size. In case the
+	// token exists, an error asserted by IsAlreadyExists is returned.
+	CreateToken(ctx context.Context, token string, size int) error
+	// DeleteToken removes the token together with its members.
+	DeleteToken(ctx context.Context, token string) error
+	// Size returns the size node of the token.
+	Size(ctx context.Context, token string) (Node, uint64, error)
+	// SetSize changes the cluster size of the token.
+	SetSize(ctx context.Context, token string, size int) (Event, error)
+	// Members returns the members registered below the token, ordered by
+	// their registration.
+	Members(ctx context.Context, token string) ([]Node, uint64, error)
+	// Member returns the member given by id.
+	Member(ctx context.Context, token, id string) (Node, uint64, error)
+	// Register sets the value of the member given by id. In case mustCreate
+	// is true and the member exists, an error asserted by IsAlreadyExists is
+	// returned.
+	Register(ctx context.Context, token, id, value string, mustCreate bool) (Event, error)
+	// Unregister removes the member given by id.
+	Unregister(ctx context.Context, token, id string) (Event, error)
+	// Watch blocks until a member of the token, or the member given by id if
+	// not empty, changes at or after waitIndex and returns the change. A
+	// waitIndex of 0 waits for the next change.
+	Watch(ctx context.Context, token, id string, waitIndex uint64) (Event, error)
+	// Close releases the resources of the registry.
+	Close() error
+}
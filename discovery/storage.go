@@ -0,0 +1,452 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+const (
+	// registryFile keeps the whole registry, so every change is stored
+	// atomically.
+	registryFile = "registry.json"
+
+	// historySize is the number of events kept to answer waitIndex
+	// requests, like etcd v2 does.
+	historySize = 1000
+)
+
+// StorageConfig configures a StorageRegistry.
+type StorageConfig struct {
+	// Storage is the cluster storage keeping the registry below
+	// hostmgr.DiscoveryDir.
+	Storage hostmgr.Storage
+
+	Logger micrologger.Logger
+}
+
+// StorageRegistry keeps the discovery registry within the cluster storage of
+// mayu, so no etcd is needed to run the internal etcd discovery. The registry
+// is held in memory and written to the storage on every change.
+type StorageRegistry struct {
+	storage hostmgr.Storage
+	logger  micrologger.Logger
+
+	// mu guards all fields below
+	mu    sync.Mutex
+	state storageState
+	// history keeps the latest events, oldest first. Events before
+	// historyStart are unknown, eg. because they happened before mayu
+	// started.
+	history      []tokenEvent
+	historyStart uint64
+	// changed is closed and replaced on every change to wake up watchers.
+	changed chan struct{}
+}
+
+// storageState is the content of the registry file.
+type storageState struct {
+	Index  uint64
+	Tokens map[string]*storageToken
+}
+
+type storageToken struct {
+	Size Node
+	// Members are ordered by their registration.
+	Members []Node
+}
+
+type tokenEvent struct {
+	token string
+	event Event
+}
+
+// NewStorageRegistry reads the registry from config.Storage.
+func NewStorageRegistry(config StorageConfig) (*StorageRegistry, error) {
+	if config.Storage == nil {
+		return nil, microerror.Maskf(invalidConfigError, "storage must not be empty")
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "logger must not be empty")
+	}
+
+	r := &StorageRegistry{
+		storage: config.Storage,
+		logger:  config.Logger,
+		changed: make(chan struct{}),
+	}
+
+	err := r.load()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	r.historyStart = r.state.Index + 1
+
+	return r, nil
+}
+
+func (r *StorageRegistry) Tokens(ctx context.Context) ([]string, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tokens := []string{}
+	for token := range r.state.Tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	return tokens, r.state.Index, nil
+}
+
+func (r *StorageRegistry) CreateToken(ctx context.Context, token string, size int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.state.Tokens[token]; exists {
+		return microerror.Maskf(alreadyExistsError, "token %s", token)
+	}
+
+	index := r.state.Index + 1
+	node := Node{
+		Key:           SizeKey,
+		Value:         strconv.Itoa(size),
+		CreatedIndex:  index,
+		ModifiedIndex: index,
+	}
+	r.state.Tokens[token] = &storageToken{
+		Size:    node,
+		Members: []Node{},
+	}
+
+	_, err := r.commit(token, Event{Action: ActionCreate, Node: node, Index: index})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (r *StorageRegistry) DeleteToken(ctx context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.state.Tokens[token]; !exists {
+		return microerror.Maskf(notFoundError, "token %s", token)
+	}
+	delete(r.state.Tokens, token)
+
+	// the token itself is reported as removed node, like etcd v2 does for
+	// directories
+	index := r.state.Index + 1
+	_, err := r.commit(token, Event{
+		Action: ActionDelete,
+		Node:   Node{ModifiedIndex: index},
+		Index:  index,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (r *StorageRegistry) Size(ctx context.Context, token string) (Node, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, exists := r.state.Tokens[token]
+	if !exists {
+		return Node{}, r.state.Index, microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	return t.Size, r.state.Index, nil
+}
+
+func (r *StorageRegistry) SetSize(ctx context.Context, token string, size int) (Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, exists := r.state.Tokens[token]
+	if !exists {
+		return Event{}, microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	index := r.state.Index + 1
+	prev := t.Size
+	t.Size.Value = strconv.Itoa(size)
+	t.Size.ModifiedIndex = index
+
+	event, err := r.commit(token, Event{Action: ActionSet, Node: t.Size, PrevNode: &prev, Index: index})
+	if err != nil {
+		return Event{}, microerror.Mask(err)
+	}
+
+	return event, nil
+}
+
+func (r *StorageRegistry) Members(ctx context.Context, token string) ([]Node, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, exists := r.state.Tokens[token]
+	if !exists {
+		return nil, r.state.Index, microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	members := make([]Node, len(t.Members))
+	copy(members, t.Members)
+
+	return members, r.state.Index, nil
+}
+
+func (r *StorageRegistry) Member(ctx context.Context, token, id string) (Node, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, exists := r.state.Tokens[token]; exists {
+		for _, member := range t.Members {
+			if member.Key == id {
+				return member, r.state.Index, nil
+			}
+		}
+	}
+
+	return Node{}, r.state.Index, microerror.Maskf(notFoundError, "member %s of token %s", id, token)
+}
+
+func (r *StorageRegistry) Register(ctx context.Context, token, id, value string, mustCreate bool) (Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, exists := r.state.Tokens[token]
+	if !exists {
+		return Event{}, microerror.Maskf(notFoundError, "token %s", token)
+	}
+
+	index := r.state.Index + 1
+	event := Event{
+		Action: ActionCreate,
+		Node: Node{
+			Key:           id,
+			Value:         value,
+			CreatedIndex:  index,
+			ModifiedIndex: index,
+		},
+		Index: index,
+	}
+
+	found := false
+	for i, member := range t.Members {
+		if member.Key != id {
+			continue
+		}
+		if mustCreate {
+			return Event{}, microerror.Maskf(alreadyExistsError, "member %s of token %s", id, token)
+		}
+		prev := member
+		event.Action = ActionSet
+		event.Node.CreatedIndex = prev.CreatedIndex
+		event.PrevNode = &prev
+		t.Members[i] = event.Node
+		found = true
+	}
+	if !found {
+		t.Members = append(t.Members, event.Node)
+	}
+
+	event, err := r.commit(token, event)
+	if err != nil {
+		return Event{}, microerror.Mask(err)
+	}
+
+	return event, nil
+}
+
+func (r *StorageRegistry) Unregister(ctx context.Context, token, id string) (Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, exists := r.state.Tokens[token]
+	if !exists {
+		return Event{}, microerror.Maskf(notFoundError, "member %s of token %s", id, token)
+	}
+
+	for i, member := range t.Members {
+		if member.Key != id {
+			continue
+		}
+
+		index := r.state.Index + 1
+		prev := member
+		t.Members = append(t.Members[:i], t.Members[i+1:]...)
+
+		event, err := r.commit(token, Event{
+			Action: ActionDelete,
+			Node: Node{
+				Key:           id,
+				CreatedIndex:  prev.CreatedIndex,
+				ModifiedIndex: index,
+			},
+			PrevNode: &prev,
+			Index:    index,
+		})
+		if err != nil {
+			return Event{}, microerror.Mask(err)
+		}
+
+		return event, nil
+	}
+
+	return Event{}, microerror.Maskf(notFoundError, "member %s of token %s", id, token)
+}
+
+func (r *StorageRegistry) Watch(ctx context.Context, token, id string, waitIndex uint64) (Event, error) {
+	r.mu.Lock()
+	if waitIndex == 0 {
+		waitIndex = r.state.Index + 1
+	}
+	r.mu.Unlock()
+
+	for {
+		r.mu.Lock()
+		event, found := r.findEvent(token, id, waitIndex)
+		changed := r.changed
+		r.mu.Unlock()
+
+		if found {
+			return event, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Event{}, microerror.Mask(ctx.Err())
+		}
+	}
+}
+
+func (r *StorageRegistry) Close() error {
+	return nil
+}
+
+// findEvent returns the first change of the watched nodes at or after
+// waitIndex. Members changed before the history starts are reported using
+// their current state, as the events are gone. Members removed in the
+// meantime are thus missed. The caller must hold mu.
+func (r *StorageRegistry) findEvent(token, id string, waitIndex uint64) (Event, bool) {
+	watched := func(key string) bool {
+		if id != "" {
+			return key == id
+		}
+		return !isConfigKey(key)
+	}
+
+	if waitIndex < r.historyStart {
+		if t, exists := r.state.Tokens[token]; exists {
+			var oldest *Node
+			for i, member := range t.Members {
+				if !watched(member.Key) || member.ModifiedIndex < waitIndex || member.ModifiedIndex >= r.historyStart {
+					continue
+				}
+				if oldest == nil || member.ModifiedIndex < oldest.ModifiedIndex {
+					oldest = &t.Members[i]
+				}
+			}
+			if oldest != nil {
+				event := Event{
+					Action: ActionSet,
+					Node:   *oldest,
+					Index:  oldest.ModifiedIndex,
+				}
+				if oldest.CreatedIndex == oldest.ModifiedIndex {
+					event.Action = ActionCreate
+				}
+				return event, true
+			}
+		}
+	}
+
+	for _, e := range r.history {
+		if e.token == token && e.event.Index >= waitIndex && watched(e.event.Node.Key) {
+			return e.event, true
+		}
+	}
+
+	return Event{}, false
+}
+
+// commit stores the registry after the in-memory state got changed by event
+// and notifies watchers. In case the registry cannot be stored, the previous
+// state is read back. The caller must hold mu.
+func (r *StorageRegistry) commit(token string, event Event) (Event, error) {
+	r.state.Index = event.Index
+
+	err := r.save()
+	if err != nil {
+		loadErr := r.load()
+		if loadErr != nil {
+			_ = r.logger.Log("level", "error", "message", "unable to restore etcd discovery registry", "stack", loadErr)
+		}
+		return Event{}, microerror.Mask(err)
+	}
+
+	r.history = append(r.history, tokenEvent{token: token, event: event})
+	if len(r.history) > historySize {
+		r.historyStart = r.history[0].event.Index + 1
+		r.history = r.history[1:]
+	}
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	return event, nil
+}
+
+func (r *StorageRegistry) load() error {
+	state := storageState{}
+
+	data, err := r.storage.Get(registryKey())
+	if hostmgr.IsNotFound(err) {
+		// no token created yet
+	} else if err != nil {
+		return microerror.Mask(err)
+	} else {
+		err = json.Unmarshal(data, &state)
+		if err != nil {
+			return microerror.Maskf(executionFailedError, "decoding %s: %s", registryKey(), err)
+		}
+	}
+	if state.Tokens == nil {
+		state.Tokens = map[string]*storageToken{}
+	}
+
+	r.state = state
+
+	return nil
+}
+
+func (r *StorageRegistry) save() error {
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = r.storage.Put(registryKey(), data)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func registryKey() string {
+	return path.Join(hostmgr.DiscoveryDir, registryFile)
+}
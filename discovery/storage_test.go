@@ -0,0 +1,163 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func newTestStorageRegistry(t *testing.T, storage hostmgr.Storage) *StorageRegistry {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewStorageRegistry(StorageConfig{
+		Storage: storage,
+		Logger:  logger,
+	})
+	if err != nil {
+		t.Fatalf("creating registry: %s", err)
+	}
+
+	return r
+}
+
+func TestStorageRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery_storage_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := hostmgr.NewDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestStorageRegistry(t, storage)
+	ctx := context.Background()
+
+	if _, _, err := r.Size(ctx, testToken); !IsNotFound(err) {
+		t.Fatalf("expected unknown token not to be found, got %v", err)
+	}
+	if _, err := r.Register(ctx, testToken, "member-1", "a=http://10.0.0.1:2380", true); !IsNotFound(err) {
+		t.Fatalf("expected registering with unknown token to fail, got %v", err)
+	}
+
+	if err := r.CreateToken(ctx, testToken, 3); err != nil {
+		t.Fatalf("creating token: %s", err)
+	}
+	if err := r.CreateToken(ctx, testToken, 3); !IsAlreadyExists(err) {
+		t.Fatalf("expected duplicate token to be rejected, got %v", err)
+	}
+
+	created, err := r.Register(ctx, testToken, "member-1", "a=http://10.0.0.1:2380", true)
+	if err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+	if created.Action != ActionCreate || created.Node.Key != "member-1" || created.Node.CreatedIndex != created.Index {
+		t.Fatalf("unexpected event %#v", created)
+	}
+	if _, err := r.Register(ctx, testToken, "member-1", "a=http://10.0.0.1:2380", true); !IsAlreadyExists(err) {
+		t.Fatalf("expected duplicate member to be rejected, got %v", err)
+	}
+	if _, err := r.Register(ctx, testToken, "member-2", "b=http://10.0.0.2:2380", true); err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+
+	members, index, err := r.Members(ctx, testToken)
+	if err != nil {
+		t.Fatalf("listing members: %s", err)
+	}
+	if len(members) != 2 || members[0].Key != "member-1" || members[1].Key != "member-2" {
+		t.Fatalf("unexpected members %#v", members)
+	}
+
+	// waiting for the next change returns the registration of the third
+	// member
+	events := make(chan Event)
+	errs := make(chan error)
+	go func() {
+		event, err := r.Watch(ctx, testToken, "", 0)
+		if err != nil {
+			errs <- err
+			return
+		}
+		events <- event
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := r.Register(ctx, testToken, "member-3", "c=http://10.0.0.3:2380", true); err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+	select {
+	case event := <-events:
+		if event.Action != ActionCreate || event.Node.Key != "member-3" || event.Index != index+1 {
+			t.Fatalf("unexpected event %#v", event)
+		}
+	case err := <-errs:
+		t.Fatalf("watching token: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for member")
+	}
+
+	// waiting times out in case nothing changes
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := r.Watch(timeoutCtx, testToken, "", index+2); err == nil {
+		t.Fatal("expected waiting for a future change to time out")
+	}
+
+	deleted, err := r.Unregister(ctx, testToken, "member-2")
+	if err != nil || deleted.Action != ActionDelete || deleted.PrevNode.Value != "b=http://10.0.0.2:2380" {
+		t.Fatalf("unexpected event %#v: %v", deleted, err)
+	}
+	if _, _, err := r.Member(ctx, testToken, "member-2"); !IsNotFound(err) {
+		t.Fatalf("expected removed member not to be found, got %v", err)
+	}
+	event, err := r.Watch(ctx, testToken, "member-2", index+1)
+	if err != nil || event.Action != ActionDelete || event.Index != deleted.Index {
+		t.Fatalf("unexpected event %#v: %v", event, err)
+	}
+
+	changed, err := r.SetSize(ctx, testToken, 5)
+	if err != nil || changed.Action != ActionSet || changed.PrevNode.Value != "3" {
+		t.Fatalf("unexpected event %#v: %v", changed, err)
+	}
+
+	// the registry survives a restart, past changes of members are reported
+	// using their current state
+	r = newTestStorageRegistry(t, storage)
+
+	size, current, err := r.Size(ctx, testToken)
+	if err != nil || size.Value != "5" || current != changed.Index {
+		t.Fatalf("unexpected size %#v at %d: %v", size, current, err)
+	}
+	members, _, err = r.Members(ctx, testToken)
+	if err != nil || len(members) != 2 || members[0].Key != "member-1" || members[1].Key != "member-3" {
+		t.Fatalf("unexpected members %#v: %v", members, err)
+	}
+	event, err = r.Watch(ctx, testToken, "", created.Index+1)
+	if err != nil || event.Node.Key != "member-3" {
+		t.Fatalf("unexpected event %#v: %v", event, err)
+	}
+
+	tokens, _, err := r.Tokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0] != testToken {
+		t.Fatalf("unexpected tokens %#v: %v", tokens, err)
+	}
+	if err := r.DeleteToken(ctx, testToken); err != nil {
+		t.Fatalf("deleting token: %s", err)
+	}
+	if _, _, err := r.Members(ctx, testToken); !IsNotFound(err) {
+		t.Fatalf("expected deleted token not to be found, got %v", err)
+	}
+	if err := r.DeleteToken(ctx, testToken); !IsNotFound(err) {
+		t.Fatalf("expected deleting unknown token to fail, got %v", err)
+	}
+}
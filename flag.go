@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"time"
 
 	"github.com/giantswarm/mayu/fs"
 )
@@ -9,6 +10,7 @@ import (
 const (
 	DefaultConfigFile               string = "/etc/mayu/config.yaml"
 	DefaultClusterDirectory         string = "cluster"
+	DefaultStorage                  string = "dir"
 	DefaultShowTemplates            bool   = false
 	DefaultNoGit                    bool   = false
 	DefaultNoTLS                    bool   = false
@@ -26,13 +28,23 @@ const (
 	DefaultTLSCertFile              string = ""
 	DefaultTLSKeyFile               string = ""
 	DefaultUseInternalEtcdDiscovery bool   = true
+	DefaultEtcdDiscoveryBackend     string = "embedded"
 	DefaultEtcdQuorumSize           int    = 3
 	DefaultEtcdDiscoveryUrl         string = ""
 	DefaultEtcdEndpoint             string = "http://127.0.0.1:2379"
 	DefaultEtcdCA                   string = ""
+	DefaultEtcdCertFile             string = ""
+	DefaultEtcdKeyFile              string = ""
+	DefaultEtcdUsername             string = ""
+	DefaultEtcdPassword             string = ""
 	DefaultFlatcarAutologin         bool   = false
 	DefaultConsoleTTY               bool   = false
 	DefaultSystemdShell             bool   = false
+	DefaultSecretsKeyFile           string = ""
+	DefaultWatchConfig              bool   = false
+	DefaultURLSigningKeyFile        string = ""
+	DefaultURLTokenTTL                     = time.Hour
+	DefaultPreregisteredOnly        bool   = false
 )
 
 type MayuFlags struct {
@@ -42,6 +54,7 @@ type MayuFlags struct {
 
 	configFile               string
 	clusterDir               string
+	storage                  string
 	showTemplates            bool
 	noGit                    bool
 	noTLS                    bool
@@ -60,21 +73,32 @@ type MayuFlags struct {
 	tlsCertFile              string
 	tlsKeyFile               string
 	useInternalEtcdDiscovery bool
+	etcdDiscoveryBackend     string
 	etcdQuorumSize           int
 	etcdDiscoveryUrl         string
 	etcdEndpoint             string
 	etcdCAfile               string
+	etcdCertFile             string
+	etcdKeyFile              string
+	etcdUsername             string
+	etcdPassword             string
 	flatcarAutologin         bool
 	consoleTTY               bool
 	systemdShell             bool
+	secretsKeyFile           string
+	watchConfig              bool
+	urlSigningKeyFile        string
+	urlTokenTTL              time.Duration
+	preregisteredOnly        bool
 
 	filesystem fs.FileSystem // internal filesystem abstraction to enable testing of file operations.
 }
 
 var (
-	ErrNotAllCertFilesProvided = errors.New("Please configure a key and cert files for TLS connections.")
-	ErrHTTPSCertFileNotRedable = errors.New("Cannot open configured certificate file for TLS connections.")
-	ErrHTTPSKeyFileNotReadable = errors.New("Cannot open configured key file for TLS connections.")
+	ErrNotAllCertFilesProvided     = errors.New("Please configure a key and cert files for TLS connections.")
+	ErrHTTPSCertFileNotRedable     = errors.New("Cannot open configured certificate file for TLS connections.")
+	ErrHTTPSKeyFileNotReadable     = errors.New("Cannot open configured key file for TLS connections.")
+	ErrNotAllEtcdCertFilesProvided = errors.New("Please configure both a key and cert file for the etcd client certificate.")
 )
 
 // Validate checks the configuration based on all Validate* functions
@@ -88,6 +112,10 @@ func (g MayuFlags) Validate() (bool, error) {
 		return ok, err
 	}
 
+	if ok, err := g.ValidateEtcdCertificateUsage(); !ok {
+		return ok, err
+	}
+
 	return true, nil
 }
 
@@ -125,3 +153,14 @@ func (g MayuFlags) ValidateHTTPCertificateFileExistance() (bool, error) {
 
 	return true, nil
 }
+
+// ValidateEtcdCertificateUsage checks if the fields etcdCertFile and
+// etcdKeyFile of the configuration struct are either both set or both empty,
+// as a client certificate is useless without its key.
+func (g MayuFlags) ValidateEtcdCertificateUsage() (bool, error) {
+	if (g.etcdCertFile == "") != (g.etcdKeyFile == "") {
+		return false, ErrNotAllEtcdCertFilesProvided
+	}
+
+	return true, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+	"github.com/spf13/cobra"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+var (
+	hostCmd = &cobra.Command{
+		Use:   "host",
+		Short: "Inspect the history of hosts within the cluster directory",
+		Long: `Inspect the history of hosts within the cluster directory.
+
+These commands operate on the cluster directory directly. While mayu is
+running, use the corresponding API endpoints instead.`,
+	}
+
+	hostHistoryCmd = &cobra.Command{
+		Use:   "history <serial>",
+		Short: "List the recorded revisions of a host",
+		Args:  cobra.ExactArgs(1),
+		Run:   hostHistoryRun,
+	}
+
+	hostDiffCmd = &cobra.Command{
+		Use:   "diff <serial> <from> [<to>]",
+		Short: "Show the changes of a host between two revisions",
+		Args:  cobra.RangeArgs(2, 3),
+		Run:   hostDiffRun,
+	}
+
+	hostRollbackCmd = &cobra.Command{
+		Use:   "rollback <serial> <revision>",
+		Short: "Restore a host as it was at the given revision",
+		Args:  cobra.ExactArgs(2),
+		Run:   hostRollbackRun,
+	}
+)
+
+func init() {
+	hostCmd.AddCommand(hostHistoryCmd)
+	hostCmd.AddCommand(hostDiffCmd)
+	hostCmd.AddCommand(hostRollbackCmd)
+	mainCmd.AddCommand(hostCmd)
+}
+
+func hostHistoryRun(cmd *cobra.Command, args []string) {
+	cluster, lock := openHistoryCluster()
+	defer lock.Unlock()
+
+	revisions, err := cluster.HostHistory(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tDATE\tMESSAGE")
+	for _, r := range revisions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.ID[:12], r.Date.Format(time.RFC3339), r.Message)
+	}
+	_ = w.Flush()
+}
+
+func hostDiffRun(cmd *cobra.Command, args []string) {
+	cluster, lock := openHistoryCluster()
+	defer lock.Unlock()
+
+	to := ""
+	if len(args) == 3 {
+		to = args[2]
+	}
+
+	diff, err := cluster.HostDiff(args[0], args[1], to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	os.Stdout.WriteString(diff)
+}
+
+func hostRollbackRun(cmd *cobra.Command, args []string) {
+	cluster, lock := openHistoryCluster()
+	defer lock.Unlock()
+
+	msg := fmt.Sprintf("rollback: roll back host %s to revision %s (by %s)", args[0], args[1], localActor())
+	err := cluster.RollbackHost(args[0], args[1], msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("rolled back host %s to revision %s", args[0], args[1])
+}
+
+// openHistoryCluster locks and opens the cluster directory. Opening the
+// cluster may migrate its schema and quarantine corrupt hosts, so even reading
+// the history must not race with a running mayu.
+func openHistoryCluster() (*hostmgr.Cluster, *hostmgr.ClusterLock) {
+	if globalFlags.noGit {
+		log.Fatal("git history is disabled using --no-git")
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lock, err := hostmgr.LockClusterDir(globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage, err := hostmgr.NewDirStorage(globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !hostmgr.ClusterExists(storage) {
+		log.Fatalf("no cluster found in %s", globalFlags.clusterDir)
+	}
+
+	cluster, err := hostmgr.OpenClusterWithStorage(storage, true, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return cluster, lock
+}
+
+// localActor names the user running a command within the cluster history.
+func localActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "cli"
+}
@@ -0,0 +1,360 @@
+package hostmgr
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+)
+
+const (
+	// ArchiveVersion is the version of the archive format written by
+	// ExportCluster. Archives of newer versions are rejected.
+	ArchiveVersion = 1
+
+	archiveManifestFile = "manifest.json"
+)
+
+// ArchiveManifest describes the content of a cluster archive. It is the first
+// entry of every archive.
+type ArchiveManifest struct {
+	Version   int
+	CreatedAt time.Time
+	Files     []ArchiveFile
+}
+
+// ArchiveFile describes a single storage entry within a cluster archive.
+type ArchiveFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// ArchiveDiff lists the storage entries an import would add, change or
+// remove.
+type ArchiveDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// Empty checks whether applying the diff would not change anything.
+func (d ArchiveDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// ExportCluster writes all entries of the given storage as gzipped tar
+// archive to w. Entries used internally, like quarantined hosts, are not
+// exported.
+func ExportCluster(storage Storage, w io.Writer) error {
+	entries, err := archiveEntries(storage)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	manifest := ArchiveManifest{
+		Version:   ArchiveVersion,
+		CreatedAt: time.Now().UTC(),
+	}
+	names := sortedKeys(entries)
+	for _, name := range names {
+		sum := sha256.Sum256(entries[name])
+		manifest.Files = append(manifest.Files, ArchiveFile{
+			Name:   name,
+			Size:   int64(len(entries[name])),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	manifestData, err := marshalJson(manifest)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err = writeTarFile(tw, archiveManifestFile, manifestData, manifest.CreatedAt)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	for _, name := range names {
+		err = writeTarFile(tw, name, entries[name], manifest.CreatedAt)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// ReadArchive reads a cluster archive written by ExportCluster and returns
+// its entries. Every entry is verified against the manifest. In case the
+// archive is damaged, incomplete or of an unsupported version, an error
+// asserted by IsInvalidFormat is returned.
+func ReadArchive(r io.Reader) (map[string][]byte, *ArchiveManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, microerror.Maskf(invalidFormatError, "archive: %s", err)
+	}
+	defer gr.Close()
+
+	var manifest *ArchiveManifest
+	entries := map[string][]byte{}
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive: %s", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive entry '%s' is not a regular file", hdr.Name)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive entry '%s': %s", hdr.Name, err)
+		}
+
+		if manifest == nil {
+			if hdr.Name != archiveManifestFile {
+				return nil, nil, microerror.Maskf(invalidFormatError, "archive does not start with %s", archiveManifestFile)
+			}
+			manifest = &ArchiveManifest{}
+			err = unmarshalJson(archiveManifestFile, data, manifest)
+			if err != nil {
+				return nil, nil, microerror.Mask(err)
+			}
+			if manifest.Version < 1 || manifest.Version > ArchiveVersion {
+				return nil, nil, microerror.Maskf(invalidFormatError, "archive version %d is not supported, expected version %d or older", manifest.Version, ArchiveVersion)
+			}
+			continue
+		}
+
+		if !isArchiveKey(hdr.Name) {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive entry '%s' has an invalid name", hdr.Name)
+		}
+		if _, exists := entries[hdr.Name]; exists {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive entry '%s' is duplicated", hdr.Name)
+		}
+		entries[hdr.Name] = data
+	}
+
+	if manifest == nil {
+		return nil, nil, microerror.Maskf(invalidFormatError, "archive is empty")
+	}
+
+	if len(manifest.Files) != len(entries) {
+		return nil, nil, microerror.Maskf(invalidFormatError, "archive holds %d entries, but manifest lists %d", len(entries), len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		data, exists := entries[f.Name]
+		if !exists {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive entry '%s' is missing", f.Name)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, microerror.Maskf(invalidFormatError, "archive entry '%s' does not match its checksum", f.Name)
+		}
+	}
+
+	if _, exists := entries[clusterConfFile]; !exists {
+		return nil, nil, microerror.Maskf(invalidFormatError, "archive holds no %s", clusterConfFile)
+	}
+	for name, data := range entries {
+		var v interface{}
+		err = unmarshalJson(name, data, &v)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+	}
+
+	return entries, manifest, nil
+}
+
+// DiffArchive compares the entries of an archive with the given storage.
+func DiffArchive(storage Storage, entries map[string][]byte) (ArchiveDiff, error) {
+	diff := ArchiveDiff{
+		Added:   []string{},
+		Changed: []string{},
+		Removed: []string{},
+	}
+
+	current, err := archiveEntries(storage)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	for _, name := range sortedKeys(entries) {
+		value, exists := current[name]
+		if !exists {
+			diff.Added = append(diff.Added, name)
+		} else if !bytes.Equal(value, entries[name]) {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for _, name := range sortedKeys(current) {
+		if _, exists := entries[name]; !exists {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	return diff, nil
+}
+
+// DiffImport returns the changes importing the entries of an archive into the
+// given storage makes, see Cluster.Import. Unlike DiffArchive, the entries are
+// migrated to the current schema versions first, like they are when being
+// imported. Neither the entries nor the storage are changed.
+func DiffImport(storage Storage, entries map[string][]byte, logger micrologger.Logger) (ArchiveDiff, error) {
+	entries, err := migrateArchive(entries, logger)
+	if err != nil {
+		return ArchiveDiff{}, microerror.Mask(err)
+	}
+
+	diff, err := DiffArchive(storage, entries)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	return diff, nil
+}
+
+// migrateArchive returns the entries of an archive with cluster.json and the
+// host conf.json files upgraded to the current schema versions, since
+// archives of older versions of mayu hold older schema versions. The given
+// entries are not changed.
+func migrateArchive(entries map[string][]byte, logger micrologger.Logger) (map[string][]byte, error) {
+	migrated, err := migrateEntries(entries, logger)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if len(migrated) == 0 {
+		return entries, nil
+	}
+
+	upgraded := make(map[string][]byte, len(entries))
+	for key, value := range entries {
+		upgraded[key] = value
+	}
+	for key, value := range migrated {
+		upgraded[key] = value
+	}
+
+	return upgraded, nil
+}
+
+// ImportCluster replaces the content of the given storage with the entries of
+// an archive. Entries which are not part of the archive are removed.
+func ImportCluster(storage Storage, entries map[string][]byte) (ArchiveDiff, error) {
+	diff, err := DiffArchive(storage, entries)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	changes := map[string][]byte{}
+	for _, name := range append(diff.Added, diff.Changed...) {
+		changes[name] = entries[name]
+	}
+	err = storage.Batch(changes)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	for _, name := range diff.Removed {
+		err = storage.Delete(name)
+		if err != nil {
+			return diff, microerror.Mask(err)
+		}
+	}
+
+	return diff, nil
+}
+
+// archiveEntries returns all entries of the storage that are part of an
+// archive.
+func archiveEntries(storage Storage) (map[string][]byte, error) {
+	keys, err := storage.Keys()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	entries := map[string][]byte{}
+	for _, key := range keys {
+		if !isArchiveKey(key) {
+			continue
+		}
+		value, err := storage.Get(key)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		entries[key] = value
+	}
+
+	return entries, nil
+}
+
+// isArchiveKey checks whether key is a clean, relative key of a JSON entry
+// outside of the directories used internally.
+func isArchiveKey(key string) bool {
+	if key == "" || path.Clean(key) != key || path.IsAbs(key) || path.Ext(key) != ".json" {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	_, err = tw.Write(data)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func sortedKeys(entries map[string][]byte) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
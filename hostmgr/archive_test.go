@@ -0,0 +1,211 @@
+package hostmgr
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func TestArchive(t *testing.T) {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, cleanupSource := newTestStorage(t, StorageDir)
+	defer cleanupSource()
+
+	cluster, err := NewClusterWithStorage(source, false, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+	cluster.Config.DefaultEtcdClusterToken = "token"
+	if err := cluster.Commit("set token"); err != nil {
+		t.Fatal(err)
+	}
+	for _, serial := range []string{"serial-1", "serial-2"} {
+		if _, err := cluster.CreateNewHost(serial); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// internal entries are not exported
+	if err := source.Put(".quarantine/serial-3-1/conf.json", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := cluster.Export(buf); err != nil {
+		t.Fatalf("exporting cluster: %s", err)
+	}
+
+	entries, manifest, err := ReadArchive(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reading archive: %s", err)
+	}
+	if manifest.Version != ArchiveVersion {
+		t.Fatalf("unexpected archive version %d", manifest.Version)
+	}
+	expected := []string{"cluster.json", "serial-1/conf.json", "serial-2/conf.json"}
+	if keys := sortedKeys(entries); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected entries %v, got %v", expected, keys)
+	}
+
+	target, cleanupTarget := newTestStorage(t, StorageBolt)
+	defer cleanupTarget()
+
+	imported, err := NewClusterWithStorage(target, false, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+	if _, err := imported.CreateNewHost("serial-4"); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffArchive(target, entries)
+	if err != nil {
+		t.Fatalf("diffing archive: %s", err)
+	}
+	expectedDiff := ArchiveDiff{
+		Added:   []string{"serial-1/conf.json", "serial-2/conf.json"},
+		Changed: []string{"cluster.json"},
+		Removed: []string{"serial-4/conf.json"},
+	}
+	if !reflect.DeepEqual(diff, expectedDiff) {
+		t.Fatalf("expected diff %#v, got %#v", expectedDiff, diff)
+	}
+
+	diff, err = imported.Import(entries, "import")
+	if err != nil {
+		t.Fatalf("importing archive: %s", err)
+	}
+	if !reflect.DeepEqual(diff, expectedDiff) {
+		t.Fatalf("expected diff %#v, got %#v", expectedDiff, diff)
+	}
+	if imported.Config.DefaultEtcdClusterToken != "token" {
+		t.Fatalf("expected imported token, got '%s'", imported.Config.DefaultEtcdClusterToken)
+	}
+	if _, exists := imported.HostWithSerial("serial-1"); !exists {
+		t.Fatalf("expected imported host")
+	}
+	if _, exists := imported.HostWithSerial("serial-4"); exists {
+		t.Fatalf("expected host missing in archive to be removed")
+	}
+
+	diff, err = DiffArchive(target, entries)
+	if err != nil {
+		t.Fatalf("diffing archive: %s", err)
+	}
+	if !diff.Empty() {
+		t.Fatalf("expected no changes after import, got %#v", diff)
+	}
+}
+
+func TestReadArchiveRejectsDamagedArchives(t *testing.T) {
+	manifest := []byte(`{"Version":1,"Files":[{"Name":"cluster.json","Size":2,"SHA256":"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}]}`)
+
+	testCases := []struct {
+		name  string
+		files map[string][]byte
+		order []string
+	}{
+		{
+			name:  "checksum mismatch",
+			files: map[string][]byte{"manifest.json": manifest, "cluster.json": []byte(`[]`)},
+			order: []string{"manifest.json", "cluster.json"},
+		},
+		{
+			name:  "missing entry",
+			files: map[string][]byte{"manifest.json": manifest},
+			order: []string{"manifest.json"},
+		},
+		{
+			name:  "newer version",
+			files: map[string][]byte{"manifest.json": []byte(`{"Version":2}`)},
+			order: []string{"manifest.json"},
+		},
+		{
+			name:  "unsafe name",
+			files: map[string][]byte{"manifest.json": manifest, "../cluster.json": []byte(`{}`)},
+			order: []string{"manifest.json", "../cluster.json"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			for _, name := range tc.order {
+				if err := writeTarFile(tw, name, tc.files[name], time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tw.Close()
+			gw.Close()
+
+			_, _, err := ReadArchive(buf)
+			if !IsInvalidFormat(err) {
+				t.Fatalf("expected invalid format error, got %#v", err)
+			}
+		})
+	}
+}
+
+func TestImportMigratesArchive(t *testing.T) {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, cleanup := newTestStorage(t, StorageDir)
+	defer cleanup()
+
+	cluster, err := NewClusterWithStorage(storage, false, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+	if _, err := cluster.CreateNewHost("serial-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// archive written before the schema version got introduced
+	entries := map[string][]byte{
+		"cluster.json":       []byte(`{"Config":{"DefaultEtcdClusterToken":"token"}}`),
+		"serial-1/conf.json": []byte(`{"Serial":"serial-1","Profile":"core","State":"running"}`),
+	}
+
+	dryRun, err := DiffImport(storage, entries, logger)
+	if err != nil {
+		t.Fatalf("diffing import: %s", err)
+	}
+	diff, err := cluster.Import(entries, "import")
+	if err != nil {
+		t.Fatalf("importing archive: %s", err)
+	}
+	if !reflect.DeepEqual(dryRun, diff) {
+		t.Fatalf("expected dry run diff %#v to match import diff %#v", dryRun, diff)
+	}
+	host, exists := cluster.HostWithSerial("serial-1")
+	if !exists || host.Version != HostSchemaVersion {
+		t.Fatalf("expected imported host to be migrated, got %#v", host)
+	}
+
+	// the migrated archive is imported already
+	dryRun, err = DiffImport(storage, entries, logger)
+	if err != nil {
+		t.Fatalf("diffing import: %s", err)
+	}
+	if !dryRun.Empty() {
+		t.Fatalf("expected no changes after import, got %#v", dryRun)
+	}
+	for key, value := range entries {
+		if current, _ := storage.Get(key); bytes.Equal(current, value) {
+			t.Fatalf("expected '%s' to be migrated", key)
+		}
+	}
+}
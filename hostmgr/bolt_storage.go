@@ -0,0 +1,123 @@
+package hostmgr
+
+import (
+	"time"
+
+	"github.com/giantswarm/microerror"
+	bolt "go.etcd.io/bbolt"
+)
+
+const boltFile = "cluster.db"
+
+var boltBucket = []byte("cluster")
+
+// BoltStorage stores all keys within a single bucket of an embedded bbolt
+// database. Every write is a transaction of its own, Batch writes all entries
+// within one transaction.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage opens or creates the bbolt database given by file. The
+// database is locked exclusively, so opening it fails while another process
+// is using it.
+func NewBoltStorage(file string) (*BoltStorage, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, microerror.Mask(err)
+	}
+
+	s := &BoltStorage{
+		db: db,
+	}
+
+	return s, nil
+}
+
+func (s *BoltStorage) Get(key string) ([]byte, error) {
+	var value []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return microerror.Maskf(notFoundError, "key '%s'", key)
+		}
+		// values are only valid during the transaction
+		value = append([]byte{}, v...)
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return value, nil
+}
+
+func (s *BoltStorage) Put(key string, value []byte) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) Delete(key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) Keys() ([]string, error) {
+	keys := []string{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return keys, nil
+}
+
+func (s *BoltStorage) Batch(entries map[string][]byte) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for key, value := range entries {
+			err := b.Put([]byte(key), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
@@ -2,10 +2,10 @@ package hostmgr
 
 import (
 	"crypto/rand"
-	"crypto/tls"
-	"crypto/x509"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -17,21 +17,36 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/client"
+	"github.com/fsnotify/fsnotify"
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/micrologger"
 	"golang.org/x/net/context"
 )
 
-const clusterConfFile = "cluster.json"
+const (
+	clusterConfFile = "cluster.json"
+	// quarantineDir keeps the entries of hosts whose configuration could not
+	// be decoded.
+	quarantineDir = ".quarantine"
+	// DiscoveryDir keeps the registry of the internal etcd discovery, in case
+	// it is embedded into the cluster storage. Like all internal entries it is
+	// neither part of the history nor of archives.
+	DiscoveryDir = ".discovery"
+)
 
 type Cluster struct {
-	Config ClusterConfig
-
-	baseDir string
-	// an cached host is identified by its serial number
-	hostsCache    map[string]*cachedHost
-	cachedModTime time.Time
-	mu            *sync.Mutex
+	// Version is the schema version of cluster.json.
+	Version int
+	Config  ClusterConfig
+
+	storage Storage
+	// git is nil in case the history of the cluster is not recorded
+	git *gitRepo
+	// index keeps all hosts in memory, guarded by mu
+	index *hostIndex
+	mu    *sync.Mutex
+	// watcher is nil unless the cluster directory is watched for changes
+	watcher *fsnotify.Watcher
 
 	logger micrologger.Logger
 }
@@ -43,43 +58,94 @@ type ClusterConfig struct {
 	EtcdDiscoveryURL string `json:"EtcdDiscoveryURL,omitempty"`
 }
 
-type cachedHost struct {
-	lastModTime time.Time
-	host        *Host
+// OpenCluster opens the existing cluster stored within the cluster directory.
+func OpenCluster(baseDir string, logger micrologger.Logger) (*Cluster, error) {
+	storage, err := NewDirStorage(baseDir)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return OpenClusterWithStorage(storage, false, logger)
 }
 
-func OpenCluster(baseDir string, logger micrologger.Logger) (*Cluster, error) {
+// OpenClusterWithStorage opens the existing cluster kept by the given
+// storage. When git is true, the cluster directory is kept as git repository
+// recording every change. This requires the dir storage.
+func OpenClusterWithStorage(storage Storage, git bool, logger micrologger.Logger) (*Cluster, error) {
 	cluster := &Cluster{logger: logger}
 
-	err := loadJson(cluster, path.Join(baseDir, clusterConfFile))
+	migrated, err := MigrateSchema(storage, logger)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
 
-	cluster.baseDir = baseDir
+	err = loadJson(storage, clusterConfFile, cluster)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	if git {
+		cluster.git, err = openStorageGitRepo(storage)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	if migrated > 0 {
+		err = cluster.commitChanges(fmt.Sprintf("startup: migrate %d files to the current schema (by mayu)", migrated))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	cluster.storage = storage
 	cluster.mu = new(sync.Mutex)
-	cluster.hostsCache = map[string]*cachedHost{}
+	cluster.index = newHostIndex()
+
+	err = cluster.cacheHosts()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
 	return cluster, nil
 }
 
 // NewCluster creates a new cluster based on the cluster directory.
 func NewCluster(baseDir string, logger micrologger.Logger) (*Cluster, error) {
-	if !fileExists(baseDir) {
-		err := os.Mkdir(baseDir, 0755)
+	storage, err := NewDirStorage(baseDir)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return NewClusterWithStorage(storage, false, logger)
+}
+
+// NewClusterWithStorage creates a new cluster kept by the given storage. When
+// git is true, the cluster directory is kept as git repository recording every
+// change. This requires the dir storage.
+func NewClusterWithStorage(storage Storage, git bool, logger micrologger.Logger) (*Cluster, error) {
+	c := &Cluster{
+		storage: storage,
+		mu:      new(sync.Mutex),
+		Config:  ClusterConfig{},
+		index:   newHostIndex(),
+		logger:  logger,
+	}
+
+	if git {
+		var err error
+		c.git, err = openStorageGitRepo(storage)
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
 	}
 
-	c := &Cluster{
-		baseDir:    baseDir,
-		mu:         new(sync.Mutex),
-		Config:     ClusterConfig{},
-		hostsCache: map[string]*cachedHost{},
-		logger:     logger,
+	err := c.cacheHosts()
+	if err != nil {
+		return nil, microerror.Mask(err)
 	}
 
-	err := c.Commit("initial commit")
+	err = c.Commit("initial commit")
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
@@ -89,11 +155,11 @@ func NewCluster(baseDir string, logger micrologger.Logger) (*Cluster, error) {
 // CreateNewHost creates a new host with the given serial.
 func (c *Cluster) CreateNewHost(serial string) (*Host, error) {
 	serial = strings.ToLower(serial)
-	hostDir := path.Join(c.baseDir, serial)
-	newHost, err := createHost(serial, hostDir)
+	newHost, err := createHost(c.storage, serial)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
+	newHost.cluster = c
 
 	machineID := genMachineID()
 	newHost.MachineID = machineID
@@ -101,21 +167,176 @@ func (c *Cluster) CreateNewHost(serial string) (*Host, error) {
 		newHost.Hostname = strings.Replace(newHost.InternalAddr.String(), ".", "-", 4)
 	}
 	_ = c.logger.Log("level", "info", "message", fmt.Sprintf("hostname for  '%s' is %s", newHost.InternalAddr.String(), newHost.Hostname))
-	_ = newHost.Save()
+	err = newHost.Save()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
 
 	return newHost, nil
 }
 
+// Commit saves the cluster configuration and records all pending changes of
+// the cluster using the given message.
 func (c *Cluster) Commit(msg string) error {
 	err := c.save()
 	if err != nil {
 		return microerror.Mask(err)
 	}
+
+	err = c.commitChanges(msg)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	return nil
+}
+
+// HostHistory returns the recorded revisions of the host given by serial,
+// newest first.
+func (c *Cluster) HostHistory(serial string) ([]Revision, error) {
+	if c.git == nil {
+		return nil, microerror.Maskf(gitDisabledError, "no history recorded for cluster")
+	}
+	if !isHostDir(serial) {
+		return nil, microerror.Maskf(invalidSerialError, "invalid serial '%s'", serial)
+	}
+
+	revisions, err := c.git.log(strings.ToLower(serial))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if len(revisions) == 0 {
+		return nil, microerror.Maskf(notFoundError, "no history for host '%s'", serial)
+	}
+
+	return revisions, nil
+}
+
+// HostDiff returns the unified diff of the host given by serial between the
+// revisions from and to. In case to is empty, from is compared against the
+// current state of the host.
+func (c *Cluster) HostDiff(serial, from, to string) (string, error) {
+	if c.git == nil {
+		return "", microerror.Maskf(gitDisabledError, "no history recorded for cluster")
+	}
+	if !isHostDir(serial) {
+		return "", microerror.Maskf(invalidSerialError, "invalid serial '%s'", serial)
+	}
+
+	diff, err := c.git.diff(strings.ToLower(serial), from, to)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return diff, nil
+}
+
+// RollbackHost restores the host given by serial as it was at the given
+// revision and records the rollback using the given message.
+func (c *Cluster) RollbackHost(serial, revision, msg string) error {
+	if c.git == nil {
+		return microerror.Maskf(gitDisabledError, "no history recorded for cluster")
+	}
+	// the serial is the path restored by git, which must not reach beyond
+	// the directory of the host
+	if !isHostDir(serial) {
+		return microerror.Maskf(invalidSerialError, "invalid serial '%s'", serial)
+	}
+	serial = strings.ToLower(serial)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.index.bySerial[serial]; !exists {
+		return microerror.Maskf(notFoundError, "host '%s' does not exist", serial)
+	}
+
+	err := c.git.checkout(serial, revision)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	c.loadHost(serial)
+
+	err = c.commitChanges(msg)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// Export writes the cluster state as archive to w, see ExportCluster.
+func (c *Cluster) Export(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := ExportCluster(c.storage, w)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// Import replaces the cluster state with the entries of an archive read by
+// ReadArchive and records the import using the given message. The returned
+// diff lists the changed entries.
+func (c *Cluster) Import(entries map[string][]byte, msg string) (ArchiveDiff, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := migrateArchive(entries, c.logger)
+	if err != nil {
+		return ArchiveDiff{}, microerror.Mask(err)
+	}
+
+	diff, err := ImportCluster(c.storage, entries)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	err = loadJson(c.storage, clusterConfFile, c)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+	err = c.cacheHosts()
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	err = c.commitChanges(msg)
+	if err != nil {
+		return diff, microerror.Mask(err)
+	}
+
+	return diff, nil
+}
+
+func (c *Cluster) commitChanges(msg string) error {
+	if c.git == nil {
+		return nil
+	}
+
+	err := c.git.commit(msg)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
 	return nil
 }
 
-// Update refreshs the internal host cache based on information within the
-// cluster directory.
+func openStorageGitRepo(storage Storage) (*gitRepo, error) {
+	dirStorage, ok := storage.(*DirStorage)
+	if !ok {
+		return nil, microerror.Maskf(invalidConfigError, "git history requires the '%s' storage", StorageDir)
+	}
+
+	return openGitRepo(dirStorage.BaseDir())
+}
+
+// Update rebuilds the host index from scratch based on the storage. Changes
+// made using the cluster are indexed right away and changes to a watched
+// cluster directory are picked up automatically, so Update is only needed to
+// recover from missed changes.
 func (c *Cluster) Update() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -128,22 +349,77 @@ func (c *Cluster) Update() error {
 	return nil
 }
 
-// HostWithSerial returns the host object given by serial based on the internal
-// cache. In case the host could not be found, host is nil and false is
-// returned as second return value.
+// HostWithSerial returns the host object given by serial based on the host
+// index. In case the host could not be found, host is nil and false is
+// returned as second return value. The returned host is a copy, changes are
+// visible to the cluster once the host is saved.
 func (c *Cluster) HostWithSerial(serial string) (*Host, bool) {
-	if err := c.Update(); err != nil {
-		_ = c.logger.Log("level", "error", "message", "error getting the serial number using the internal cache", "stack", err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if host, exists := c.index.bySerial[strings.ToLower(serial)]; exists {
+		return host.clone(), true
+	} else {
 		return nil, false
 	}
+}
+
+// HostWithInternalAddr returns the host the given internal address is
+// assigned to. In case the host could not be found, host is nil and false is
+// returned as second return value.
+func (c *Cluster) HostWithInternalAddr(ip net.IP) (*Host, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if cached, exists := c.hostsCache[strings.ToLower(serial)]; exists {
-		return cached.get(), true
-	} else {
+	host, exists := anyHost(c.index.byIP[ip.String()])
+	if !exists {
 		return nil, false
 	}
+	return host.clone(), true
+}
+
+// HostWithAdditionalAddr returns the host the given address of the extra NIC
+// given by nic is assigned to. In case the host could not be found, host is
+// nil and false is returned as second return value.
+func (c *Cluster) HostWithAdditionalAddr(nic string, ip net.IP) (*Host, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	host, exists := anyHost(c.index.byAdditionalAddr[additionalAddrKey(nic, ip)])
+	if !exists {
+		return nil, false
+	}
+	return host.clone(), true
+}
+
+// HostWithMacAddress returns the host owning the given MAC address. In case
+// the host could not be found, host is nil and false is returned as second
+// return value.
+func (c *Cluster) HostWithMacAddress(mac string) (*Host, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	host, exists := anyHost(c.index.byMAC[strings.ToLower(mac)])
+	if !exists {
+		return nil, false
+	}
+	return host.clone(), true
+}
+
+// HostsWithProfile returns all hosts assigned to the given profile.
+func (c *Cluster) HostsWithProfile(profile string) []*Host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return cloneHosts(hostList(c.index.byProfile[profile]))
+}
+
+// HostsWithState returns all hosts in the given state.
+func (c *Cluster) HostsWithState(state hostState) []*Host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return cloneHosts(hostList(c.index.byState[state]))
 }
 
 // GetProfileCount returns a matching of profiles and how many of them are
@@ -154,34 +430,22 @@ func (c *Cluster) HostWithSerial(serial string) (*Host, bool) {
 //	    "core": 2,
 //	}
 func (c *Cluster) GetProfileCount() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	count := map[string]int{}
-	allHosts := c.GetAllHosts()
-	for _, host := range allHosts {
-		if host.Profile == "" {
-			continue
-		}
-		if cnt, exists := count[host.Profile]; exists {
-			count[host.Profile] = cnt + 1
-		} else {
-			count[host.Profile] = 1
-		}
+	for profile, hosts := range c.index.byProfile {
+		count[profile] = len(hosts)
 	}
 	return count
 }
 
-// GetAllHosts returns a list of all hosts based on the internal cache.
+// GetAllHosts returns a list of all hosts based on the host index.
 func (c *Cluster) GetAllHosts() []*Host {
-	hosts := make([]*Host, 0, len(c.hostsCache))
-
-	if err := c.Update(); err != nil {
-		_ = c.logger.Log("level", "error", "message", "error getting the list of hosts based on the internal cache: %#v", "stack", err)
-		return hosts
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for _, cachedHost := range c.hostsCache {
-		hosts = append(hosts, cachedHost.get())
-	}
-	return hosts
+	return cloneHosts(hostList(c.index.bySerial))
 }
 
 func (c *Cluster) GenerateEtcdDiscoveryToken() (string, error) {
@@ -195,24 +459,19 @@ func (c *Cluster) GenerateEtcdDiscoveryToken() (string, error) {
 	return token, nil
 }
 
-func (c *Cluster) StoreEtcdDiscoveryToken(etcdEndpoint, etcdCAFile, token string, size int) error {
+// StoreEtcdDiscoveryToken adds the given token to the etcd discovery registry
+// kept in the v2 keys API of the etcd given by conn.
+func (c *Cluster) StoreEtcdDiscoveryToken(conn EtcdConnection, token string, size int) error {
 	//http transport for etcd connection
 	transport := client.DefaultTransport
-	// read custom root CA file if https and CAfile is configured
-	if strings.HasPrefix(etcdEndpoint, "https") && etcdCAFile != "" {
-		customCA := x509.NewCertPool()
-
-		pemData, err := ioutil.ReadFile(etcdCAFile)
-		if err != nil {
-			return microerror.Maskf(err, "Unable to read custom CA file: ")
-		}
-		customCA.AppendCertsFromPEM(pemData)
+	tlsConfig, err := conn.TLSConfig()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if tlsConfig != nil {
 		transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:    customCA,
-				MinVersion: tls.VersionTLS12,
-			},
-			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+			Proxy:           http.ProxyFromEnvironment,
 			Dial: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -223,8 +482,10 @@ func (c *Cluster) StoreEtcdDiscoveryToken(etcdEndpoint, etcdCAFile, token string
 
 	// store in etcd
 	cfg := client.Config{
-		Endpoints: []string{etcdEndpoint},
+		Endpoints: []string{conn.Endpoint},
 		Transport: transport,
+		Username:  conn.Username,
+		Password:  conn.Password,
 		// set timeout per request to fail fast when the target endpoint is unavailable
 		HeaderTimeoutPerRequest: time.Second,
 	}
@@ -276,66 +537,128 @@ func Has(host *Host, exists bool) bool {
 	return exists
 }
 
-func (cached cachedHost) get() *Host {
-	fi, err := os.Stat(cached.host.confPath())
+// Storage returns the storage keeping the cluster state.
+func (c *Cluster) Storage() Storage {
+	return c.storage
+}
+
+func (c *Cluster) save() error {
+	c.Version = ClusterSchemaVersion
+	return saveJson(c.storage, clusterConfFile, c)
+}
+
+func (c *Cluster) cacheHosts() error {
+	keys, err := c.storage.Keys()
 	if err != nil {
-		panic(err)
+		return microerror.Mask(err)
 	}
 
-	if fi.ModTime().After(cached.lastModTime) {
-		hostDir := cached.host.hostDir.Name()
-		cached.host, err = HostFromDir(hostDir)
-		if err != nil {
-			panic(err)
+	newIndex := newHostIndex()
+
+	for _, serial := range hostSerials(keys) {
+		host, digest, err := readHost(c.storage, serial)
+		if IsInvalidFormat(err) {
+			target, qerr := quarantineHost(c.storage, serial)
+			if qerr != nil {
+				_ = c.logger.Log("level", "error", "message", fmt.Sprintf("unable to quarantine corrupt host '%s'", serial), "stack", qerr)
+				continue
+			}
+			_ = c.logger.Log("level", "error", "message", fmt.Sprintf("moved corrupt host '%s' to '%s'", serial, target), "stack", err)
+			continue
+		} else if err != nil {
+			_ = c.logger.Log("level", "warning", "message", fmt.Sprintf("unable to process host '%s'", serial), "stack", err)
+			continue
 		}
-		cached.lastModTime = cached.host.lastModTime
+		host.cluster = c
+		newIndex.put(host, digest)
 	}
 
-	return cached.host
+	c.index = newIndex
+	return nil
 }
 
-func (c *Cluster) save() error {
-	return saveJson(c, c.confPath())
+// indexHost updates the index with the given host, which just got saved with
+// a configuration matching digest.
+func (c *Cluster) indexHost(h *Host, digest [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.index.put(h.clone(), digest)
 }
 
-func (c *Cluster) confPath() string {
-	return path.Join(c.baseDir, clusterConfFile)
+// reloadHost updates the index with the host kept in dir as found in the
+// storage. Hosts which no longer exist are removed from the index. Hosts
+// which cannot be decoded are kept as indexed, since the change might not be
+// complete yet.
+func (c *Cluster) reloadHost(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loadHost(dir)
 }
 
-func (c *Cluster) cacheHosts() error {
-	baseDirFileInfo, err := os.Stat(c.baseDir)
-	if err != nil {
-		return microerror.Mask(err)
+// loadHost is reloadHost for callers holding c.mu.
+func (c *Cluster) loadHost(dir string) {
+	serial := strings.ToLower(dir)
+
+	host, digest, err := readHost(c.storage, dir)
+	if IsNotFound(err) {
+		c.index.remove(serial)
+		return
+	} else if err != nil {
+		_ = c.logger.Log("level", "warning", "message", fmt.Sprintf("unable to reload host '%s'", dir), "stack", err)
+		return
+	}
+
+	if indexed, exists := c.index.digest(serial); exists && indexed == digest {
+		// the change got made by the cluster itself and is indexed already
+		return
 	}
 
-	modTime := baseDirFileInfo.ModTime()
+	host.cluster = c
+	c.index.put(host, digest)
+}
+
+func cloneHosts(hosts []*Host) []*Host {
+	clones := make([]*Host, 0, len(hosts))
+	for _, h := range hosts {
+		clones = append(clones, h.clone())
+	}
+	return clones
+}
 
-	fis, err := ioutil.ReadDir(c.baseDir)
+// quarantineHost moves all entries of the host given by serial out of the
+// cluster, so that a corrupt host configuration no longer breaks it. The
+// entries are kept below quarantineDir for inspection and manual recovery.
+func quarantineHost(storage Storage, serial string) (string, error) {
+	keys, err := storage.Keys()
 	if err != nil {
-		return microerror.Mask(err)
+		return "", microerror.Mask(err)
 	}
 
-	newCache := map[string]*cachedHost{}
-
-	for _, fi := range fis {
-		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
-			hostConfPath := path.Join(c.baseDir, fi.Name(), hostConfFile)
-			if fileExists(hostConfPath) {
-				host, err := HostFromDir(path.Join(c.baseDir, fi.Name()))
-				if err != nil {
-					_ = c.logger.Log("level", "warning", "message", fmt.Sprintf("unable to process '%s'", hostConfPath), "stack", err)
-				}
-				newCache[strings.ToLower(fi.Name())] = &cachedHost{
-					host:        host,
-					lastModTime: host.lastModTime,
-				}
-			}
+	target := path.Join(quarantineDir, fmt.Sprintf("%s-%d", serial, time.Now().Unix()))
+	prefix := serial + "/"
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		value, err := storage.Get(key)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		err = storage.Put(path.Join(target, strings.TrimPrefix(key, prefix)), value)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		err = storage.Delete(key)
+		if err != nil {
+			return "", microerror.Mask(err)
 		}
 	}
 
-	c.hostsCache = newCache
-	c.cachedModTime = modTime
-	return nil
+	return target, nil
 }
 
 func fileExists(path string) bool {
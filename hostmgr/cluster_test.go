@@ -0,0 +1,69 @@
+package hostmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func TestCorruptHostIsQuarantined(t *testing.T) {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []string{StorageDir, StorageBolt} {
+		t.Run(kind, func(t *testing.T) {
+			storage, cleanup := newTestStorage(t, kind)
+			defer cleanup()
+
+			cluster, err := NewClusterWithStorage(storage, false, logger)
+			if err != nil {
+				t.Fatalf("creating cluster: %s", err)
+			}
+			if _, err := cluster.CreateNewHost("serial-1"); err != nil {
+				t.Fatal(err)
+			}
+
+			// simulate a write interrupted by a crash
+			err = storage.Put(hostConfKey("serial-2"), []byte(`{"Serial":"ser`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cluster, err = OpenClusterWithStorage(storage, false, logger)
+			if err != nil {
+				t.Fatalf("opening cluster with corrupt host: %s", err)
+			}
+			if _, exists := cluster.HostWithSerial("serial-1"); !exists {
+				t.Fatalf("expected intact host to be loaded")
+			}
+			if _, exists := cluster.HostWithSerial("serial-2"); exists {
+				t.Fatalf("expected corrupt host to be skipped")
+			}
+
+			keys, err := storage.Keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys {
+				if strings.HasPrefix(key, "serial-2/") {
+					t.Fatalf("expected corrupt host to be moved, found '%s'", key)
+				}
+			}
+
+			if kind == StorageBolt {
+				quarantined := false
+				for _, key := range keys {
+					if strings.HasPrefix(key, quarantineDir+"/serial-2-") {
+						quarantined = true
+					}
+				}
+				if !quarantined {
+					t.Fatalf("expected corrupt host below %s, got keys %v", quarantineDir, keys)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package hostmgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+// DirStorage stores every key as JSON file within the cluster directory.
+// Files and directories starting with a dot are ignored, so are files which
+// are not JSON files.
+type DirStorage struct {
+	baseDir string
+}
+
+// NewDirStorage creates a storage for the cluster directory given by baseDir.
+// The directory is created in case it does not exist yet.
+func NewDirStorage(baseDir string) (*DirStorage, error) {
+	if !fileExists(baseDir) {
+		err := os.MkdirAll(baseDir, 0755)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	s := &DirStorage{
+		baseDir: baseDir,
+	}
+
+	return s, nil
+}
+
+func (s *DirStorage) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, microerror.Maskf(notFoundError, "key '%s'", key)
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return data, nil
+}
+
+// Put writes value to a temporary file which is synced and then renamed to
+// the file of key, so that a crash never leaves a partially written file
+// behind.
+func (s *DirStorage) Put(key string, value []byte) error {
+	p := s.path(key)
+	dir := filepath.Dir(p)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	// cleans up the temporary file in case anything below fails
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(value)
+	if err != nil {
+		tmp.Close()
+		return microerror.Mask(err)
+	}
+	err = tmp.Chmod(0644)
+	if err != nil {
+		tmp.Close()
+		return microerror.Mask(err)
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return microerror.Mask(err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = os.Rename(tmp.Name(), p)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = syncDir(dir)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (s *DirStorage) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// Keys returns the keys of all JSON entries. Hidden files and directories like
+// the git repository are skipped, except for the registry kept in
+// DiscoveryDir, so that it gets migrated along with the cluster.
+func (s *DirStorage) Keys() ([]string, error) {
+	keys := []string{}
+
+	err := filepath.Walk(s.baseDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.baseDir {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), ".") && p != filepath.Join(s.baseDir, DiscoveryDir) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || filepath.Ext(p) != ".json" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.baseDir, p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Batch writes all entries one after another. The directory layout does not
+// support transactions, so a failure leaves the entries written so far in
+// place.
+func (s *DirStorage) Batch(entries map[string][]byte) error {
+	for key, value := range entries {
+		err := s.Put(key, value)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *DirStorage) Close() error {
+	return nil
+}
+
+// BaseDir returns the cluster directory the storage operates on.
+func (s *DirStorage) BaseDir() string {
+	return s.baseDir
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (s *DirStorage) path(key string) string {
+	return filepath.Join(s.baseDir, filepath.FromSlash(path.Clean("/"+key)))
+}
@@ -0,0 +1,84 @@
+package hostmgr
+
+import "github.com/giantswarm/microerror"
+
+var notFoundError = &microerror.Error{
+	Kind: "notFoundError",
+}
+
+// IsNotFound asserts notFoundError.
+func IsNotFound(err error) bool {
+	return microerror.Cause(err) == notFoundError
+}
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
+
+var executionFailedError = &microerror.Error{
+	Kind: "executionFailedError",
+}
+
+// IsExecutionFailed asserts executionFailedError.
+func IsExecutionFailed(err error) bool {
+	return microerror.Cause(err) == executionFailedError
+}
+
+var gitDisabledError = &microerror.Error{
+	Kind: "gitDisabledError",
+}
+
+// IsGitDisabled asserts gitDisabledError.
+func IsGitDisabled(err error) bool {
+	return microerror.Cause(err) == gitDisabledError
+}
+
+var lockedError = &microerror.Error{
+	Kind: "lockedError",
+}
+
+// IsLocked asserts lockedError.
+func IsLocked(err error) bool {
+	return microerror.Cause(err) == lockedError
+}
+
+var invalidFormatError = &microerror.Error{
+	Kind: "invalidFormatError",
+}
+
+// IsInvalidFormat asserts invalidFormatError.
+func IsInvalidFormat(err error) bool {
+	return microerror.Cause(err) == invalidFormatError
+}
+
+var unsupportedVersionError = &microerror.Error{
+	Kind: "unsupportedVersionError",
+}
+
+// IsUnsupportedVersion asserts unsupportedVersionError.
+func IsUnsupportedVersion(err error) bool {
+	return microerror.Cause(err) == unsupportedVersionError
+}
+
+var invalidRevisionError = &microerror.Error{
+	Kind: "invalidRevisionError",
+}
+
+// IsInvalidRevision asserts invalidRevisionError.
+func IsInvalidRevision(err error) bool {
+	return microerror.Cause(err) == invalidRevisionError
+}
+
+var invalidSerialError = &microerror.Error{
+	Kind: "invalidSerialError",
+}
+
+// IsInvalidSerial asserts invalidSerialError.
+func IsInvalidSerial(err error) bool {
+	return microerror.Cause(err) == invalidSerialError
+}
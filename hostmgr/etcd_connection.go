@@ -0,0 +1,62 @@
+package hostmgr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/giantswarm/microerror"
+)
+
+// EtcdConnection configures the connection to the etcd keeping the registry of
+// the internal etcd discovery.
+type EtcdConnection struct {
+	// Endpoint is the client URL of etcd.
+	Endpoint string
+	// CAFile verifies the certificates of etcd, if it is not signed by a
+	// trusted root CA.
+	CAFile string
+	// CertFile and KeyFile authenticate mayu using a client certificate.
+	CertFile string
+	KeyFile  string
+	// Username and Password authenticate mayu as etcd user.
+	Username string
+	Password string
+}
+
+// TLSConfig returns the TLS configuration for connecting to etcd. It is nil
+// in case neither a CA file nor a client certificate is configured.
+func (c EtcdConnection) TLSConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" {
+		return nil, nil
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, microerror.Maskf(invalidConfigError, "etcd client certificate and key must be given together")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		pemData, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "unable to read etcd CA file: %s", err)
+		}
+		customCA := x509.NewCertPool()
+		if !customCA.AppendCertsFromPEM(pemData) {
+			return nil, microerror.Maskf(invalidConfigError, "no certificates found in etcd CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = customCA
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "unable to load etcd client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
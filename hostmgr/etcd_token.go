@@ -0,0 +1,36 @@
+package hostmgr
+
+// EtcdToken reports a token of the internal etcd discovery together with the
+// hosts using it.
+type EtcdToken struct {
+	Token string
+	// Size is the size of the etcd cluster. It is 0 for tokens unknown to
+	// the registry.
+	Size    int
+	Members []EtcdTokenMember
+	// Registered is false in case hosts use the token, but it is unknown to
+	// the registry of the internal etcd discovery.
+	Registered bool
+	// Default lists the clusters using the token as default etcd cluster
+	// token. The default cluster is named by an empty string.
+	Default []string `json:",omitempty"`
+	Hosts   []EtcdTokenHost
+}
+
+// EtcdTokenMember is an etcd member registered with a token.
+type EtcdTokenMember struct {
+	// ID is the member ID in hex, as registered by etcd.
+	ID string
+	// Value names the member and its peer URLs, eg.
+	// "node-1=https://10.0.0.1:2380".
+	Value string
+}
+
+// EtcdTokenHost is a host using a token, either explicitly or as default of
+// its cluster.
+type EtcdTokenHost struct {
+	// Cluster names the additional cluster of the host. It is empty for the
+	// default cluster.
+	Cluster string `json:",omitempty"`
+	Serial  string
+}
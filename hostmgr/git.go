@@ -0,0 +1,234 @@
+package hostmgr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	gitAuthorName  = "mayu"
+	gitAuthorEmail = "mayu@localhost"
+
+	// fieldSep separates the fields of the git log format used to read the
+	// history of a host.
+	fieldSep = "\x1f"
+)
+
+// gitIgnore lists files within the cluster directory which are not part of
+// the cluster state and thus must not be committed.
+var gitIgnore = []string{
+	boltFile,
+	lockFile,
+	quarantineDir + "/",
+	backupDir + "/",
+	DiscoveryDir + "/",
+}
+
+// Revision is a single entry within the history of the cluster directory.
+type Revision struct {
+	ID      string
+	Author  string
+	Date    time.Time
+	Message string
+}
+
+// gitRepo keeps the cluster directory as git repository. Operations are
+// serialized, since git itself does not allow concurrent commits.
+type gitRepo struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func openGitRepo(dir string) (*gitRepo, error) {
+	_, err := exec.LookPath("git")
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "git executable not found, use --no-git to disable git history")
+	}
+
+	g := &gitRepo{
+		dir: dir,
+	}
+
+	if !fileExists(filepath.Join(dir, ".git")) {
+		_, err := g.run("init", "--quiet")
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	// keep the ignored files up to date for repositories created by older
+	// versions
+	ignore := []byte(strings.Join(gitIgnore, "\n") + "\n")
+	current, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil || !bytes.Equal(current, ignore) {
+		err = ioutil.WriteFile(filepath.Join(dir, ".gitignore"), ignore, 0644) // nolint
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	return g, nil
+}
+
+// commit records all changes within the cluster directory. Nothing is
+// committed in case there are no changes.
+func (g *gitRepo) commit(msg string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, err := g.run("add", "--all")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	out, err := g.run("status", "--porcelain")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+
+	_, err = g.run("commit", "--quiet", "--message", msg)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// log returns the revisions touching the given path, newest first.
+func (g *gitRepo) log(p string) ([]Revision, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	format := strings.Join([]string{"%H", "%an", "%aI", "%s"}, fieldSep)
+	out, err := g.run("log", "--format="+format, "--end-of-options", "--", p)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	revisions := []Revision{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 4 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		revisions = append(revisions, Revision{
+			ID:      fields[0],
+			Author:  fields[1],
+			Date:    date,
+			Message: fields[3],
+		})
+	}
+
+	return revisions, nil
+}
+
+// diff returns the unified diff of the given path between the revisions from
+// and to. In case to is empty, from is compared against the current state.
+func (g *gitRepo) diff(p, from, to string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fromID, err := g.resolve(from)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	args := []string{"diff", "--no-color", "--end-of-options", fromID}
+	if to != "" {
+		toID, err := g.resolve(to)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		args = append(args, toID)
+	}
+	args = append(args, "--", p)
+
+	out, err := g.run(args...)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return string(out), nil
+}
+
+// checkout restores the given path as it was at the given revision. Files
+// created after that revision are removed.
+func (g *gitRepo) checkout(p, revision string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id, err := g.resolve(revision)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	_, err = g.run("cat-file", "-e", "--end-of-options", id+":"+p)
+	if err != nil {
+		return microerror.Maskf(notFoundError, "'%s' does not exist at revision '%s'", p, revision)
+	}
+
+	_, err = g.run("rm", "-r", "--quiet", "--ignore-unmatch", "--", p)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	// checkout does not support --end-of-options, id is a resolved commit ID
+	_, err = g.run("checkout", id, "--", p)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// resolve returns the commit ID of the given revision. Revisions must not
+// look like options, as they are passed on to git.
+func (g *gitRepo) resolve(revision string) (string, error) {
+	if revision == "" || strings.HasPrefix(revision, "-") {
+		return "", microerror.Maskf(invalidRevisionError, "invalid revision '%s'", revision)
+	}
+
+	out, err := g.run("rev-parse", "--quiet", "--verify", "--end-of-options", revision+"^{commit}")
+	if err != nil {
+		return "", microerror.Maskf(notFoundError, "revision '%s' does not exist", revision)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g *gitRepo) run(args ...string) ([]byte, error) {
+	subcommand := args[0]
+	// paths given to git are always literal, never globs or pathspec magic
+	args = append([]string{
+		"--literal-pathspecs",
+		"-C", g.dir,
+		"-c", "user.name=" + gitAuthorName,
+		"-c", "user.email=" + gitAuthorEmail,
+		"-c", "commit.gpgsign=false",
+	}, args...)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...) // nolint
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "git %s: %s", subcommand, strings.TrimSpace(fmt.Sprintf("%s %s", err, stderr.String())))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,136 @@
+package hostmgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func TestClusterHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostmgr_git_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := NewClusterWithStorage(storage, true, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+
+	host, err := cluster.CreateNewHost("serial-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.ProviderId = "first"
+	if err := host.Commit("set_provider_id: set provider id of host serial-1 (by test)"); err != nil {
+		t.Fatal(err)
+	}
+	host.ProviderId = "second"
+	if err := host.Commit("set_provider_id: set provider id of host serial-1 (by test)"); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := cluster.HostHistory("serial-1")
+	if err != nil {
+		t.Fatalf("reading history: %s", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %#v", revisions)
+	}
+	if revisions[0].Message != "set_provider_id: set provider id of host serial-1 (by test)" {
+		t.Fatalf("unexpected message '%s'", revisions[0].Message)
+	}
+
+	diff, err := cluster.HostDiff("serial-1", revisions[1].ID, revisions[0].ID)
+	if err != nil {
+		t.Fatalf("reading diff: %s", err)
+	}
+	if !strings.Contains(diff, `-  "ProviderId": "first"`) || !strings.Contains(diff, `+  "ProviderId": "second"`) {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+
+	err = cluster.RollbackHost("serial-1", revisions[1].ID, "rollback: roll back host serial-1 (by test)")
+	if err != nil {
+		t.Fatalf("rolling back host: %s", err)
+	}
+
+	host, exists := cluster.HostWithSerial("serial-1")
+	if !exists {
+		t.Fatalf("expected host to exist after rollback")
+	}
+	if host.ProviderId != "first" {
+		t.Fatalf("expected rolled back provider id 'first', got '%s'", host.ProviderId)
+	}
+
+	revisions, err = cluster.HostHistory("serial-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("expected rollback to be recorded, got %#v", revisions)
+	}
+
+	// revisions are never passed on as options
+	output := filepath.Join(dir, "injected")
+	for _, revision := range []string{"--output=" + output, "-p", ""} {
+		if _, err := cluster.HostDiff("serial-1", revision, ""); !IsInvalidRevision(err) {
+			t.Errorf("expected invalid revision error for '%s', got %#v", revision, err)
+		}
+		if _, err := cluster.HostDiff("serial-1", revisions[0].ID, revision); revision != "" && !IsInvalidRevision(err) {
+			t.Errorf("expected invalid revision error for '%s', got %#v", revision, err)
+		}
+		if err := cluster.RollbackHost("serial-1", revision, "rollback"); !IsInvalidRevision(err) {
+			t.Errorf("expected invalid revision error for '%s', got %#v", revision, err)
+		}
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written by git, got %#v", err)
+	}
+	if _, err := cluster.HostDiff("serial-1", "unknown", ""); !IsNotFound(err) {
+		t.Fatalf("expected not found error for unknown revision, got %#v", err)
+	}
+
+	_, err = cluster.HostHistory("unknown")
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found error, got %#v", err)
+	}
+
+	// serials never reach beyond the directory of a single host
+	for _, serial := range []string{"", ".", "..", ".git", "serial-1/..", "../serial-1"} {
+		if err := cluster.RollbackHost(serial, revisions[2].ID, "rollback"); !IsInvalidSerial(err) {
+			t.Errorf("expected invalid serial error for '%s', got %#v", serial, err)
+		}
+		if _, err := cluster.HostHistory(serial); !IsInvalidSerial(err) {
+			t.Errorf("expected invalid serial error for '%s', got %#v", serial, err)
+		}
+		if _, err := cluster.HostDiff(serial, revisions[2].ID, ""); !IsInvalidSerial(err) {
+			t.Errorf("expected invalid serial error for '%s', got %#v", serial, err)
+		}
+	}
+	for _, serial := range []string{"unknown", "*", ":(glob)*"} {
+		if err := cluster.RollbackHost(serial, revisions[2].ID, "rollback"); !IsNotFound(err) {
+			t.Errorf("expected not found error for '%s', got %#v", serial, err)
+		}
+	}
+	revisions, err = cluster.HostHistory("serial-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("expected no further rollback to be recorded, got %#v", revisions)
+	}
+}
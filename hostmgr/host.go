@@ -2,9 +2,9 @@ package hostmgr
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"net"
-	"os"
 	"path"
 	"time"
 
@@ -15,6 +15,9 @@ const hostConfFile = "conf.json"
 
 // Host represents a node within the mayu cluster.
 type Host struct {
+	// Version is the schema version of the host's conf.json.
+	Version int
+
 	Id               int               `json:",omitempty"`
 	ProviderId       string            `json:",omitempty"`
 	Enabled          bool              `json:",omitempty"`
@@ -36,8 +39,16 @@ type Host struct {
 
 	FlatcarVersion string `json:",omitempty"`
 
-	hostDir     *os.File
-	lastModTime time.Time
+	// IgnitionReport keeps the problems found in the Ignition config last
+	// rendered for the host. It is nil in case there were none.
+	IgnitionReport *IgnitionReport `json:",omitempty"`
+
+	// dir is the name of the host's directory within the cluster, which is
+	// the lower case serial the host got created with.
+	dir     string
+	storage Storage
+	// cluster is nil for hosts loaded without a cluster, eg. via HostFromDir
+	cluster *Cluster
 }
 
 type IPMac struct {
@@ -57,48 +68,62 @@ func genMachineID() string {
 // HostFromDir takes a path to a host directory within the cluster directory
 // and loads the found configuration. Then the corresponding Host is returned.
 func HostFromDir(hostdir string) (*Host, error) {
-	confPath := path.Join(hostdir, hostConfFile)
-
-	h := &Host{}
-	err := loadJson(h, confPath)
+	storage, err := NewDirStorage(path.Dir(hostdir))
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
 
-	h.hostDir, err = os.Open(hostdir)
+	h, err := loadHost(storage, path.Base(hostdir))
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
 
-	fi, err := os.Stat(confPath)
+	return h, nil
+}
+
+func loadHost(storage Storage, dir string) (*Host, error) {
+	h, _, err := readHost(storage, dir)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
-	h.lastModTime = fi.ModTime()
 
 	return h, nil
 }
 
-func createHost(serial string, hostDir string) (*Host, error) {
-	var err error
-	if !fileExists(hostDir) {
-		err = os.Mkdir(hostDir, 0755)
-		if err != nil {
-			return nil, microerror.Mask(err)
-		}
+// readHost loads the host kept in dir along with the checksum of its
+// configuration.
+func readHost(storage Storage, dir string) (*Host, [sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+
+	data, err := storage.Get(hostConfKey(dir))
+	if err != nil {
+		return nil, digest, microerror.Mask(err)
 	}
 
-	hostDirFile, err := os.Open(hostDir)
+	h := &Host{}
+	err = unmarshalJson(hostConfKey(dir), data, h)
 	if err != nil {
-		return nil, microerror.Mask(err)
+		return nil, digest, microerror.Mask(err)
 	}
+	if h.Version > HostSchemaVersion {
+		return nil, digest, microerror.Maskf(unsupportedVersionError, "'%s' has schema version %d, this version of mayu supports version %d or older", hostConfKey(dir), h.Version, HostSchemaVersion)
+	}
+
+	h.dir = dir
+	h.storage = storage
 
+	return h, sha256.Sum256(data), nil
+}
+
+func createHost(storage Storage, serial string) (*Host, error) {
 	h := &Host{
-		hostDir: hostDirFile,
 		Serial:  serial,
 		Enabled: true,
+
+		dir:     serial,
+		storage: storage,
 	}
-	err = h.Save()
+	err := h.Save()
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
@@ -107,20 +132,66 @@ func createHost(serial string, hostDir string) (*Host, error) {
 }
 
 func (h *Host) Save() error {
-	err := saveJson(h, h.confPath())
+	if h.storage == nil {
+		return microerror.Maskf(executionFailedError, "host '%s' is not part of a cluster", h.Serial)
+	}
+
+	h.Version = HostSchemaVersion
+	data, err := marshalJson(h)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	fi, err := os.Stat(h.confPath())
+	err = h.storage.Put(hostConfKey(h.dir), data)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	h.lastModTime = fi.ModTime()
+	if h.cluster != nil {
+		h.cluster.indexHost(h, sha256.Sum256(data))
+	}
+
 	return nil
 }
 
-func (h *Host) confPath() string {
-	return path.Join(h.hostDir.Name(), hostConfFile)
+// clone returns a copy of the host which can be changed without affecting
+// the host it got cloned from.
+func (h *Host) clone() *Host {
+	c := *h
+
+	if h.MacAddresses != nil {
+		c.MacAddresses = append([]string(nil), h.MacAddresses...)
+	}
+	if h.AdditionalAddrs != nil {
+		c.AdditionalAddrs = make(map[string]net.IP, len(h.AdditionalAddrs))
+		for nic, ip := range h.AdditionalAddrs {
+			c.AdditionalAddrs[nic] = ip
+		}
+	}
+	if h.Overrides != nil {
+		c.Overrides = make(map[string]interface{}, len(h.Overrides))
+		for k, v := range h.Overrides {
+			c.Overrides[k] = v
+		}
+	}
+
+	return &c
+}
+
+// Commit saves the host and records all pending changes of the cluster the
+// host belongs to using the given message.
+func (h *Host) Commit(msg string) error {
+	err := h.Save()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if h.cluster != nil {
+		err = h.cluster.commitChanges(msg)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
 }
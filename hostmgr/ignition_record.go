@@ -0,0 +1,55 @@
+package hostmgr
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const ignitionFile = "ignition.json"
+
+// IgnitionRecord is the Ignition config a host received last. It is stored
+// next to the host configuration within the cluster directory. Encrypted
+// template variables are masked in Config, so that no secrets are kept in
+// plain text.
+type IgnitionRecord struct {
+	Time   time.Time
+	Config json.RawMessage
+}
+
+// LastIgnition loads the Ignition config the host received last. In case the
+// host did not receive one yet, an error asserted by IsNotFound is returned.
+func (h *Host) LastIgnition() (*IgnitionRecord, error) {
+	if h.storage == nil {
+		return nil, microerror.Maskf(notFoundError, "no ignition config recorded for host '%s'", h.Serial)
+	}
+
+	record := &IgnitionRecord{}
+	err := loadJson(h.storage, ignitionKey(h.dir), record)
+	if IsNotFound(err) {
+		return nil, microerror.Maskf(notFoundError, "no ignition config recorded for host '%s'", h.Serial)
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return record, nil
+}
+
+// SetLastIgnition records the JSON Ignition config the host received. It is
+// part of the next commit of the host.
+func (h *Host) SetLastIgnition(config []byte) error {
+	if h.storage == nil {
+		return microerror.Maskf(executionFailedError, "host '%s' is not part of a cluster", h.Serial)
+	}
+
+	err := saveJson(h.storage, ignitionKey(h.dir), IgnitionRecord{
+		Time:   time.Now().UTC(),
+		Config: json.RawMessage(config),
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
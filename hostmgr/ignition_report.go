@@ -0,0 +1,55 @@
+package hostmgr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IgnitionReport lists the problems found validating the Ignition config
+// rendered for a host against the Ignition spec.
+type IgnitionReport struct {
+	// Version is the Ignition spec version the config got validated
+	// against.
+	Version  string
+	Time     time.Time
+	Errors   []IgnitionReportEntry `json:",omitempty"`
+	Warnings []IgnitionReportEntry `json:",omitempty"`
+}
+
+// IgnitionReportEntry is a single problem found in an Ignition config.
+type IgnitionReportEntry struct {
+	// Path locates the problem within the config, eg.
+	// "$.storage.files.0.path". It is empty for problems of spec 2 configs.
+	Path    string `json:",omitempty"`
+	Message string
+}
+
+// Valid checks whether the config can be served, ie. no errors were found.
+// Warnings do not keep Ignition from applying a config.
+func (r IgnitionReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Empty checks whether neither errors nor warnings were found.
+func (r IgnitionReport) Empty() bool {
+	return len(r.Errors) == 0 && len(r.Warnings) == 0
+}
+
+func (r IgnitionReport) String() string {
+	var lines []string
+	for _, e := range r.Errors {
+		lines = append(lines, "error"+e.String())
+	}
+	for _, e := range r.Warnings {
+		lines = append(lines, "warning"+e.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e IgnitionReportEntry) String() string {
+	if e.Path == "" {
+		return fmt.Sprintf(": %s", e.Message)
+	}
+	return fmt.Sprintf(" at %s: %s", e.Path, e.Message)
+}
@@ -0,0 +1,167 @@
+package hostmgr
+
+import (
+	"crypto/sha256"
+	"net"
+	"strings"
+)
+
+// hostIndex keeps all hosts of a cluster in memory. Next to the primary index
+// by serial, hosts are indexed by the attributes mayu looks them up by, so
+// that no lookup has to touch the storage or iterate all hosts. The index is
+// not safe for concurrent use, the cluster guards it using its mutex.
+type hostIndex struct {
+	bySerial  map[string]*Host
+	byIP      map[string]map[string]*Host
+	byMAC     map[string]map[string]*Host
+	byProfile map[string]map[string]*Host
+	byState   map[hostState]map[string]*Host
+	// byAdditionalAddr indexes the addresses of the extra NICs by the key
+	// returned by additionalAddrKey.
+	byAdditionalAddr map[string]map[string]*Host
+
+	// entries remembers the indexed attributes of every host, because hosts
+	// are changed in place before they are saved and reindexed.
+	entries map[string]indexEntry
+}
+
+type indexEntry struct {
+	ip              string
+	macs            []string
+	additionalAddrs []string
+	profile         string
+	state           hostState
+	// digest is the checksum of the configuration the host got indexed
+	// with. It identifies changes of the storage caused by the cluster
+	// itself.
+	digest [sha256.Size]byte
+}
+
+func newHostIndex() *hostIndex {
+	return &hostIndex{
+		bySerial:         map[string]*Host{},
+		byIP:             map[string]map[string]*Host{},
+		byMAC:            map[string]map[string]*Host{},
+		byProfile:        map[string]map[string]*Host{},
+		byState:          map[hostState]map[string]*Host{},
+		byAdditionalAddr: map[string]map[string]*Host{},
+		entries:          map[string]indexEntry{},
+	}
+}
+
+// put indexes the given host, replacing any host indexed with the same
+// serial. digest is the checksum of the configuration the host was saved or
+// loaded with.
+func (i *hostIndex) put(h *Host, digest [sha256.Size]byte) {
+	serial := strings.ToLower(h.dir)
+	i.remove(serial)
+
+	e := indexEntry{
+		profile: h.Profile,
+		state:   h.State,
+		digest:  digest,
+	}
+	if h.InternalAddr != nil {
+		e.ip = h.InternalAddr.String()
+	}
+	for _, mac := range h.MacAddresses {
+		e.macs = append(e.macs, strings.ToLower(mac))
+	}
+	for nic, ip := range h.AdditionalAddrs {
+		if ip != nil {
+			e.additionalAddrs = append(e.additionalAddrs, additionalAddrKey(nic, ip))
+		}
+	}
+
+	i.bySerial[serial] = h
+	i.entries[serial] = e
+
+	if e.ip != "" {
+		addToSet(i.byIP, e.ip, serial, h)
+	}
+	for _, mac := range e.macs {
+		addToSet(i.byMAC, mac, serial, h)
+	}
+	for _, key := range e.additionalAddrs {
+		addToSet(i.byAdditionalAddr, key, serial, h)
+	}
+	if e.profile != "" {
+		addToSet(i.byProfile, e.profile, serial, h)
+	}
+	if i.byState[e.state] == nil {
+		i.byState[e.state] = map[string]*Host{}
+	}
+	i.byState[e.state][serial] = h
+}
+
+// remove drops the host given by serial from all indexes.
+func (i *hostIndex) remove(serial string) {
+	e, exists := i.entries[serial]
+	if !exists {
+		return
+	}
+
+	removeFromSet(i.byIP, e.ip, serial)
+	for _, mac := range e.macs {
+		removeFromSet(i.byMAC, mac, serial)
+	}
+	for _, key := range e.additionalAddrs {
+		removeFromSet(i.byAdditionalAddr, key, serial)
+	}
+	removeFromSet(i.byProfile, e.profile, serial)
+	delete(i.byState[e.state], serial)
+
+	delete(i.bySerial, serial)
+	delete(i.entries, serial)
+}
+
+// digest returns the checksum of the configuration the host given by serial
+// got indexed with.
+func (i *hostIndex) digest(serial string) ([sha256.Size]byte, bool) {
+	e, exists := i.entries[serial]
+	return e.digest, exists
+}
+
+// additionalAddrKey returns the key of the address ip of the extra NIC given
+// by nic within byAdditionalAddr.
+func additionalAddrKey(nic string, ip net.IP) string {
+	return nic + "/" + ip.String()
+}
+
+func addToSet(index map[string]map[string]*Host, key, serial string, h *Host) {
+	if index[key] == nil {
+		index[key] = map[string]*Host{}
+	}
+	index[key][serial] = h
+}
+
+func removeFromSet(index map[string]map[string]*Host, key, serial string) {
+	delete(index[key], serial)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// anyHost returns one host of the given set. Sets of the unique attributes
+// only contain more than one host when the cluster directory got changed
+// manually, so the host with the lowest serial is returned to stay
+// deterministic.
+func anyHost(set map[string]*Host) (*Host, bool) {
+	var found *Host
+	var foundSerial string
+	for serial, h := range set {
+		if found == nil || serial < foundSerial {
+			found = h
+			foundSerial = serial
+		}
+	}
+	return found, found != nil
+}
+
+func hostList(set map[string]*Host) []*Host {
+	hosts := make([]*Host, 0, len(set))
+	for _, h := range set {
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
@@ -0,0 +1,243 @@
+package hostmgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func TestHostIndex(t *testing.T) {
+	storage, cleanup := newTestStorage(t, StorageDir)
+	defer cleanup()
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := NewClusterWithStorage(storage, false, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+
+	host, err := cluster.CreateNewHost("Serial-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.InternalAddr = net.ParseIP("10.0.0.1")
+	host.MacAddresses = []string{"00:16:3E:A0:B7:DF"}
+	host.AdditionalAddrs = map[string]net.IP{"em2": net.ParseIP("10.1.0.1")}
+	host.Profile = "core"
+	host.State = Configured
+	if err := host.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	found, exists := cluster.HostWithInternalAddr(net.ParseIP("10.0.0.1"))
+	if !exists || found.Serial != "serial-1" {
+		t.Fatalf("expected host by internal address, got %#v", found)
+	}
+	found, exists = cluster.HostWithMacAddress("00:16:3e:a0:b7:df")
+	if !exists || found.Serial != "serial-1" {
+		t.Fatalf("expected host by mac address, got %#v", found)
+	}
+	found, exists = cluster.HostWithAdditionalAddr("em2", net.ParseIP("10.1.0.1"))
+	if !exists || found.Serial != "serial-1" {
+		t.Fatalf("expected host by additional address, got %#v", found)
+	}
+	if _, exists := cluster.HostWithAdditionalAddr("em3", net.ParseIP("10.1.0.1")); exists {
+		t.Fatalf("expected additional address of other NIC not to be found")
+	}
+	if hosts := cluster.HostsWithProfile("core"); len(hosts) != 1 {
+		t.Fatalf("expected 1 host with profile, got %d", len(hosts))
+	}
+	if hosts := cluster.HostsWithState(Configured); len(hosts) != 1 {
+		t.Fatalf("expected 1 configured host, got %d", len(hosts))
+	}
+
+	// changes are only visible once the host is saved
+	found.InternalAddr = net.ParseIP("10.0.0.2")
+	found.AdditionalAddrs["em2"] = net.ParseIP("10.1.0.2")
+	found.Profile = "worker"
+	if _, exists := cluster.HostWithInternalAddr(net.ParseIP("10.0.0.2")); exists {
+		t.Fatalf("expected unsaved change to be invisible")
+	}
+	if err := found.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists := cluster.HostWithInternalAddr(net.ParseIP("10.0.0.1")); exists {
+		t.Fatalf("expected old internal address to be removed from index")
+	}
+	if _, exists := cluster.HostWithInternalAddr(net.ParseIP("10.0.0.2")); !exists {
+		t.Fatalf("expected new internal address to be indexed")
+	}
+	if _, exists := cluster.HostWithAdditionalAddr("em2", net.ParseIP("10.1.0.1")); exists {
+		t.Fatalf("expected old additional address to be removed from index")
+	}
+	if _, exists := cluster.HostWithAdditionalAddr("em2", net.ParseIP("10.1.0.2")); !exists {
+		t.Fatalf("expected new additional address to be indexed")
+	}
+	count := cluster.GetProfileCount()
+	if count["core"] != 0 || count["worker"] != 1 {
+		t.Fatalf("unexpected profile count %v", count)
+	}
+}
+
+func TestWatchPicksUpExternalChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostmgr_watch_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := NewCluster(dir, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+	err = cluster.Watch()
+	if err != nil {
+		t.Fatalf("watching cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	// prepare the host outside of the cluster directory and move it in at
+	// once, like an admin restoring a backup would do
+	staging, err := ioutil.TempDir("", "hostmgr_watch_staging_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(staging)
+	err = ioutil.WriteFile(filepath.Join(staging, hostConfFile), []byte(`{"Serial":"serial-1","Profile":"core"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(staging, filepath.Join(dir, "serial-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "external host to be indexed", func() bool {
+		_, exists := cluster.HostWithSerial("serial-1")
+		return exists
+	})
+
+	err = ioutil.WriteFile(filepath.Join(dir, "serial-1", hostConfFile), []byte(`{"Serial":"serial-1","Profile":"worker"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "external change to be indexed", func() bool {
+		return len(cluster.HostsWithProfile("worker")) == 1
+	})
+
+	err = os.RemoveAll(filepath.Join(dir, "serial-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "removed host to be dropped", func() bool {
+		_, exists := cluster.HostWithSerial("serial-1")
+		return !exists
+	})
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newBenchmarkCluster returns a cluster with n hosts in its index. The hosts
+// are not persisted, as the benchmarks only cover lookups.
+func newBenchmarkCluster(b *testing.B, n int) *Cluster {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c := &Cluster{
+		index:  newHostIndex(),
+		mu:     new(sync.Mutex),
+		logger: logger,
+	}
+
+	for i := 0; i < n; i++ {
+		h := &Host{
+			Serial:       fmt.Sprintf("serial-%d", i),
+			InternalAddr: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)),
+			MacAddresses: []string{fmt.Sprintf("00:16:3e:%02x:%02x:%02x", byte(i>>16), byte(i>>8), byte(i))},
+			Profile:      fmt.Sprintf("profile-%d", i%10),
+			State:        Running,
+
+			dir: fmt.Sprintf("serial-%d", i),
+		}
+		c.index.put(h, [32]byte{})
+	}
+
+	return c
+}
+
+var benchmarkSizes = []int{100, 1000, 10000}
+
+func BenchmarkHostWithSerial(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("hosts=%d", n), func(b *testing.B) {
+			c := newBenchmarkCluster(b, n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, exists := c.HostWithSerial(fmt.Sprintf("serial-%d", i%n)); !exists {
+					b.Fatal("host not found")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHostWithInternalAddr(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("hosts=%d", n), func(b *testing.B) {
+			c := newBenchmarkCluster(b, n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				j := i % n
+				if _, exists := c.HostWithInternalAddr(net.IPv4(10, byte(j>>16), byte(j>>8), byte(j))); !exists {
+					b.Fatal("host not found")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetProfileCount(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("hosts=%d", n), func(b *testing.B) {
+			c := newBenchmarkCluster(b, n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if count := c.GetProfileCount(); len(count) != 10 {
+					b.Fatalf("unexpected profile count %v", count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package hostmgr
+
+import (
+	"strings"
+	"time"
+
+	"github.com/giantswarm/mayu-infopusher/machinedata"
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	inventoryFile = "inventory.json"
+
+	// InventoryVersion is the version of the inventory file format written by
+	// this version of mayu.
+	InventoryVersion = 1
+)
+
+// Inventory is the hardware inventory of a host as reported by
+// mayu-infopusher. It is stored next to the host configuration within the
+// cluster directory.
+type Inventory struct {
+	// Version is the version of the inventory file format.
+	Version int
+	// Revision is incremented every time a new inventory is reported for the
+	// host.
+	Revision  int
+	UpdatedAt time.Time
+
+	HostData machinedata.HostData
+}
+
+// MacAddresses returns the MAC addresses of all network devices found in the
+// inventory.
+func (i *Inventory) MacAddresses() []string {
+	macs := []string{}
+	for _, dev := range i.HostData.NetDevs {
+		if dev.MacAddress != "" {
+			macs = append(macs, strings.ToLower(dev.MacAddress))
+		}
+	}
+	return macs
+}
+
+// Inventory loads the hardware inventory of the host. In case no inventory
+// was reported yet, an error asserted by IsNotFound is returned.
+func (h *Host) Inventory() (*Inventory, error) {
+	if h.storage == nil {
+		return nil, microerror.Maskf(notFoundError, "no inventory for host '%s'", h.Serial)
+	}
+
+	inv := &Inventory{}
+	err := loadJson(h.storage, inventoryKey(h.dir), inv)
+	if IsNotFound(err) {
+		return nil, microerror.Maskf(notFoundError, "no inventory for host '%s'", h.Serial)
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return inv, nil
+}
+
+// SetInventory stores the given hardware data as new revision of the host's
+// inventory.
+func (h *Host) SetInventory(data machinedata.HostData) (*Inventory, error) {
+	if h.storage == nil {
+		return nil, microerror.Maskf(executionFailedError, "host '%s' is not part of a cluster", h.Serial)
+	}
+
+	revision := 0
+	current, err := h.Inventory()
+	if IsNotFound(err) {
+		// fall through with the first revision
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	} else {
+		revision = current.Revision
+	}
+
+	inv := &Inventory{
+		Version:   InventoryVersion,
+		Revision:  revision + 1,
+		UpdatedAt: time.Now(),
+		HostData:  data,
+	}
+
+	err = saveJson(h.storage, inventoryKey(h.dir), inv)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return inv, nil
+}
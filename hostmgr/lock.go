@@ -0,0 +1,63 @@
+package hostmgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+const lockFile = ".lock"
+
+// ClusterLock is an advisory lock on a cluster directory. It prevents several
+// mayu processes, or mayu and its commands, from changing the same cluster
+// directory at the same time.
+type ClusterLock struct {
+	file *os.File
+}
+
+// LockClusterDir acquires the advisory lock of the cluster directory given by
+// baseDir. In case another process holds the lock, an error asserted by
+// IsLocked is returned right away.
+func LockClusterDir(baseDir string) (*ClusterLock, error) {
+	err := os.MkdirAll(baseDir, 0755)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	p := filepath.Join(baseDir, lockFile)
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = lockFileExclusive(f)
+	if err != nil {
+		f.Close()
+		owner, _ := ioutil.ReadFile(p)
+		return nil, microerror.Maskf(lockedError, "cluster directory %s is locked by pid %s", baseDir, strings.TrimSpace(string(owner)))
+	}
+
+	// record the owner to ease debugging, the lock itself is the flock
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+
+	l := &ClusterLock{
+		file: f,
+	}
+
+	return l, nil
+}
+
+// Unlock releases the lock.
+func (l *ClusterLock) Unlock() error {
+	err := unlockFile(l.file)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return l.file.Close()
+}
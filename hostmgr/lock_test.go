@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package hostmgr
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLockClusterDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostmgr_lock_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := LockClusterDir(dir)
+	if err != nil {
+		t.Fatalf("locking cluster directory: %s", err)
+	}
+
+	_, err = LockClusterDir(dir)
+	if !IsLocked(err) {
+		t.Fatalf("expected locked error, got %#v", err)
+	}
+
+	err = lock.Unlock()
+	if err != nil {
+		t.Fatalf("unlocking cluster directory: %s", err)
+	}
+
+	lock, err = LockClusterDir(dir)
+	if err != nil {
+		t.Fatalf("locking released cluster directory: %s", err)
+	}
+	_ = lock.Unlock()
+}
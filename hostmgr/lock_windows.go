@@ -0,0 +1,14 @@
+package hostmgr
+
+import "os"
+
+// mayu itself does not run on windows, the client package using hostmgr does.
+// There is no advisory locking there.
+
+func lockFileExclusive(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package hostmgr
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+)
+
+const (
+	// ClusterSchemaVersion is the version of cluster.json written by this
+	// version of mayu.
+	ClusterSchemaVersion = 1
+	// HostSchemaVersion is the version of the host conf.json files written
+	// by this version of mayu.
+	HostSchemaVersion = 1
+
+	// backupDir keeps archives of the cluster state taken before migrating
+	// it.
+	backupDir = ".backups"
+)
+
+// migration upgrades a decoded file from version To-1 to version To.
+type migration struct {
+	To          int
+	Description string
+	Migrate     func(doc map[string]interface{}) error
+}
+
+// clusterMigrations upgrade cluster.json. The migration to version N must be
+// at index N-1.
+var clusterMigrations = []migration{
+	{
+		To:          1,
+		Description: "introduce the schema version",
+		Migrate:     func(doc map[string]interface{}) error { return nil },
+	},
+}
+
+// hostMigrations upgrade the host conf.json files. The migration to version N
+// must be at index N-1.
+var hostMigrations = []migration{
+	{
+		To:          1,
+		Description: "introduce the schema version",
+		Migrate:     func(doc map[string]interface{}) error { return nil },
+	},
+}
+
+// MigrateSchema upgrades cluster.json and all host conf.json files kept by
+// the given storage to the schema versions of this version of mayu, one
+// version at a time. Before anything is changed, the cluster state is backed
+// up as archive below .backups. In case any file is newer than supported, an
+// error asserted by IsUnsupportedVersion is returned and nothing is changed.
+// The number of migrated files is returned.
+func MigrateSchema(storage Storage, logger micrologger.Logger) (int, error) {
+	entries, err := archiveEntries(storage)
+	if err != nil {
+		return 0, microerror.Mask(err)
+	}
+
+	migrated, err := migrateEntries(entries, logger)
+	if err != nil {
+		return 0, microerror.Mask(err)
+	}
+	if len(migrated) == 0 {
+		return 0, nil
+	}
+
+	backup, err := backupCluster(storage)
+	if err != nil {
+		return 0, microerror.Mask(err)
+	}
+	_ = logger.Log("level", "info", "message", fmt.Sprintf("backed up cluster state to '%s' before migrating %d files", backup, len(migrated)))
+
+	err = storage.Batch(migrated)
+	if err != nil {
+		return 0, microerror.Mask(err)
+	}
+
+	return len(migrated), nil
+}
+
+// migrateEntries upgrades cluster.json and the host conf.json files within
+// the given entries and returns the upgraded ones. The given entries are not
+// changed.
+func migrateEntries(entries map[string][]byte, logger micrologger.Logger) (map[string][]byte, error) {
+	files := map[string][]migration{}
+	if _, exists := entries[clusterConfFile]; exists {
+		files[clusterConfFile] = clusterMigrations
+	}
+	for _, serial := range hostSerials(sortedKeys(entries)) {
+		files[hostConfKey(serial)] = hostMigrations
+	}
+
+	migrated := map[string][]byte{}
+	for key, migrations := range files {
+		doc := map[string]interface{}{}
+		err := unmarshalJson(key, entries[key], &doc)
+		if IsInvalidFormat(err) {
+			// corrupt files are reported, or quarantined in case of hosts,
+			// when loading the cluster
+			continue
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		version, err := schemaVersion(key, doc)
+		if IsInvalidFormat(err) {
+			continue
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		if version > len(migrations) {
+			return nil, microerror.Maskf(unsupportedVersionError, "'%s' has schema version %d, this version of mayu supports version %d or older", key, version, len(migrations))
+		}
+		if version == len(migrations) {
+			continue
+		}
+
+		for _, m := range migrations[version:] {
+			err = m.Migrate(doc)
+			if err != nil {
+				return nil, microerror.Maskf(executionFailedError, "migrating '%s' to schema version %d: %s", key, m.To, err)
+			}
+			doc["Version"] = m.To
+			_ = logger.Log("level", "info", "message", fmt.Sprintf("migrated '%s' to schema version %d: %s", key, m.To, m.Description))
+		}
+
+		migrated[key], err = marshalJson(doc)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	return migrated, nil
+}
+
+// backupCluster stores an archive of the current cluster state below
+// backupDir and returns its key.
+func backupCluster(storage Storage) (string, error) {
+	buf := &bytes.Buffer{}
+	err := ExportCluster(storage, buf)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	key := path.Join(backupDir, fmt.Sprintf("%s.tar.gz", time.Now().UTC().Format("20060102T150405.000000000Z")))
+	err = storage.Put(key, buf.Bytes())
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return key, nil
+}
+
+// schemaVersion returns the schema version of a decoded file. Files written
+// before the schema version got introduced are version 0.
+func schemaVersion(key string, doc map[string]interface{}) (int, error) {
+	v, exists := doc["Version"]
+	if !exists {
+		return 0, nil
+	}
+
+	// JSON numbers are decoded as float64
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != float64(int(f)) {
+		return 0, microerror.Maskf(invalidFormatError, "'%s' has an invalid schema version '%v'", key, v)
+	}
+
+	return int(f), nil
+}
@@ -0,0 +1,134 @@
+package hostmgr
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []string{StorageDir, StorageBolt} {
+		t.Run(kind, func(t *testing.T) {
+			storage, cleanup := newTestStorage(t, kind)
+			defer cleanup()
+
+			// files written before the schema version got introduced
+			legacy := map[string][]byte{
+				"cluster.json":       []byte(`{"Config":{"DefaultEtcdClusterToken":"token"}}`),
+				"serial-1/conf.json": []byte(`{"Serial":"serial-1","Profile":"core","State":"running"}`),
+			}
+			if err := storage.Batch(legacy); err != nil {
+				t.Fatal(err)
+			}
+
+			cluster, err := OpenClusterWithStorage(storage, false, logger)
+			if err != nil {
+				t.Fatalf("opening legacy cluster: %s", err)
+			}
+			if cluster.Version != ClusterSchemaVersion {
+				t.Fatalf("expected cluster schema version %d, got %d", ClusterSchemaVersion, cluster.Version)
+			}
+			host, exists := cluster.HostWithSerial("serial-1")
+			if !exists {
+				t.Fatalf("expected migrated host")
+			}
+			if host.Version != HostSchemaVersion || host.Profile != "core" || host.State != Running {
+				t.Fatalf("unexpected migrated host %#v", host)
+			}
+
+			backup := findBackup(t, storage)
+			if backup == "" {
+				t.Fatalf("expected backup below %s", backupDir)
+			}
+			data, err := storage.Get(backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, _, err := ReadArchive(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("reading backup: %s", err)
+			}
+			for key, value := range legacy {
+				if !bytes.Equal(entries[key], value) {
+					t.Fatalf("expected backup of '%s' to hold '%s', got '%s'", key, value, entries[key])
+				}
+			}
+
+			migrated, err := MigrateSchema(storage, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != 0 {
+				t.Fatalf("expected current files to be left alone, got %d migrated", migrated)
+			}
+		})
+	}
+}
+
+func TestMigrateSchemaRefusesNewerFiles(t *testing.T) {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, cleanup := newTestStorage(t, StorageDir)
+	defer cleanup()
+
+	err = storage.Batch(map[string][]byte{
+		"cluster.json":       []byte(`{"Config":{}}`),
+		"serial-1/conf.json": []byte(`{"Version":99,"Serial":"serial-1"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenClusterWithStorage(storage, false, logger)
+	if !IsUnsupportedVersion(err) {
+		t.Fatalf("expected unsupported version error, got %#v", err)
+	}
+
+	data, err := storage.Get(clusterConfFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"Config":{}}` {
+		t.Fatalf("expected cluster.json to be left alone, got '%s'", data)
+	}
+}
+
+func findBackup(t *testing.T, storage Storage) string {
+	// the dir storage does not list entries below dot directories
+	if dirStorage, ok := storage.(*DirStorage); ok {
+		matches, err := filepath.Glob(filepath.Join(dirStorage.BaseDir(), backupDir, "*.tar.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 {
+			return ""
+		}
+		rel, err := filepath.Rel(dirStorage.BaseDir(), matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	keys, err := storage.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, backupDir+"/") {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package hostmgr
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	// StorageDir keeps the cluster state as JSON files within the cluster
+	// directory. This is the classic layout of cluster.json plus
+	// <serial>/conf.json.
+	StorageDir = "dir"
+	// StorageBolt keeps the cluster state within an embedded bbolt database
+	// stored in the cluster directory.
+	StorageBolt = "bolt"
+)
+
+// Storage persists the state of a cluster. Entries are addressed by slash
+// separated keys like "cluster.json" or "<serial>/conf.json" no matter how the
+// implementation actually stores them.
+type Storage interface {
+	// Get returns the value stored for key. In case the key does not exist,
+	// an error asserted by IsNotFound is returned.
+	Get(key string) ([]byte, error)
+	// Put stores value for key, replacing any existing value.
+	Put(key string, value []byte) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key string) error
+	// Keys returns all keys known to the storage in lexical order.
+	Keys() ([]string, error)
+	// Batch stores all given entries. Implementations supporting transactions
+	// store either all or none of them.
+	Batch(entries map[string][]byte) error
+	// Close releases all resources held by the storage.
+	Close() error
+}
+
+// NewStorage creates the storage implementation given by kind for the
+// cluster directory given by baseDir.
+func NewStorage(kind, baseDir string) (Storage, error) {
+	switch kind {
+	case StorageDir, "":
+		return NewDirStorage(baseDir)
+	case StorageBolt:
+		return NewBoltStorage(path.Join(baseDir, boltFile))
+	default:
+		return nil, microerror.Maskf(invalidConfigError, "unknown storage '%s'", kind)
+	}
+}
+
+// MigrateStorage copies all entries of the storage given by from to the
+// storage given by to. The target storage must not contain a cluster yet.
+func MigrateStorage(from, to Storage) error {
+	if ClusterExists(to) {
+		return microerror.Maskf(invalidConfigError, "target storage already contains a cluster")
+	}
+
+	keys, err := from.Keys()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	entries := map[string][]byte{}
+	for _, key := range keys {
+		value, err := from.Get(key)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		entries[key] = value
+	}
+
+	err = to.Batch(entries)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	migrated, err := to.Keys()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if len(migrated) != len(keys) {
+		return microerror.Maskf(executionFailedError, "migrated %d of %d entries", len(migrated), len(keys))
+	}
+
+	return nil
+}
+
+// ClusterExists checks whether the given storage already holds a cluster.
+func ClusterExists(storage Storage) bool {
+	_, err := storage.Get(clusterConfFile)
+	return err == nil
+}
+
+// isHostDir checks whether serial names a directory right within the
+// cluster directory, which excludes hidden directories like the one of the
+// git repository.
+func isHostDir(serial string) bool {
+	return serial != "" && !strings.HasPrefix(serial, ".") && !strings.ContainsAny(serial, `/\`)
+}
+
+func hostConfKey(serial string) string {
+	return path.Join(serial, hostConfFile)
+}
+
+func inventoryKey(serial string) string {
+	return path.Join(serial, inventoryFile)
+}
+
+func ignitionKey(serial string) string {
+	return path.Join(serial, ignitionFile)
+}
+
+// hostSerials returns the serials of all hosts found within the given keys.
+func hostSerials(keys []string) []string {
+	serials := []string{}
+	for _, key := range keys {
+		dir, file := path.Split(key)
+		dir = strings.TrimSuffix(dir, "/")
+		if file == hostConfFile && dir != "" && !strings.Contains(dir, "/") {
+			serials = append(serials, dir)
+		}
+	}
+	sort.Strings(serials)
+	return serials
+}
@@ -0,0 +1,152 @@
+package hostmgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func newTestStorage(t *testing.T, kind string) (Storage, func()) {
+	dir, err := ioutil.TempDir("", "hostmgr_storage_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewStorage(kind, dir)
+	if err != nil {
+		t.Fatalf("creating %s storage: %s", kind, err)
+	}
+
+	return storage, func() {
+		storage.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	for _, kind := range []string{StorageDir, StorageBolt} {
+		t.Run(kind, func(t *testing.T) {
+			storage, cleanup := newTestStorage(t, kind)
+			defer cleanup()
+
+			_, err := storage.Get("missing.json")
+			if !IsNotFound(err) {
+				t.Fatalf("expected not found error, got %#v", err)
+			}
+
+			err = storage.Batch(map[string][]byte{
+				"cluster.json":        []byte(`{}`),
+				"serial-1/conf.json":  []byte(`{"Serial":"serial-1"}`),
+				"serial-2/conf.json":  []byte(`{"Serial":"serial-2"}`),
+				"serial-2/other.json": []byte(`{}`),
+			})
+			if err != nil {
+				t.Fatalf("storing entries: %s", err)
+			}
+
+			value, err := storage.Get("serial-1/conf.json")
+			if err != nil {
+				t.Fatalf("reading entry: %s", err)
+			}
+			if string(value) != `{"Serial":"serial-1"}` {
+				t.Fatalf("unexpected value '%s'", value)
+			}
+
+			err = storage.Delete("serial-2/other.json")
+			if err != nil {
+				t.Fatalf("deleting entry: %s", err)
+			}
+
+			keys, err := storage.Keys()
+			if err != nil {
+				t.Fatalf("listing keys: %s", err)
+			}
+			expected := []string{"cluster.json", "serial-1/conf.json", "serial-2/conf.json"}
+			if !reflect.DeepEqual(keys, expected) {
+				t.Fatalf("expected keys %v, got %v", expected, keys)
+			}
+
+			serials := hostSerials(keys)
+			if !reflect.DeepEqual(serials, []string{"serial-1", "serial-2"}) {
+				t.Fatalf("unexpected serials %v", serials)
+			}
+		})
+	}
+}
+
+func TestMigrateStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostmgr_migrate_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := NewCluster(dir, logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+	cluster.Config.DefaultEtcdClusterToken = "token"
+	if err := cluster.Commit("set token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cluster.CreateNewHost("Serial-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	from, err := NewDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := path.Join(DiscoveryDir, "registry.json")
+	if err := from.Put(registry, []byte(`{"tokens":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	to, err := NewBoltStorage(filepath.Join(dir, boltFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+
+	err = MigrateStorage(from, to)
+	if err != nil {
+		t.Fatalf("migrating storage: %s", err)
+	}
+
+	migrated, err := OpenClusterWithStorage(to, false, logger)
+	if err != nil {
+		t.Fatalf("opening migrated cluster: %s", err)
+	}
+	if migrated.Config.DefaultEtcdClusterToken != "token" {
+		t.Fatalf("expected migrated token, got '%s'", migrated.Config.DefaultEtcdClusterToken)
+	}
+	host, exists := migrated.HostWithSerial("serial-1")
+	if !exists {
+		t.Fatalf("expected migrated host")
+	}
+	if host.Serial != "serial-1" {
+		t.Fatalf("unexpected host %#v", host)
+	}
+
+	value, err := to.Get(registry)
+	if err != nil {
+		t.Fatalf("expected migrated discovery registry, got %#v", err)
+	}
+	if string(value) != `{"tokens":{}}` {
+		t.Fatalf("unexpected discovery registry '%s'", value)
+	}
+
+	err = MigrateStorage(from, to)
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected migrating into an existing cluster to fail, got %#v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package hostmgr
+
+// Sources of template variables, from lowest to highest precedence.
+const (
+	TemplateVarSourceGlobal  = "global"
+	TemplateVarSourceCluster = "cluster"
+	TemplateVarSourceProfile = "profile"
+	TemplateVarSourceHost    = "host"
+)
+
+// TemplateVar explains the value a template variable has when rendering the
+// templates of a host.
+type TemplateVar struct {
+	Value interface{}
+	// Source is the layer the value is taken from, eg.
+	// TemplateVarSourceProfile.
+	Source string
+	// Overridden lists the values of lower layers replaced by Value, lowest
+	// first.
+	Overridden []TemplateVarValue `json:",omitempty"`
+}
+
+// TemplateVarValue is the value a single layer gives a template variable.
+type TemplateVarValue struct {
+	Source string
+	Value  interface{}
+}
@@ -2,39 +2,44 @@ package hostmgr
 
 import (
 	"encoding/json"
-	"os"
 
 	"github.com/giantswarm/microerror"
 )
 
-func saveJson(data interface{}, filepath string) error {
-	marshalled, err := json.MarshalIndent(data, "", "  ")
+func saveJson(storage Storage, key string, data interface{}) error {
+	marshalled, err := marshalJson(data)
 	if err != nil {
 		return microerror.Mask(err)
 	}
-	file, err := os.Create(filepath)
+
+	err = storage.Put(key, marshalled)
 	if err != nil {
 		return microerror.Mask(err)
 	}
-	defer file.Close()
-
-	_, _ = file.Write(marshalled)
 	return nil
 }
 
-func loadJson(target interface{}, filepath string) error {
-
-	jsonFile, err := os.Open(filepath)
+func loadJson(storage Storage, key string, target interface{}) error {
+	data, err := storage.Get(key)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	defer jsonFile.Close()
+	return unmarshalJson(key, data, target)
+}
 
-	jsonDec := json.NewDecoder(jsonFile)
-	err = jsonDec.Decode(target)
+func marshalJson(data interface{}) ([]byte, error) {
+	marshalled, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
+	}
+	return marshalled, nil
+}
+
+func unmarshalJson(key string, data []byte, target interface{}) error {
+	err := json.Unmarshal(data, target)
+	if err != nil {
+		return microerror.Maskf(invalidFormatError, "'%s': %s", key, err)
 	}
 	return nil
 }
@@ -0,0 +1,124 @@
+package hostmgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/giantswarm/microerror"
+)
+
+// Watch keeps the host index in sync with changes made to the cluster
+// directory from the outside, e.g. by manual edits or git operations. Only the
+// dir storage can be changed that way, so Watch does nothing for other
+// storages. Stop watching using Close.
+func (c *Cluster) Watch() error {
+	dirStorage, ok := c.storage.(*DirStorage)
+	if !ok {
+		return nil
+	}
+	baseDir := dirStorage.BaseDir()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = w.Add(baseDir)
+	if err != nil {
+		w.Close()
+		return microerror.Mask(err)
+	}
+
+	entries, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		w.Close()
+		return microerror.Mask(err)
+	}
+	for _, fi := range entries {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		err = w.Add(filepath.Join(baseDir, fi.Name()))
+		if err != nil {
+			w.Close()
+			return microerror.Mask(err)
+		}
+	}
+
+	c.watcher = w
+	go c.watch(w, baseDir)
+
+	return nil
+}
+
+// Close stops watching the cluster directory.
+func (c *Cluster) Close() error {
+	if c.watcher == nil {
+		return nil
+	}
+
+	err := c.watcher.Close()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (c *Cluster) watch(w *fsnotify.Watcher, baseDir string) {
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			c.handleEvent(w, baseDir, event)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			_ = c.logger.Log("level", "warning", "message", "watching the cluster directory failed, changes might be missed", "stack", err)
+		}
+	}
+}
+
+func (c *Cluster) handleEvent(w *fsnotify.Watcher, baseDir string, event fsnotify.Event) {
+	rel, err := filepath.Rel(baseDir, event.Name)
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, part := range parts {
+		// temporary files of atomic writes, git and quarantined hosts
+		if strings.HasPrefix(part, ".") {
+			return
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		if parts[0] == clusterConfFile {
+			return
+		}
+		// a host directory got created, removed or renamed
+		if event.Op&fsnotify.Create != 0 {
+			if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+				err = w.Add(event.Name)
+				if err != nil {
+					_ = c.logger.Log("level", "warning", "message", fmt.Sprintf("unable to watch host directory '%s'", event.Name), "stack", err)
+				}
+			}
+		}
+		c.reloadHost(parts[0])
+	case 2:
+		if parts[1] != hostConfFile {
+			return
+		}
+		c.reloadHost(parts[0])
+	}
+}
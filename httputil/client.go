@@ -14,3 +14,12 @@ func Put(url string, bodyType string, body io.Reader) (resp *http.Response, err
 	req.Header.Set("Content-Type", bodyType)
 	return http.DefaultClient.Do(req)
 }
+
+func Delete(url string) (resp *http.Response, err error) {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.DefaultClient.Do(req)
+}
@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/giantswarm/micrologger"
 	"github.com/spf13/cobra"
@@ -44,6 +47,7 @@ func init() {
 	pf.BoolVar(&globalFlags.help, "help", false, "Show mayu usage")
 	pf.StringVar(&globalFlags.configFile, "config", DefaultConfigFile, "Path to the configuration file")
 	pf.StringVar(&globalFlags.clusterDir, "cluster-directory", DefaultClusterDirectory, "Path to the cluster directory")
+	pf.StringVar(&globalFlags.storage, "storage", DefaultStorage, "Storage backend for the cluster state within the cluster directory (dir or bolt)")
 	pf.BoolVar(&globalFlags.showTemplates, "show-templates", DefaultShowTemplates, "Show the templates and quit")
 	pf.BoolVar(&globalFlags.noGit, "no-git", DefaultNoGit, "Disable git operations")
 	pf.BoolVar(&globalFlags.noTLS, "no-tls", DefaultNoTLS, "Disable tls")
@@ -61,13 +65,23 @@ func init() {
 	pf.StringVar(&globalFlags.tlsCertFile, "tls-cert-file", DefaultTLSCertFile, "Path to tls certificate file")
 	pf.StringVar(&globalFlags.tlsKeyFile, "tls-key-file", DefaultTLSKeyFile, "Path to tls key file")
 	pf.BoolVar(&globalFlags.useInternalEtcdDiscovery, "use-internal-etcd-discovery", DefaultUseInternalEtcdDiscovery, "Use the internal etcd discovery")
+	pf.StringVar(&globalFlags.etcdDiscoveryBackend, "etcd-discovery-backend", DefaultEtcdDiscoveryBackend, "Registry of the internal etcd discovery (embedded, etcd or etcd-v2). embedded keeps it in the cluster directory, etcd keeps it in etcd using the v3 API, etcd-v2 proxies to the v2 API of etcd.")
 	pf.IntVar(&globalFlags.etcdQuorumSize, "etcd-quorum-size", DefaultEtcdQuorumSize, "Default quorum of the etcd clusters")
 	pf.StringVar(&globalFlags.etcdDiscoveryUrl, "etcd-discovery", DefaultEtcdDiscoveryUrl, "External etcd discovery base url (eg https://discovery.etcd.io). Note: This should be the base URL of the discovery without a specific token. Mayu itself creates a token for the etcd clusters.")
-	pf.StringVar(&globalFlags.etcdEndpoint, "etcd-endpoint", DefaultEtcdEndpoint, "The etcd endpoint for the internal discovery feature (you must also specify protocol).")
+	pf.StringVar(&globalFlags.etcdEndpoint, "etcd-endpoint", DefaultEtcdEndpoint, "The etcd endpoint for the internal discovery using the etcd or etcd-v2 backend (you must also specify protocol).")
 	pf.StringVar(&globalFlags.etcdCAfile, "etcd-cafile", DefaultEtcdCA, "The etcd CA file, if etcd is using non-trustred root CA certificate")
+	pf.StringVar(&globalFlags.etcdCertFile, "etcd-certfile", DefaultEtcdCertFile, "The client certificate file to authenticate against etcd")
+	pf.StringVar(&globalFlags.etcdKeyFile, "etcd-keyfile", DefaultEtcdKeyFile, "The key file of the client certificate to authenticate against etcd")
+	pf.StringVar(&globalFlags.etcdUsername, "etcd-username", DefaultEtcdUsername, "The user to authenticate against etcd")
+	pf.StringVar(&globalFlags.etcdPassword, "etcd-password", DefaultEtcdPassword, "The password of the etcd user")
 	pf.BoolVar(&globalFlags.flatcarAutologin, "flatcar-autologin", DefaultFlatcarAutologin, "Sets kernel boot param 'flatcar.autologin'. This is handy for debugging. Do NOT use for production!")
 	pf.BoolVar(&globalFlags.consoleTTY, "console-tty", DefaultConsoleTTY, "Sets kernel boot param 'console=ttyS0'. This is handy for debugging.")
 	pf.BoolVar(&globalFlags.systemdShell, "systemd-shell", DefaultSystemdShell, "Sets kernel boot param 'rd.shell'. This will be activated if the initramfs fails to boot successfully.")
+	pf.BoolVar(&globalFlags.watchConfig, "watch-config", DefaultWatchConfig, "Reload the configuration file, templates, snippets and files on changes")
+	pf.StringVar(&globalFlags.secretsKeyFile, "secrets-key-file", DefaultSecretsKeyFile, "The age identity file decrypting the encrypted values of templates_env and host overrides")
+	pf.StringVar(&globalFlags.urlSigningKeyFile, "url-signing-key-file", DefaultURLSigningKeyFile, "The key signing the per-host tokens of the Ignition URLs, tokens are not required if unset")
+	pf.DurationVar(&globalFlags.urlTokenTTL, "url-token-ttl", DefaultURLTokenTTL, "Validity of the per-host tokens of the Ignition URLs")
+	pf.BoolVar(&globalFlags.preregisteredOnly, "preregistered-only", DefaultPreregisteredOnly, "Refuse to boot machines which are neither known nor listed in serials of the configuration")
 	globalFlags.filesystem = fs.DefaultFilesystem
 }
 
@@ -116,28 +130,85 @@ func mainRun(cmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
-	var cluster *hostmgr.Cluster
-
-	if fileExists(fmt.Sprintf("%s/cluster.json", globalFlags.clusterDir)) {
-		cluster, err = hostmgr.OpenCluster(globalFlags.clusterDir, logger)
-	} else {
-		cluster, err = hostmgr.NewCluster(globalFlags.clusterDir, logger)
+	lock, err := hostmgr.LockClusterDir(globalFlags.clusterDir)
+	if err != nil {
+		_ = logger.Log("level", "error", "message", "unable to lock the cluster directory", "stack", err)
+		os.Exit(1)
 	}
+	defer lock.Unlock()
 
+	cluster, err := openCluster(globalFlags.storage, globalFlags.clusterDir, logger)
 	if err != nil {
 		_ = logger.Log("level", "error", "message", "unable to get a cluster", "stack", err)
 		os.Exit(1)
 	}
 
-	globalFlags.templateSnippets = DefaultTemplateSnippets
+	err = cluster.Watch()
+	if err != nil {
+		_ = logger.Log("level", "error", "message", "unable to watch the cluster directory", "stack", err)
+		os.Exit(1)
+	}
+	defer cluster.Close()
+
+	pxeManager, err := pxemgr.PXEManager(pxeManagerConfiguration(logger), cluster)
+	if err != nil {
+		_ = logger.Log("level", "error", "message", "unable to create a pxe manager", "stack", err)
+		os.Exit(1)
+	}
+
+	if globalFlags.showTemplates {
+		b := bytes.NewBuffer(nil)
+		if err := pxeManager.WriteIgnitionConfigs(b); err != nil {
+			_ = logger.Log("level", "error", "message", "error found while checking generated ignition config ", "stack", err)
+			os.Exit(1)
+		}
+		os.Stdout.WriteString(b.String())
+
+		os.Exit(0)
+	}
+
+	// reload the configuration and templates on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := pxeManager.Reload(); err != nil {
+				_ = logger.Log("level", "error", "message", "reloading the configuration failed, keeping the running configuration", "stack", err)
+			}
+		}
+	}()
+
+	if globalFlags.watchConfig {
+		err = pxeManager.WatchConfig()
+		if err != nil {
+			_ = logger.Log("level", "error", "message", "unable to watch the configuration", "stack", err)
+			os.Exit(1)
+		}
+		defer pxeManager.Close()
+	}
+
+	err = pxeManager.Start()
+	if err != nil {
+		_ = logger.Log("level", "error", "message", err)
+		os.Exit(1)
+	}
+}
 
-	pxeManager, err := pxemgr.PXEManager(pxemgr.PXEManagerConfiguration{
+// pxeManagerConfiguration returns the configuration of the PXE manager given
+// by the global flags.
+func pxeManagerConfiguration(logger micrologger.Logger) pxemgr.PXEManagerConfiguration {
+	return pxemgr.PXEManagerConfiguration{
 		ConfigFile:               globalFlags.configFile,
 		UseInternalEtcdDiscovery: globalFlags.useInternalEtcdDiscovery,
+		EtcdDiscoveryBackend:     globalFlags.etcdDiscoveryBackend,
 		EtcdQuorumSize:           globalFlags.etcdQuorumSize,
 		EtcdDiscoveryUrl:         globalFlags.etcdDiscoveryUrl,
 		EtcdEndpoint:             globalFlags.etcdEndpoint,
 		EtcdCAFile:               globalFlags.etcdCAfile,
+		EtcdCertFile:             globalFlags.etcdCertFile,
+		EtcdKeyFile:              globalFlags.etcdKeyFile,
+		EtcdUsername:             globalFlags.etcdUsername,
+		EtcdPassword:             globalFlags.etcdPassword,
 		DNSmasqExecutable:        globalFlags.dnsmasq,
 		DNSmasqTemplate:          globalFlags.dnsmasqTemplate,
 		TFTPRoot:                 globalFlags.tFTPRoot,
@@ -156,39 +227,80 @@ func mainRun(cmd *cobra.Command, args []string) {
 		FlatcarAutologin:         globalFlags.flatcarAutologin,
 		ConsoleTTY:               globalFlags.consoleTTY,
 		SystemdShell:             globalFlags.systemdShell,
+		SecretsKeyFile:           globalFlags.secretsKeyFile,
+		URLSigningKeyFile:        globalFlags.urlSigningKeyFile,
+		URLTokenTTL:              globalFlags.urlTokenTTL,
+		PreregisteredOnly:        globalFlags.preregisteredOnly,
 		Version:                  projectVersion,
+		OpenCluster: func(dir string) (*hostmgr.Cluster, error) {
+			return openAdditionalCluster(dir, logger)
+		},
 
 		Logger: logger,
-	}, cluster)
+	}
+}
+
+// openCluster opens the cluster kept within the cluster directory given by dir
+// using the storage backend given by kind. In case there is no cluster yet, a
+// new one is created. Git history is recorded unless disabled using --no-git
+// or not supported by the storage backend.
+func openCluster(kind, dir string, logger micrologger.Logger) (*hostmgr.Cluster, error) {
+	storage, err := hostmgr.NewStorage(kind, dir)
 	if err != nil {
-		_ = logger.Log("level", "error", "message", "unable to create a pxe manager", "stack", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	if globalFlags.showTemplates {
-		placeholderHost := hostmgr.Host{}
+	git := !globalFlags.noGit
+	if git && kind != hostmgr.StorageDir {
+		_ = logger.Log("level", "info", "message", fmt.Sprintf("git history is not supported by the '%s' storage", kind))
+		git = false
+	}
 
-		b := bytes.NewBuffer(nil)
-		if err := pxeManager.WriteIgnitionConfig(placeholderHost, b); err != nil {
-			_ = logger.Log("level", "error", "message", "error found while checking generated ignition config ", "stack", err)
-			os.Exit(1)
-		}
-		os.Stdout.WriteString("ignition config:\n")
-		os.Stdout.WriteString(b.String())
+	if hostmgr.ClusterExists(storage) {
+		return hostmgr.OpenClusterWithStorage(storage, git, logger)
+	}
+	return hostmgr.NewClusterWithStorage(storage, git, logger)
+}
 
-		os.Exit(0)
+// additionalClusterLocks keeps the locks of the additional cluster
+// directories referenced, so they are not released before mayu exits.
+var additionalClusterLocks []*hostmgr.ClusterLock
+
+// openAdditionalCluster locks, opens and watches the cluster directory of an
+// additional cluster defined in the configuration. Relative directories are
+// relative to the parent of the --cluster-directory.
+func openAdditionalCluster(dir string, logger micrologger.Logger) (*hostmgr.Cluster, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(filepath.Clean(globalFlags.clusterDir)), dir)
 	}
 
-	err = pxeManager.Start()
+	base, err := filepath.Abs(globalFlags.clusterDir)
 	if err != nil {
-		_ = logger.Log("level", "error", "message", err)
-		os.Exit(1)
+		return nil, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(base, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("cluster directory %s must not be within %s", dir, globalFlags.clusterDir)
 	}
-}
 
-func fileExists(path string) bool {
-	if _, err := os.Stat(path); err == nil {
-		return true
+	lock, err := hostmgr.LockClusterDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	additionalClusterLocks = append(additionalClusterLocks, lock)
+
+	cluster, err := openCluster(globalFlags.storage, dir, logger)
+	if err != nil {
+		return nil, err
 	}
-	return false
+
+	err = cluster.Watch()
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
 }
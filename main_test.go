@@ -121,3 +121,28 @@ func TestHTTPCertConfigFileStatValidation(t *testing.T) {
 	}
 
 }
+
+func TestEtcdCertConfigValidation(t *testing.T) {
+	cases := []struct {
+		globalFlags    MayuFlags
+		expectedResult bool
+		expectedError  error
+	}{
+		{MayuFlags{etcdCertFile: "", etcdKeyFile: ""}, true, nil},
+		{MayuFlags{etcdCertFile: "certfile", etcdKeyFile: ""}, false, ErrNotAllEtcdCertFilesProvided},
+		{MayuFlags{etcdCertFile: "", etcdKeyFile: "keyfile"}, false, ErrNotAllEtcdCertFilesProvided},
+		{MayuFlags{etcdCertFile: "certfile", etcdKeyFile: "keyfile"}, true, nil},
+	}
+
+	for _, c := range cases {
+		result, err := c.globalFlags.ValidateEtcdCertificateUsage()
+
+		if result != c.expectedResult {
+			t.Errorf("expected function ValidateEtcdCertificateUsage() to return %v for configuration :%#v", c.expectedResult, c.globalFlags)
+		}
+
+		if err != c.expectedError {
+			t.Errorf("expected function ValidateEtcdCertificateUsage() to return error '%s' but got '%s' for configuration :%#v", c.expectedError, err, c.globalFlags)
+		}
+	}
+}
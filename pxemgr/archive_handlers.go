@@ -0,0 +1,54 @@
+package pxemgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func (mgr *pxeManagerT) clusterExport(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	err := mgr.cluster.Export(buf)
+	if err != nil {
+		mgr.httpError(w, fmt.Sprintf("exporting cluster failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"mayu-cluster-%s.tar.gz\"", time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(200)
+	_, _ = buf.WriteTo(w)
+}
+
+// clusterImport replaces the cluster state with the uploaded archive. Using
+// the query parameter dry_run=true, only the changes the import would make are
+// returned.
+func (mgr *pxeManagerT) clusterImport(w http.ResponseWriter, r *http.Request) {
+	entries, _, err := hostmgr.ReadArchive(r.Body)
+	if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to read cluster archive: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var diff hostmgr.ArchiveDiff
+	if r.URL.Query().Get("dry_run") == "true" {
+		diff, err = hostmgr.DiffImport(mgr.cluster.Storage(), entries, mgr.logger)
+	} else {
+		diff, err = mgr.cluster.Import(entries, commitMessage(r, "import", "import cluster archive"))
+	}
+	if err != nil {
+		mgr.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(diff)
+}
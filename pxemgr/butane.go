@@ -0,0 +1,90 @@
+package pxemgr
+
+import (
+	"fmt"
+
+	"github.com/coreos/butane/config/common"
+	flatcar1_0 "github.com/coreos/butane/config/flatcar/v1_0"
+	"github.com/coreos/vcontext/report"
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// butaneVariant is the Butane variant templates are written in. Other
+// variants target other operating systems than Flatcar.
+const butaneVariant = "flatcar"
+
+// butaneTranslator translates a Butane config of a spec version to an
+// Ignition config of the given spec version.
+type butaneTranslator struct {
+	ignitionVersion string
+	translate       func([]byte, common.TranslateBytesOptions) ([]byte, report.Report, error)
+}
+
+// butaneTranslators are keyed by the Butane spec version.
+var butaneTranslators = map[string]butaneTranslator{
+	"1.0.0": {ignitionVersion: "3.3.0", translate: flatcar1_0.ToIgn3_3Bytes},
+}
+
+type butaneHeader struct {
+	Variant string `json:"variant"`
+	Version string `json:"version"`
+}
+
+// isButane checks whether the rendered template data is a Butane config.
+// Unlike Ignition configs, Butane configs name their variant.
+func isButane(data []byte) bool {
+	var header butaneHeader
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return false
+	}
+	return header.Variant != ""
+}
+
+// translateButane translates the Butane config data to an Ignition config in
+// JSON. Local files are embedded relative to filesDir. The problems found are
+// added to r, together with the Ignition spec version of the config. The
+// config is nil in case it cannot be translated.
+func translateButane(data []byte, filesDir string, r *hostmgr.IgnitionReport) ([]byte, error) {
+	var header butaneHeader
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to unmarshal input: %v", err)
+	}
+	if header.Variant != butaneVariant {
+		addIgnitionReportEntry(&r.Errors, "$.variant", fmt.Sprintf("unsupported Butane variant %s, use %s", header.Variant, butaneVariant))
+		return nil, nil
+	}
+	translator, ok := butaneTranslators[header.Version]
+	if !ok {
+		addIgnitionReportEntry(&r.Errors, "$.version", fmt.Sprintf("unsupported Butane spec version %s", header.Version))
+		return nil, nil
+	}
+	r.Version = translator.ignitionVersion
+
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{FilesDir: filesDir},
+	}
+	config, rpt, err := translator.translate(data, options)
+	for _, entry := range rpt.Entries {
+		path := ""
+		if entry.Context.Len() != 0 {
+			path = entry.Context.String()
+		}
+		switch entry.Kind {
+		case report.Error:
+			addIgnitionReportEntry(&r.Errors, path, entry.Message)
+		case report.Warn:
+			addIgnitionReportEntry(&r.Warnings, path, entry.Message)
+		}
+	}
+	if err != nil {
+		if !rpt.IsFatal() {
+			addIgnitionReportEntry(&r.Errors, "", err.Error())
+		}
+		return nil, nil
+	}
+
+	return config, nil
+}
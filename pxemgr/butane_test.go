@@ -0,0 +1,152 @@
+package pxemgr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+const butaneTemplate = `variant: flatcar
+version: 1.0.0
+storage:
+  trees:
+    - local: tree
+      path: /opt/tree
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: {{ .Host.Hostname }}
+    - path: /etc/motd
+      contents:
+        local: motd
+systemd:
+  units:
+    - name: docker.service
+      dropins:
+        - name: 10-env.conf
+          contents: |
+            [Service]
+            Environment=UPDATE={{ index .TemplatesEnv "update" }}
+`
+
+func TestButaneTemplate(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	butaneDir := filepath.Join(h.dir, "butane")
+	if err := os.MkdirAll(filepath.Join(butaneDir, "files", "tree", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(butaneDir, name), []byte(data), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+	}
+	writeFile("ignition.yaml", butaneTemplate)
+	writeFile("files/motd", "welcome\n")
+	writeFile("files/tree/sub/data", "data\n")
+
+	config := configOK + `
+profiles:
+  - name: butane
+    quantity: 1
+    ignition_config: ` + filepath.Join(butaneDir, "ignition.yaml") + `
+    files_dir: ` + filepath.Join(butaneDir, "files") + `
+`
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_butane.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_butane.yaml")
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	host := hostmgr.Host{Serial: "test1234", Hostname: "node1", Profile: "butane"}
+	data, report, err := mgr.renderIgnitionConfig(host, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Version != "3.3.0" {
+		t.Fatalf("expected valid Ignition 3.3.0 config, got %s %s", report.Version, report.String())
+	}
+
+	var cfg struct {
+		Ignition struct {
+			Version string
+		}
+		Storage struct {
+			Files []struct {
+				Path string
+			}
+		}
+		Systemd struct {
+			Units []struct {
+				Name    string
+				Dropins []struct {
+					Contents string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Ignition.Version != "3.3.0" {
+		t.Errorf("expected Ignition version 3.3.0, got %s", cfg.Ignition.Version)
+	}
+	paths := []string{}
+	for _, file := range cfg.Storage.Files {
+		paths = append(paths, file.Path)
+	}
+	if strings.Join(paths, ",") != "/etc/hostname,/etc/motd,/opt/tree/sub/data" {
+		t.Errorf("unexpected storage files %v", paths)
+	}
+	if len(cfg.Systemd.Units) != 1 || len(cfg.Systemd.Units[0].Dropins) != 1 || !strings.Contains(cfg.Systemd.Units[0].Dropins[0].Contents, "UPDATE=no_updates") {
+		t.Errorf("unexpected systemd units %#v", cfg.Systemd.Units)
+	}
+
+	// translation problems are reported
+	cases := map[string]struct {
+		template string
+		errors   bool
+	}{
+		"unused key": {
+			template: "variant: flatcar\nversion: 1.0.0\nunknown: true\n",
+		},
+		"unsupported variant": {
+			template: "variant: fcos\nversion: 1.4.0\n",
+			errors:   true,
+		},
+		"missing local file": {
+			template: "variant: flatcar\nversion: 1.0.0\nstorage:\n  files:\n    - path: /etc/missing\n      contents:\n        local: missing\n",
+			errors:   true,
+		},
+	}
+	for name, c := range cases {
+		writeFile("ignition.yaml", c.template)
+		_, report, err := mgr.renderIgnitionConfig(host, false)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if c.errors && report.Valid() {
+			t.Errorf("%s: expected errors, got %s", name, report.String())
+		}
+		if !c.errors && (!report.Valid() || len(report.Warnings) == 0) {
+			t.Errorf("%s: expected warnings only, got %s", name, report.String())
+		}
+	}
+}
@@ -3,7 +3,10 @@ package pxemgr
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"regexp"
+	"strings"
 
 	"github.com/giantswarm/microerror"
 	"gopkg.in/yaml.v2"
@@ -11,6 +14,8 @@ import (
 	"github.com/giantswarm/mayu/hostmgr"
 )
 
+var clusterNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
 func LoadConfig(filePath string) (Configuration, error) {
 	conf := Configuration{}
 
@@ -27,16 +32,151 @@ func LoadConfig(filePath string) (Configuration, error) {
 
 	err = yaml.Unmarshal(confBytes, &conf)
 
-	fmt.Printf("loaded config: %#v\n", conf)
+	fmt.Printf("loaded config: %#v\n", maskedConfiguration(conf))
 
 	return conf, microerror.Mask(err)
 }
 
+// loadConfiguration loads and validates the configuration file given by
+// filePath.
+func loadConfiguration(filePath string) (Configuration, error) {
+	conf, err := LoadConfig(filePath)
+	if err != nil {
+		return Configuration{}, microerror.Maskf(err, "failed to load config from file")
+	}
+
+	if conf.DefaultFlatcarVersion == "" {
+		return Configuration{}, microerror.Maskf(invalidConfigError, "No default_flatcar_version specified in %s", filePath)
+	}
+
+	err = conf.validateIgnitionVersions(filePath)
+	if err != nil {
+		return Configuration{}, microerror.Mask(err)
+	}
+	err = conf.validateProvisioners(filePath)
+	if err != nil {
+		return Configuration{}, microerror.Mask(err)
+	}
+	err = conf.validateClusters(filePath)
+	if err != nil {
+		return Configuration{}, microerror.Mask(err)
+	}
+
+	return conf, nil
+}
+
 type Configuration struct {
 	DefaultFlatcarVersion string `yaml:"default_flatcar_version"`
-	Network               Network
-	Profiles              []Profile
-	TemplatesEnv          map[string]interface{} `yaml:"templates_env"`
+	// DefaultIgnitionVersion is the Ignition spec version rendered for
+	// profiles not selecting one. Templates are kept at their own version in
+	// case it is empty.
+	DefaultIgnitionVersion string `yaml:"default_ignition_version"`
+	Network                Network
+	Profiles               []Profile
+	TemplatesEnv           map[string]interface{} `yaml:"templates_env"`
+	// Serials pre-registers machines with the cluster.
+	Serials []string `yaml:"serials"`
+
+	// Clusters are served next to the default cluster configured above, each
+	// with its own state, profiles, network and templates.
+	Clusters []ClusterDefinition `yaml:"clusters"`
+}
+
+// ClusterDefinition configures an additional cluster served by mayu. Machines
+// are routed to the cluster in case their serial is pre-registered with it,
+// or they request their iPXE script from within its PXE or primary NIC
+// address range.
+type ClusterDefinition struct {
+	Name string `yaml:"name"`
+	// ClusterDirectory keeps the state of the cluster. Relative paths are
+	// relative to the parent of the --cluster-directory.
+	ClusterDirectory string `yaml:"cluster_directory"`
+	// IgnitionConfig, TemplateSnippets and FilesDir default to the ones of
+	// the default cluster.
+	IgnitionConfig   string `yaml:"ignition_config"`
+	TemplateSnippets string `yaml:"template_snippets"`
+	FilesDir         string `yaml:"files_dir"`
+
+	// DefaultFlatcarVersion, DefaultIgnitionVersion and TemplatesEnv extend
+	// the ones of the default cluster, Network and Profiles replace them.
+	Configuration `yaml:",inline"`
+}
+
+// validateIgnitionVersions checks the Ignition spec versions selected for a
+// configuration loaded from configFile.
+func (c Configuration) validateIgnitionVersions(configFile string) error {
+	if c.DefaultIgnitionVersion != "" {
+		if _, err := parseIgnitionVersion(c.DefaultIgnitionVersion); err != nil {
+			return microerror.Maskf(invalidConfigError, "default_ignition_version in %s: %s", configFile, err)
+		}
+	}
+	for _, profile := range c.Profiles {
+		if profile.IgnitionVersion == "" {
+			continue
+		}
+		if _, err := parseIgnitionVersion(profile.IgnitionVersion); err != nil {
+			return microerror.Maskf(invalidConfigError, "ignition_version of profile '%s' in %s: %s", profile.Name, configFile, err)
+		}
+	}
+
+	return nil
+}
+
+// validateProvisioners checks the provisioners of the profiles of a
+// configuration loaded from configFile.
+func (c Configuration) validateProvisioners(configFile string) error {
+	for _, profile := range c.Profiles {
+		p := profile.Provisioner
+		switch p.Type {
+		case "", provisionerFlatcar:
+		case provisionerNoCloud:
+			if p.Kernel == "" || p.Initrd == "" || p.UserData == "" {
+				return microerror.Maskf(invalidConfigError, "provisioner of profile '%s' in %s requires kernel, initrd and user_data", profile.Name, configFile)
+			}
+		default:
+			return microerror.Maskf(invalidConfigError, "unknown provisioner '%s' of profile '%s' in %s, use %s or %s", p.Type, profile.Name, configFile, provisionerFlatcar, provisionerNoCloud)
+		}
+	}
+
+	return nil
+}
+
+// validateClusters checks the cluster definitions for a configuration loaded
+// from configFile.
+func (c Configuration) validateClusters(configFile string) error {
+	names := map[string]struct{}{}
+	dirs := map[string]struct{}{}
+
+	for _, def := range c.Clusters {
+		if !clusterNameRegexp.MatchString(def.Name) {
+			return microerror.Maskf(invalidConfigError, "invalid cluster name '%s' in %s, use lower case letters, digits and dashes", def.Name, configFile)
+		}
+		if _, exists := names[def.Name]; exists {
+			return microerror.Maskf(invalidConfigError, "cluster '%s' is defined twice in %s", def.Name, configFile)
+		}
+		names[def.Name] = struct{}{}
+
+		if def.ClusterDirectory == "" {
+			return microerror.Maskf(invalidConfigError, "no cluster_directory specified for cluster '%s' in %s", def.Name, configFile)
+		}
+		if _, exists := dirs[def.ClusterDirectory]; exists {
+			return microerror.Maskf(invalidConfigError, "cluster_directory of cluster '%s' is used twice in %s", def.Name, configFile)
+		}
+		dirs[def.ClusterDirectory] = struct{}{}
+
+		if len(def.Clusters) > 0 {
+			return microerror.Maskf(invalidConfigError, "cluster '%s' in %s must not define clusters itself", def.Name, configFile)
+		}
+
+		if err := def.validateIgnitionVersions(configFile); err != nil {
+			return microerror.Mask(err)
+		}
+		if err := def.validateProvisioners(configFile); err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
 }
 
 type Profile struct {
@@ -46,6 +186,115 @@ type Profile struct {
 	DisableEngine    bool   `yaml:"disable_engine"`
 	FlatcarVersion   string `yaml:"flatcar_version"`
 	EtcdClusterToken string `yaml:"etcd_cluster_token"`
+	// IgnitionVersion is the Ignition spec version rendered for hosts of
+	// the profile, eg. 3.3. The Ignition template is translated to it.
+	IgnitionVersion string `yaml:"ignition_version"`
+	// IgnitionConfig replaces the Ignition template of the cluster for hosts
+	// of the profile. The snippets found in the TemplateSnippets directories
+	// are added to the shared snippets of the cluster and replace shared
+	// snippets of the same file name.
+	IgnitionConfig   string   `yaml:"ignition_config"`
+	TemplateSnippets []string `yaml:"template_snippets"`
+	// FilesDir holds files for hosts of the profile. They are added to the
+	// files of the cluster and replace files of the same relative path.
+	FilesDir string `yaml:"files_dir"`
+	// TemplatesEnv extends the templates_env of the cluster for hosts of the
+	// profile and replaces variables of the same name.
+	TemplatesEnv map[string]interface{} `yaml:"templates_env"`
+	// Provisioner installs the operating system of hosts of the profile.
+	// Hosts are installed with Flatcar by default.
+	Provisioner ProvisionerConfig `yaml:"provisioner"`
+
+	// Match restricts the profile to hosts whose reported hardware inventory
+	// fits the given criteria. Profiles without criteria match every host.
+	Match ProfileMatch `yaml:"match"`
+}
+
+// profile returns the profile of the given name.
+func (c Configuration) profile(name string) (Profile, bool) {
+	for _, profile := range c.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+// ProvisionerConfig selects the provisioner installing hosts of a profile.
+type ProvisionerConfig struct {
+	// Type is either flatcar or nocloud.
+	Type string `yaml:"type"`
+	// Kernel and Initrd are the images booted by the nocloud provisioner,
+	// relative to the images cache directory.
+	Kernel string `yaml:"kernel"`
+	Initrd string `yaml:"initrd"`
+	// KernelArgs are added to the kernel command line of the nocloud
+	// provisioner.
+	KernelArgs []string `yaml:"kernel_args"`
+	// UserData is the template of the cloud-init user-data served by the
+	// nocloud provisioner.
+	UserData string `yaml:"user_data"`
+}
+
+// ProfileMatch holds the hardware criteria a host must fulfill to be assigned
+// to a profile, based on the inventory reported by infopusher. All given
+// criteria must match.
+type ProfileMatch struct {
+	// MacPrefixes matches hosts having at least one NIC whose MAC address
+	// starts with one of the given prefixes (eg. the vendor part).
+	MacPrefixes []string `yaml:"mac_prefixes"`
+	// ConnectedNIC matches hosts where infopusher found the given NIC to be
+	// connected.
+	ConnectedNIC string `yaml:"connected_nic"`
+	// MinNICs matches hosts having at least the given number of NICs.
+	MinNICs int `yaml:"min_nics"`
+	// IPMI matches hosts which do (true) or do not (false) report an IPMI
+	// address.
+	IPMI *bool `yaml:"ipmi"`
+}
+
+func (m ProfileMatch) isEmpty() bool {
+	return len(m.MacPrefixes) == 0 && m.ConnectedNIC == "" && m.MinNICs == 0 && m.IPMI == nil
+}
+
+// Matches checks whether the given inventory fulfills all criteria of the
+// profile. Hosts without inventory only match profiles without criteria.
+func (m ProfileMatch) Matches(inv *hostmgr.Inventory) bool {
+	if m.isEmpty() {
+		return true
+	}
+	if inv == nil {
+		return false
+	}
+
+	if len(m.MacPrefixes) > 0 {
+		found := false
+		for _, mac := range inv.MacAddresses() {
+			for _, prefix := range m.MacPrefixes {
+				if strings.HasPrefix(mac, strings.ToLower(prefix)) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if m.ConnectedNIC != "" && inv.HostData.ConnectedNIC != m.ConnectedNIC {
+		return false
+	}
+
+	if len(inv.HostData.NetDevs) < m.MinNICs {
+		return false
+	}
+
+	if m.IPMI != nil && *m.IPMI != (inv.HostData.IPMIAddress != nil) {
+		return false
+	}
+
+	return true
 }
 
 type NetworkRange struct {
@@ -95,3 +344,9 @@ type Network struct {
 	IgnoredHosts []string
 	StaticHosts  []hostmgr.IPMac
 }
+
+// contains checks whether ip is within the PXE or primary NIC address range
+// of the network.
+func (n Network) contains(ip net.IP) bool {
+	return ipInRange(ip, n.PXE.PxeInterface.IPRange) || ipInRange(ip, n.PrimaryNIC.IPRange)
+}
@@ -2,8 +2,9 @@ package pxemgr
 
 import (
 	"bufio"
+	"bytes"
 	"io"
-	"os"
+	"io/ioutil"
 	"os/exec"
 	"text/template"
 
@@ -92,31 +93,57 @@ func (dnsmasq *DNSmasqInstance) Restart() error {
 	return nil
 }
 
-func (dnsmasq *DNSmasqInstance) updateConf(net Network) error {
+// updateConf writes the dnsmasq configuration for the network of the default
+// cluster. The PXE address ranges of additional clusters are served next to
+// the one of the default cluster. It returns whether the configuration
+// changed.
+func (dnsmasq *DNSmasqInstance) updateConf(net Network, clusters []Network) (bool, error) {
 	_ = dnsmasq.conf.Logger.Log("level", "info", "component", "dnsmasq", "message", "updating Dnsmasq configuration")
 
+	conf, err := dnsmasq.renderConf(net, clusters)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	current, err := ioutil.ReadFile(dnsmasq.confpath)
+	if err == nil && bytes.Equal(current, conf) {
+		return false, nil
+	}
+
+	err = ioutil.WriteFile(dnsmasq.confpath, conf, 0644) // nolint
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+	return true, nil
+}
+
+// renderConf renders the dnsmasq configuration for the network of the
+// default cluster and the ones of the additional clusters.
+func (dnsmasq *DNSmasqInstance) renderConf(net Network, clusters []Network) ([]byte, error) {
 	tmpl, err := template.ParseFiles(dnsmasq.conf.Template)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 
 	tmplArgs := struct {
-		Network Network
-		Global  DNSmasqConfiguration
+		Network  Network
+		Clusters []Network
+		Global   DNSmasqConfiguration
 	}{
-		Network: net,
-		Global:  dnsmasq.conf,
+		Network:  net,
+		Clusters: clusters,
+		Global:   dnsmasq.conf,
 	}
 
-	file, err := os.Create(dnsmasq.confpath)
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, tmplArgs)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
-	defer file.Close()
+	return b.Bytes(), nil
+}
 
-	err = tmpl.Execute(file, tmplArgs)
-	if err != nil {
-		return microerror.Mask(err)
-	}
-	return nil
+// running returns whether dnsmasq got started.
+func (dnsmasq *DNSmasqInstance) running() bool {
+	return dnsmasq.cmd != nil
 }
@@ -19,3 +19,21 @@ var invalidConfigError = &microerror.Error{
 func IsInvalidConfig(err error) bool {
 	return microerror.Cause(err) == invalidConfigError
 }
+
+var invalidIgnitionError = &microerror.Error{
+	Kind: "invalidIgnitionError",
+}
+
+// IsInvalidIgnition asserts invalidIgnitionError.
+func IsInvalidIgnition(err error) bool {
+	return microerror.Cause(err) == invalidIgnitionError
+}
+
+var invalidTokenError = &microerror.Error{
+	Kind: "invalidTokenError",
+}
+
+// IsInvalidToken asserts invalidTokenError.
+func IsInvalidToken(err error) bool {
+	return microerror.Cause(err) == invalidTokenError
+}
@@ -1,51 +1,83 @@
 package pxemgr
 
 import (
-	"bytes"
-	"crypto/tls"
-	"crypto/x509"
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 	"path"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/giantswarm/microerror"
 	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/discovery"
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+const (
+	// EtcdDiscoveryBackendEmbedded keeps the registry of the internal etcd
+	// discovery within the cluster storage of mayu, so no etcd is needed.
+	EtcdDiscoveryBackendEmbedded = "embedded"
+	// EtcdDiscoveryBackendEtcd keeps the registry of the internal etcd
+	// discovery in etcd using the v3 API.
+	EtcdDiscoveryBackendEtcd = "etcd"
+	// EtcdDiscoveryBackendEtcdV2 proxies the internal etcd discovery to the
+	// v2 keys API of etcd, which needs to be enabled explicitly since etcd
+	// 3.4.
+	EtcdDiscoveryBackendEtcdV2 = "etcd-v2"
+
+	etcdRequestTimeout = 5 * time.Second
+
+	// error codes of the etcd v2 API
+	etcdErrorCodeKeyNotFound  = 100
+	etcdErrorCodeNodeExist    = 105
+	etcdErrorCodeEventIndex   = 401
+	etcdErrorCodeRaftInternal = 300
 )
 
 type EtcdNode struct {
-	Key   string      `json:"key"`
-	Value string      `json:"value,omitempty"`
-	Nodes []*EtcdNode `json:"nodes,omitempty"`
-	Dir   bool        `json:"dir,omitempty"`
+	Key           string      `json:"key"`
+	Value         string      `json:"value,omitempty"`
+	Nodes         []*EtcdNode `json:"nodes,omitempty"`
+	Dir           bool        `json:"dir,omitempty"`
+	CreatedIndex  uint64      `json:"createdIndex,omitempty"`
+	ModifiedIndex uint64      `json:"modifiedIndex,omitempty"`
 }
 
 type EtcdResponse struct {
-	Action string    `json:"action"`
-	Node   *EtcdNode `json:"node,omitempty"`
+	Action   string    `json:"action"`
+	Node     *EtcdNode `json:"node,omitempty"`
+	PrevNode *EtcdNode `json:"prevNode,omitempty"`
 }
 
 type EtcdResponseError struct {
 	ErrorCode int    `json:"errorCode"`
 	Message   string `json:"message"`
 	Cause     string `json:"cause"`
+	Index     uint64 `json:"index"`
 }
 
 func (mgr *pxeManagerT) defineEtcdDiscoveryRoutes(etcdRouter *mux.Router) {
 	etcdRouter.PathPrefix("/new").Methods("PUT").HandlerFunc(mgr.etcdDiscoveryNewCluster)
 
 	tokenRouter := etcdRouter.PathPrefix("/{token:[a-f0-9]{32}}").Subrouter()
-	tokenRouter.PathPrefix("/_config/size").Methods("GET").HandlerFunc(mgr.etcdDiscoveryProxyHandler)
-	tokenRouter.PathPrefix("/_config/size").Methods("PUT").HandlerFunc(mgr.etcdDiscoveryProxyHandler)
-	tokenRouter.PathPrefix("/{machine}").Methods("PUT").HandlerFunc(mgr.etcdDiscoveryProxyHandler)
-	tokenRouter.PathPrefix("/{machine}").Methods("GET").HandlerFunc(mgr.etcdDiscoveryProxyHandler)
-	tokenRouter.PathPrefix("/{machine}").Methods("DELETE").HandlerFunc(mgr.etcdDiscoveryProxyHandler)
-	tokenRouter.Methods("GET").HandlerFunc(mgr.etcdDiscoveryProxyHandler)
+	if mgr.discovery == nil {
+		tokenRouter.PathPrefix("/_config/size").Methods("GET").Handler(mgr.etcdProxy)
+		tokenRouter.PathPrefix("/_config/size").Methods("PUT").Handler(mgr.etcdProxy)
+		tokenRouter.PathPrefix("/{machine}").Methods("PUT").Handler(mgr.etcdProxy)
+		tokenRouter.PathPrefix("/{machine}").Methods("GET").Handler(mgr.etcdProxy)
+		tokenRouter.PathPrefix("/{machine}").Methods("DELETE").Handler(mgr.etcdProxy)
+		tokenRouter.Methods("GET").Handler(mgr.etcdProxy)
+	} else {
+		tokenRouter.PathPrefix("/_config/size").Methods("GET").HandlerFunc(mgr.etcdDiscoveryGetSize)
+		tokenRouter.PathPrefix("/_config/size").Methods("PUT").HandlerFunc(mgr.etcdDiscoverySetSize)
+		tokenRouter.PathPrefix("/{machine}").Methods("PUT").HandlerFunc(mgr.etcdDiscoveryRegister)
+		tokenRouter.PathPrefix("/{machine}").Methods("GET").HandlerFunc(mgr.etcdDiscoveryGetMember)
+		tokenRouter.PathPrefix("/{machine}").Methods("DELETE").HandlerFunc(mgr.etcdDiscoveryUnregister)
+		tokenRouter.Methods("GET").HandlerFunc(mgr.etcdDiscoveryGetMembers)
+	}
 
 	etcdRouter.Methods("GET").HandlerFunc(mgr.etcdDiscoveryHandler)
 }
@@ -75,7 +107,7 @@ func (mgr *pxeManagerT) etcdDiscoveryNewCluster(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = mgr.cluster.StoreEtcdDiscoveryToken(mgr.etcdEndpoint, mgr.etcdCAFile, token, size)
+	err = mgr.storeEtcdDiscoveryToken(r.Context(), token, size)
 	if err != nil {
 		mgr.httpError(w, fmt.Sprintf("Unable to store token in etcd '%v'", err), 400)
 		return
@@ -90,75 +122,241 @@ func (mgr *pxeManagerT) etcdDiscoveryBaseURL() string {
 	return fmt.Sprintf("%s/etcd", mgr.apiURL())
 }
 
-func (mgr *pxeManagerT) etcdDiscoveryProxyHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := mgr.etcdDiscoveryProxyRequest(r)
+// etcdConnection returns the connection to the etcd of the etcd and etcd-v2
+// discovery backends.
+func (mgr *pxeManagerT) etcdConnection() hostmgr.EtcdConnection {
+	return hostmgr.EtcdConnection{
+		Endpoint: mgr.etcdEndpoint,
+		CAFile:   mgr.etcdCAFile,
+		CertFile: mgr.etcdCertFile,
+		KeyFile:  mgr.etcdKeyFile,
+		Username: mgr.etcdUsername,
+		Password: mgr.etcdPassword,
+	}
+}
+
+// storeEtcdDiscoveryToken adds the given token to the registry of the
+// internal etcd discovery.
+func (mgr *pxeManagerT) storeEtcdDiscoveryToken(ctx context.Context, token string, size int) error {
+	if mgr.discovery == nil {
+		return mgr.cluster.StoreEtcdDiscoveryToken(mgr.etcdConnection(), token, size)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, etcdRequestTimeout)
+	defer cancel()
+	err := mgr.discovery.CreateToken(ctx, token, size)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (mgr *pxeManagerT) etcdDiscoveryGetSize(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	node, index, err := mgr.discovery.Size(r.Context(), token)
 	if err != nil {
-		mgr.httpError(w, fmt.Sprintf("Error proxying request to etcd '%v'", err), 500)
+		mgr.etcdDiscoveryError(w, token, discovery.SizeKey, index, err)
+		return
 	}
 
-	copyHeader(w.Header(), resp.Header)
-	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+	mgr.etcdDiscoveryResponse(w, http.StatusOK, index, EtcdResponse{
+		Action: discovery.ActionGet,
+		Node:   etcdNode(token, node),
+	})
 }
 
-func (mgr *pxeManagerT) etcdDiscoveryProxyRequest(r *http.Request) (*http.Response, error) {
-	body, err := ioutil.ReadAll(r.Body)
+func (mgr *pxeManagerT) etcdDiscoverySetSize(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	size, err := strconv.Atoi(r.FormValue("value"))
+	if err != nil || size < 1 {
+		mgr.httpError(w, fmt.Sprintf("invalid cluster size '%s'", r.FormValue("value")), http.StatusBadRequest)
+		return
+	}
+
+	event, err := mgr.discovery.SetSize(r.Context(), token, size)
 	if err != nil {
-		return nil, microerror.Mask(err)
+		mgr.etcdDiscoveryError(w, token, discovery.SizeKey, 0, err)
+		return
+	}
+
+	mgr.etcdDiscoveryEvent(w, token, event)
+}
+
+func (mgr *pxeManagerT) etcdDiscoveryGetMembers(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	if r.FormValue("wait") == "true" {
+		mgr.etcdDiscoveryWait(w, r, token, "")
+		return
 	}
-	u, err := url.Parse(mgr.etcdEndpoint)
+
+	members, index, err := mgr.discovery.Members(r.Context(), token)
 	if err != nil {
-		return nil, microerror.Mask(errors.New("invalid etcd-endpoint: " + err.Error()))
+		mgr.etcdDiscoveryError(w, token, "", index, err)
+		return
+	}
+
+	dir := &EtcdNode{
+		Key: path.Join(discovery.RegistryPrefix, token),
+		Dir: true,
 	}
+	for _, member := range members {
+		dir.Nodes = append(dir.Nodes, etcdNode(token, member))
+	}
+
+	mgr.etcdDiscoveryResponse(w, http.StatusOK, index, EtcdResponse{
+		Action: discovery.ActionGet,
+		Node:   dir,
+	})
+}
 
-	u.Path = path.Join("v2", "keys", "_etcd", "registry", strings.TrimPrefix(r.URL.Path, "/etcd"))
-	u.RawQuery = r.URL.RawQuery
-	var transport = http.DefaultTransport
+func (mgr *pxeManagerT) etcdDiscoveryGetMember(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	machine := mux.Vars(r)["machine"]
 
-	if u.Scheme == "https" && mgr.etcdCAFile != "" {
-		customCA := x509.NewCertPool()
+	if r.FormValue("wait") == "true" {
+		mgr.etcdDiscoveryWait(w, r, token, machine)
+		return
+	}
 
-		pemData, err := ioutil.ReadFile(mgr.etcdCAFile)
+	node, index, err := mgr.discovery.Member(r.Context(), token, machine)
+	if err != nil {
+		mgr.etcdDiscoveryError(w, token, machine, index, err)
+		return
+	}
+
+	mgr.etcdDiscoveryResponse(w, http.StatusOK, index, EtcdResponse{
+		Action: discovery.ActionGet,
+		Node:   etcdNode(token, node),
+	})
+}
+
+func (mgr *pxeManagerT) etcdDiscoveryRegister(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	machine := mux.Vars(r)["machine"]
+
+	// etcd members register using prevExist=false, so that members with the
+	// same ID are detected
+	mustCreate := r.FormValue("prevExist") == "false"
+
+	event, err := mgr.discovery.Register(r.Context(), token, machine, r.FormValue("value"), mustCreate)
+	if err != nil {
+		mgr.etcdDiscoveryError(w, token, machine, 0, err)
+		return
+	}
+
+	mgr.etcdDiscoveryEvent(w, token, event)
+}
+
+func (mgr *pxeManagerT) etcdDiscoveryUnregister(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	machine := mux.Vars(r)["machine"]
+
+	event, err := mgr.discovery.Unregister(r.Context(), token, machine)
+	if err != nil {
+		mgr.etcdDiscoveryError(w, token, machine, 0, err)
+		return
+	}
+
+	mgr.etcdDiscoveryEvent(w, token, event)
+}
+
+// etcdDiscoveryWait answers long-poll requests using wait=true. It blocks
+// until the members of the token, or the member given by machine, change at
+// or after the index given by waitIndex.
+func (mgr *pxeManagerT) etcdDiscoveryWait(w http.ResponseWriter, r *http.Request, token, machine string) {
+	var waitIndex uint64
+	if s := r.FormValue("waitIndex"); s != "" {
+		var err error
+		waitIndex, err = strconv.ParseUint(s, 10, 64)
 		if err != nil {
-			return nil, microerror.Mask(errors.New("unable to read custom CA file: " + err.Error()))
+			mgr.httpError(w, fmt.Sprintf("invalid waitIndex '%s'", s), http.StatusBadRequest)
+			return
 		}
-		customCA.AppendCertsFromPEM(pemData)
-		transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:    customCA,
-				MinVersion: tls.VersionTLS12,
-			},
+	}
+
+	event, err := mgr.discovery.Watch(r.Context(), token, machine, waitIndex)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// the client went away
+			return
 		}
+		mgr.etcdDiscoveryError(w, token, machine, 0, err)
+		return
 	}
 
-	for i := 0; i <= 10; i++ { // nolint
-		buf := bytes.NewBuffer(body)
-		_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("Body '%s'", body))
+	mgr.etcdDiscoveryResponse(w, http.StatusOK, event.Index, EtcdResponse{
+		Action:   event.Action,
+		Node:     etcdNode(token, event.Node),
+		PrevNode: etcdPrevNode(token, event.PrevNode),
+	})
+}
 
-		outreq, err := http.NewRequest(r.Method, u.String(), buf)
-		if err != nil {
-			return nil, microerror.Mask(err)
-		}
+func (mgr *pxeManagerT) etcdDiscoveryEvent(w http.ResponseWriter, token string, event discovery.Event) {
+	status := http.StatusOK
+	if event.Action == discovery.ActionCreate {
+		status = http.StatusCreated
+	}
 
-		copyHeader(outreq.Header, r.Header)
+	mgr.etcdDiscoveryResponse(w, status, event.Index, EtcdResponse{
+		Action:   event.Action,
+		Node:     etcdNode(token, event.Node),
+		PrevNode: etcdPrevNode(token, event.PrevNode),
+	})
+}
 
-		client := http.Client{Transport: transport}
-		resp, err := client.Do(outreq)
-		if err != nil {
-			return nil, microerror.Mask(err)
-		}
+func (mgr *pxeManagerT) etcdDiscoveryResponse(w http.ResponseWriter, status int, index uint64, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(index, 10))
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// etcdDiscoveryError reports err in the error format of the etcd v2 API.
+func (mgr *pxeManagerT) etcdDiscoveryError(w http.ResponseWriter, token, key string, index uint64, err error) {
+	resp := EtcdResponseError{
+		Cause: path.Join(discovery.RegistryPrefix, token, key),
+		Index: index,
+	}
 
-		return resp, nil // nolint
+	status := http.StatusInternalServerError
+	switch {
+	case discovery.IsNotFound(err):
+		status = http.StatusNotFound
+		resp.ErrorCode = etcdErrorCodeKeyNotFound
+		resp.Message = "Key not found"
+	case discovery.IsAlreadyExists(err):
+		status = http.StatusPreconditionFailed
+		resp.ErrorCode = etcdErrorCodeNodeExist
+		resp.Message = "Key already exists"
+	case discovery.IsOutdated(err):
+		status = http.StatusBadRequest
+		resp.ErrorCode = etcdErrorCodeEventIndex
+		resp.Message = "The event in requested index is outdated and cleared"
+	default:
+		_ = mgr.logger.Log("level", "error", "message", "etcd discovery request failed", "stack", err)
+		resp.ErrorCode = etcdErrorCodeRaftInternal
+		resp.Message = err.Error()
 	}
 
-	return nil, microerror.Mask(errors.New("all attempts at proxying to etcd failed"))
+	mgr.etcdDiscoveryResponse(w, status, index, resp)
 }
 
-// copyHeader copies all of the headers from dst to src.
-func copyHeader(dst, src http.Header) {
-	for k, v := range src {
-		for _, q := range v {
-			dst.Add(k, q)
-		}
+func etcdNode(token string, node discovery.Node) *EtcdNode {
+	return &EtcdNode{
+		Key:           path.Join(discovery.RegistryPrefix, token, node.Key),
+		Value:         node.Value,
+		CreatedIndex:  node.CreatedIndex,
+		ModifiedIndex: node.ModifiedIndex,
+	}
+}
+
+func etcdPrevNode(token string, node *discovery.Node) *EtcdNode {
+	if node == nil {
+		return nil
 	}
+	return etcdNode(token, *node)
 }
@@ -0,0 +1,326 @@
+package pxemgr
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	etcddiscovery "github.com/coreos/etcd/discovery"
+	"github.com/coreos/etcd/embed"
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/giantswarm/micrologger"
+	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/discovery"
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// startTestEtcd starts an embedded etcd keeping its data in dir. Clients
+// connect using TLS in case tlsInfo is given.
+func startTestEtcd(t *testing.T, dir string, tlsInfo *transport.TLSInfo) *embed.Etcd {
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	if tlsInfo != nil {
+		clientURL.Scheme = "https"
+		cfg.ClientTLSInfo = *tlsInfo
+	}
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("starting etcd: %s", err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		t.Fatal("timed out starting etcd")
+	}
+
+	return e
+}
+
+// TestEtcdDiscoveryJoinCluster lets etcd's own discovery client bootstrap a
+// cluster using the internal etcd discovery backed by an embedded etcd.
+func TestEtcdDiscoveryJoinCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pxemgr_etcd_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := startTestEtcd(t, dir, nil)
+	defer e.Close()
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := discovery.NewEtcdRegistry(discovery.EtcdConfig{
+		Endpoints: []string{"http://" + e.Clients[0].Addr().String()},
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatalf("creating registry: %s", err)
+	}
+	defer registry.Close()
+
+	testJoinCluster(t, registry, logger)
+}
+
+// TestEtcdDiscoveryTLS connects the etcd and etcd-v2 discovery backends to an
+// etcd requiring client certificates and authentication.
+func TestEtcdDiscoveryTLS(t *testing.T) {
+	if raceEnabled {
+		// etcd checks the password of every etcd v2 request using bcrypt,
+		// which exceeds the request timeouts using the race detector.
+		t.Skip("etcd v2 authentication is too slow using the race detector")
+	}
+
+	dir, err := ioutil.TempDir("", "pxemgr_etcd_tls_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the self-signed certificate serves etcd, authenticates mayu and acts
+	// as CA verifying both
+	tlsInfo, err := transport.SelfCert(filepath.Join(dir, "certs"), []string{"127.0.0.1:0"}, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		t.Fatalf("creating certificate: %s", err)
+	}
+	tlsInfo.TrustedCAFile = tlsInfo.CertFile
+	tlsInfo.ClientCertAuth = true
+
+	e := startTestEtcd(t, filepath.Join(dir, "etcd"), &tlsInfo)
+	defer e.Close()
+
+	conn := hostmgr.EtcdConnection{
+		Endpoint: "https://" + e.Clients[0].Addr().String(),
+		CAFile:   tlsInfo.CertFile,
+		CertFile: tlsInfo.CertFile,
+		KeyFile:  tlsInfo.KeyFile,
+		Username: "root",
+		Password: "secret",
+	}
+	enableTestEtcdAuth(t, conn)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := hostmgr.NewCluster(filepath.Join(dir, "cluster"), logger)
+	if err != nil {
+		t.Fatalf("creating cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	// connections without client certificate or credentials are rejected
+	withoutCert := conn
+	withoutCert.CertFile, withoutCert.KeyFile = "", ""
+	if err := cluster.StoreEtcdDiscoveryToken(withoutCert, "00000000000000000000000000000000", 3); err == nil {
+		t.Fatal("expected storing token without client certificate to fail")
+	}
+	withoutPassword := conn
+	withoutPassword.Username, withoutPassword.Password = "", ""
+	if err := cluster.StoreEtcdDiscoveryToken(withoutPassword, "00000000000000000000000000000000", 3); err == nil {
+		t.Fatal("expected storing token without credentials to fail")
+	}
+
+	// etcd-v2 stores tokens in and proxies the discovery to the v2 API
+	token := "0123456789abcdef0123456789abcdef"
+	if err := cluster.StoreEtcdDiscoveryToken(conn, token, 3); err != nil {
+		t.Fatalf("storing token: %s", err)
+	}
+	p, err := newEtcdProxy(etcdProxyConfig{
+		Connection: conn,
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatalf("creating etcd proxy: %s", err)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "http://127.0.0.1:4080/etcd/"+token+"/_config/size", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"value":"3"`) {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	// etcd keeps the registry in the v3 API
+	registry, err := discovery.NewEtcdRegistry(discovery.EtcdConfig{
+		Endpoints: []string{conn.Endpoint},
+		CAFile:    conn.CAFile,
+		CertFile:  conn.CertFile,
+		KeyFile:   conn.KeyFile,
+		Username:  conn.Username,
+		Password:  conn.Password,
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatalf("creating registry: %s", err)
+	}
+	defer registry.Close()
+	ctx := context.Background()
+	if err := registry.CreateToken(ctx, token, 5); err != nil {
+		t.Fatalf("creating token: %s", err)
+	}
+	size, _, err := registry.Size(ctx, token)
+	if err != nil || size.Value != "5" {
+		t.Fatalf("unexpected size %#v: %v", size, err)
+	}
+}
+
+// enableTestEtcdAuth enables authentication of the v2 and v3 API of etcd for
+// the root user given by conn, and revokes access of unauthenticated users.
+func enableTestEtcdAuth(t *testing.T, conn hostmgr.EtcdConnection) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tlsConfig, err := conn.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v3, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{conn.Endpoint},
+		DialTimeout: 5 * time.Second,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v3.Close()
+	if _, err := v3.UserAdd(ctx, conn.Username, conn.Password); err != nil {
+		t.Fatalf("adding v3 user: %s", err)
+	}
+	if _, err := v3.UserGrantRole(ctx, conn.Username, "root"); err != nil {
+		t.Fatalf("granting v3 role: %s", err)
+	}
+	if _, err := v3.AuthEnable(ctx); err != nil {
+		t.Fatalf("enabling v3 auth: %s", err)
+	}
+
+	newV2 := func(username, password string) client.Client {
+		c, err := client.New(client.Config{
+			Endpoints: []string{conn.Endpoint},
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Username:  username,
+			Password:  password,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if err := client.NewAuthUserAPI(newV2("", "")).AddUser(ctx, conn.Username, conn.Password); err != nil {
+		t.Fatalf("adding v2 user: %s", err)
+	}
+	if err := client.NewAuthAPI(newV2("", "")).Enable(ctx); err != nil {
+		t.Fatalf("enabling v2 auth: %s", err)
+	}
+	if _, err := client.NewAuthRoleAPI(newV2(conn.Username, conn.Password)).RevokeRoleKV(ctx, "guest", []string{"/*"}, client.ReadWritePermission); err != nil {
+		t.Fatalf("revoking v2 guest access: %s", err)
+	}
+}
+
+// TestEmbeddedDiscoveryJoinCluster lets etcd's own discovery client bootstrap
+// a cluster using the internal etcd discovery backed by the cluster storage.
+func TestEmbeddedDiscoveryJoinCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pxemgr_discovery_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := hostmgr.NewDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := discovery.NewStorageRegistry(discovery.StorageConfig{
+		Storage: storage,
+		Logger:  logger,
+	})
+	if err != nil {
+		t.Fatalf("creating registry: %s", err)
+	}
+	defer registry.Close()
+
+	testJoinCluster(t, registry, logger)
+}
+
+// testJoinCluster bootstraps a cluster of three members using the internal
+// etcd discovery backed by registry.
+func testJoinCluster(t *testing.T, registry discovery.Registry, logger micrologger.Logger) {
+	mgr := &pxeManagerT{
+		defaultEtcdQuorumSize: 3,
+		discovery:             registry,
+		logger:                logger,
+	}
+	router := mux.NewRouter()
+	mgr.defineEtcdDiscoveryRoutes(router.PathPrefix("/etcd").Subrouter())
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	token := "0123456789abcdef0123456789abcdef"
+	err := mgr.storeEtcdDiscoveryToken(context.Background(), token, 3)
+	if err != nil {
+		t.Fatalf("storing token: %s", err)
+	}
+
+	type result struct {
+		cluster string
+		err     error
+	}
+	results := make(chan result)
+	for i := 1; i <= 3; i++ {
+		go func(i int) {
+			config := fmt.Sprintf("member-%d=http://10.0.0.%d:2380", i, i)
+			cluster, err := etcddiscovery.JoinCluster(ts.URL+"/etcd/"+token, "", types.ID(i), config)
+			results <- result{cluster, err}
+		}(i)
+	}
+
+	expected := "member-1=http://10.0.0.1:2380,member-2=http://10.0.0.2:2380,member-3=http://10.0.0.3:2380"
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				t.Fatalf("joining cluster: %s", r.err)
+			}
+			members := strings.Split(r.cluster, ",")
+			sort.Strings(members)
+			if strings.Join(members, ",") != expected {
+				t.Fatalf("expected cluster %s, got %s", expected, r.cluster)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("timed out joining cluster")
+		}
+	}
+
+	// the cluster is full, so further members are rejected
+	_, err = etcddiscovery.JoinCluster(ts.URL+"/etcd/"+token, "", types.ID(4), "member-4=http://10.0.0.4:2380")
+	if err != etcddiscovery.ErrFullCluster {
+		t.Fatalf("expected full cluster, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package pxemgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	stdhttputil "net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/discovery"
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+const (
+	// etcdProxyAttempts is the number of attempts made to forward a request
+	// to etcd.
+	etcdProxyAttempts = 5
+	// etcdProxyBackoff is the delay before the first retry. It doubles with
+	// every further retry.
+	etcdProxyBackoff = 100 * time.Millisecond
+	// etcdWaitTimeout limits long-poll requests using wait=true. etcd members
+	// simply wait again in case the request timed out.
+	etcdWaitTimeout = 5 * time.Minute
+)
+
+type etcdProxyConfig struct {
+	Connection hostmgr.EtcdConnection
+
+	Logger micrologger.Logger
+}
+
+// etcdProxy forwards the etcd discovery protocol to the v2 keys API of etcd.
+// All requests share a pool of connections. Failed requests are retried in
+// case this is safe, and errors are reported in the error format of etcd.
+type etcdProxy struct {
+	endpoint *url.URL
+	// username and password authenticate requests using basic auth, as
+	// expected by the etcd v2 API.
+	username string
+	password string
+	proxy    *stdhttputil.ReverseProxy
+	logger   micrologger.Logger
+}
+
+func newEtcdProxy(config etcdProxyConfig) (*etcdProxy, error) {
+	u, err := url.Parse(config.Connection.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, microerror.Maskf(invalidConfigError, "invalid etcd-endpoint '%s'", config.Connection.Endpoint)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   etcdRequestTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns: 100,
+		// all members of a cluster long-poll etcd at the same time
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: etcdRequestTimeout,
+	}
+	transport.TLSClientConfig, err = config.Connection.TLSConfig()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	p := &etcdProxy{
+		endpoint: u,
+		username: config.Connection.Username,
+		password: config.Connection.Password,
+		logger:   config.Logger,
+	}
+	p.proxy = &stdhttputil.ReverseProxy{
+		Director: p.direct,
+		Transport: &etcdProxyTransport{
+			transport:      transport,
+			attempts:       etcdProxyAttempts,
+			backoff:        etcdProxyBackoff,
+			requestTimeout: etcdRequestTimeout,
+			waitTimeout:    etcdWaitTimeout,
+			logger:         config.Logger,
+		},
+		ErrorHandler: p.handleError,
+	}
+
+	return p, nil
+}
+
+func (p *etcdProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.proxy.ServeHTTP(w, r)
+}
+
+// direct rewrites requests to the discovery, eg. /etcd/<token>/<member>, to
+// the registry in etcd, ie. /v2/keys/_etcd/registry/<token>/<member>.
+func (p *etcdProxy) direct(r *http.Request) {
+	r.URL.Scheme = p.endpoint.Scheme
+	r.URL.Host = p.endpoint.Host
+	r.URL.Path = path.Join("/", p.endpoint.Path, "v2", "keys", discovery.RegistryPrefix, strings.TrimPrefix(r.URL.Path, "/etcd"))
+	r.URL.RawPath = ""
+	r.Host = p.endpoint.Host
+	r.Header.Del("Authorization")
+	if p.username != "" {
+		r.SetBasicAuth(p.username, p.password)
+	}
+}
+
+// handleError reports requests which could not be forwarded to etcd in the
+// error format of etcd.
+func (p *etcdProxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
+
+	status := http.StatusBadGateway
+	message := "Unable to reach etcd"
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+		message = "etcd did not respond in time"
+	}
+	_ = p.logger.Log("level", "warning", "message", fmt.Sprintf("proxying etcd discovery request %s %s failed", r.Method, r.URL.Path), "stack", err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(EtcdResponseError{
+		ErrorCode: etcdErrorCodeRaftInternal,
+		Message:   message,
+		Cause:     err.Error(),
+	})
+}
+
+// etcdProxyTransport forwards requests to etcd, retrying them with backoff in
+// case it is safe to do so. Idempotent requests are retried on any failure,
+// others only in case etcd could not be reached at all.
+type etcdProxyTransport struct {
+	transport      http.RoundTripper
+	attempts       int
+	backoff        time.Duration
+	requestTimeout time.Duration
+	waitTimeout    time.Duration
+	logger         micrologger.Logger
+}
+
+func (t *etcdProxyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	wait := r.URL.Query().Get("wait") == "true"
+	timeout := t.requestTimeout
+	if wait {
+		timeout = t.waitTimeout
+	}
+
+	backoff := t.backoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		outreq := r.Clone(ctx)
+		outreq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		outreq.ContentLength = int64(len(body))
+		if len(body) == 0 {
+			outreq.Body = nil
+		}
+
+		resp, err := t.transport.RoundTrip(outreq)
+
+		retry := false
+		if attempt < t.attempts && r.Context().Err() == nil {
+			switch {
+			case err != nil && isDialError(err):
+				retry = true
+			case err != nil:
+				// long-polls timing out are not retried, as they already
+				// waited long enough
+				retry = isIdempotent(r.Method) && !(wait && errors.Is(err, context.DeadlineExceeded))
+			case resp.StatusCode >= 500:
+				retry = isIdempotent(r.Method)
+			}
+		}
+
+		if !retry {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		reason := fmt.Sprintf("%v", err)
+		if err == nil {
+			reason = resp.Status
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+		_ = t.logger.Log("level", "warning", "message", fmt.Sprintf("attempt %d of %d to forward %s %s to etcd failed, retrying in %s: %s", attempt, t.attempts, r.Method, r.URL.Path, backoff, reason))
+
+		select {
+		case <-time.After(backoff):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// isIdempotent checks whether requests using method can be repeated safely.
+// PUT and DELETE are not, since the discovery relies on them failing for
+// existing or missing members.
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// isDialError checks whether err occurred while connecting, so the request
+// did not reach etcd.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// cancelReadCloser releases the context of a forwarded request once its
+// response has been read.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
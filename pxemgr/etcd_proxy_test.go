@@ -0,0 +1,117 @@
+package pxemgr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func newTestEtcdProxy(t *testing.T, endpoint string) *etcdProxy {
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newEtcdProxy(etcdProxyConfig{
+		Connection: hostmgr.EtcdConnection{Endpoint: endpoint},
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatalf("creating etcd proxy: %s", err)
+	}
+	transport := p.proxy.Transport.(*etcdProxyTransport)
+	transport.backoff = time.Millisecond
+	transport.waitTimeout = 100 * time.Millisecond
+
+	return p
+}
+
+func TestEtcdProxy(t *testing.T) {
+	token := "0123456789abcdef0123456789abcdef"
+
+	var requests int32
+	var failures int32
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		if r.URL.Path != "/v2/keys/_etcd/registry/"+token+"/_config/size" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("wait") == "true" {
+			<-r.Context().Done()
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Etcd-Index", "7")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"action":"get","node":{"key":"/_etcd/registry/` + token + `/_config/size","value":"3"},"body":"` + string(body) + `"}`))
+	}))
+	defer etcd.Close()
+
+	p := newTestEtcdProxy(t, etcd.URL)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		atomic.StoreInt32(&requests, 0)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(method, "http://127.0.0.1:4080/etcd/"+token+target, strings.NewReader(body)))
+		return w
+	}
+
+	// failed GET requests are retried
+	atomic.StoreInt32(&failures, 2)
+	w := do("GET", "/_config/size", "")
+	if w.Code != http.StatusOK || w.Header().Get("X-Etcd-Index") != "7" || !strings.Contains(w.Body.String(), `"value":"3"`) {
+		t.Fatalf("unexpected response %d %#v: %s", w.Code, w.Header(), w.Body.String())
+	}
+	if atomic.LoadInt32(&requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", atomic.LoadInt32(&requests))
+	}
+
+	// PUT requests are forwarded including their body, but not retried
+	atomic.StoreInt32(&failures, 0)
+	w = do("PUT", "/_config/size", "value=5")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"body":"value=5"`) {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	atomic.StoreInt32(&failures, 1)
+	w = do("PUT", "/_config/size", "value=5")
+	if w.Code != http.StatusInternalServerError || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected failed PUT not to be retried, got %d after %d requests", w.Code, atomic.LoadInt32(&requests))
+	}
+
+	// long-polls time out
+	atomic.StoreInt32(&failures, 0)
+	w = do("GET", "/_config/size?wait=true", "")
+	var etcdErr EtcdResponseError
+	if err := json.Unmarshal(w.Body.Bytes(), &etcdErr); err != nil {
+		t.Fatalf("decoding error %q: %s", w.Body.String(), err)
+	}
+	if w.Code != http.StatusGatewayTimeout || etcdErr.ErrorCode != etcdErrorCodeRaftInternal || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("unexpected response %d after %d requests: %s", w.Code, atomic.LoadInt32(&requests), w.Body.String())
+	}
+
+	// errors reaching etcd are reported in its error format
+	etcd.Close()
+	w = do("GET", "/_config/size", "")
+	etcdErr = EtcdResponseError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &etcdErr); err != nil {
+		t.Fatalf("decoding error %q: %s", w.Body.String(), err)
+	}
+	if w.Code != http.StatusBadGateway || etcdErr.ErrorCode != etcdErrorCodeRaftInternal {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,294 @@
+package pxemgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/giantswarm/microerror"
+	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/discovery"
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// defineEtcdTokenRoutes adds the endpoints managing the tokens of the internal
+// etcd discovery. The registry is shared by all clusters, so the endpoints are
+// not available per cluster.
+func (mgr *pxeManagerT) defineEtcdTokenRoutes(router *mux.Router) {
+	router.Methods("GET").Path("/admin/etcd/tokens").HandlerFunc(mgr.etcdTokensList)
+	router.Methods("GET").Path("/admin/etcd/tokens/{token}").HandlerFunc(mgr.etcdTokenGet)
+	router.Methods("PUT").Path("/admin/etcd/tokens/{token}/size").HandlerFunc(mgr.etcdTokenSetSize)
+	router.Methods("DELETE").Path("/admin/etcd/tokens/{token}/members/{member}").HandlerFunc(mgr.etcdTokenRemoveMember)
+	router.Methods("DELETE").Path("/admin/etcd/tokens/{token}").HandlerFunc(mgr.etcdTokenDelete)
+}
+
+func (mgr *pxeManagerT) etcdTokensList(w http.ResponseWriter, r *http.Request) {
+	if !mgr.checkEtcdTokenRegistry(w) {
+		return
+	}
+
+	tokens, err := mgr.etcdTokens(r.Context())
+	if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to list etcd tokens: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(tokens)
+}
+
+func (mgr *pxeManagerT) etcdTokenGet(w http.ResponseWriter, r *http.Request) {
+	if !mgr.checkEtcdTokenRegistry(w) {
+		return
+	}
+
+	token, err := mgr.etcdToken(r.Context(), mux.Vars(r)["token"])
+	if discovery.IsNotFound(err) {
+		mgr.httpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to get etcd token: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(token)
+}
+
+func (mgr *pxeManagerT) etcdTokenSetSize(w http.ResponseWriter, r *http.Request) {
+	if !mgr.checkEtcdTokenRegistry(w) {
+		return
+	}
+	token := mux.Vars(r)["token"]
+
+	payload := hostmgr.EtcdToken{}
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		mgr.httpError(w, "unable to parse json data in set size request", http.StatusBadRequest)
+		return
+	}
+	if payload.Size < 1 {
+		mgr.httpError(w, fmt.Sprintf("invalid etcd cluster size %d", payload.Size), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), etcdRequestTimeout)
+	defer cancel()
+	_, err = mgr.discovery.SetSize(ctx, token, payload.Size)
+	if discovery.IsNotFound(err) {
+		mgr.httpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to set size of etcd token: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("set size of etcd token '%s' to %d (by %s)", token, payload.Size, actor(r)))
+
+	w.WriteHeader(204)
+}
+
+func (mgr *pxeManagerT) etcdTokenRemoveMember(w http.ResponseWriter, r *http.Request) {
+	if !mgr.checkEtcdTokenRegistry(w) {
+		return
+	}
+	token := mux.Vars(r)["token"]
+	member := mux.Vars(r)["member"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), etcdRequestTimeout)
+	defer cancel()
+	_, err := mgr.discovery.Unregister(ctx, token, member)
+	if discovery.IsNotFound(err) {
+		mgr.httpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to remove member of etcd token: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("removed member '%s' of etcd token '%s' (by %s)", member, token, actor(r)))
+
+	w.WriteHeader(204)
+}
+
+// etcdTokenDelete removes a token from the registry. Tokens still used by
+// hosts or as default token of a cluster are only removed using the query
+// parameter force=true.
+func (mgr *pxeManagerT) etcdTokenDelete(w http.ResponseWriter, r *http.Request) {
+	if !mgr.checkEtcdTokenRegistry(w) {
+		return
+	}
+
+	token, err := mgr.etcdToken(r.Context(), mux.Vars(r)["token"])
+	if discovery.IsNotFound(err) {
+		mgr.httpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to get etcd token: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !token.Registered {
+		mgr.httpError(w, fmt.Sprintf("etcd token '%s' is not registered", token.Token), http.StatusNotFound)
+		return
+	}
+	if (len(token.Hosts) > 0 || len(token.Default) > 0) && r.URL.Query().Get("force") != "true" {
+		mgr.httpError(w, fmt.Sprintf("etcd token '%s' is used by %d hosts and %d clusters, use force=true to delete it anyway", token.Token, len(token.Hosts), len(token.Default)), http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), etcdRequestTimeout)
+	defer cancel()
+	err = mgr.discovery.DeleteToken(ctx, token.Token)
+	if discovery.IsNotFound(err) {
+		mgr.httpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		mgr.httpError(w, fmt.Sprintf("unable to delete etcd token: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("deleted etcd token '%s' (by %s)", token.Token, actor(r)))
+
+	w.WriteHeader(204)
+}
+
+// checkEtcdTokenRegistry responds with an error in case the registry of the
+// internal etcd discovery cannot be managed, ie. using the etcd-v2 backend.
+func (mgr *pxeManagerT) checkEtcdTokenRegistry(w http.ResponseWriter) bool {
+	if mgr.discovery == nil {
+		mgr.httpError(w, fmt.Sprintf("managing etcd tokens is not supported by the '%s' etcd discovery backend", mgr.etcdDiscoveryBackend), http.StatusNotImplemented)
+		return false
+	}
+
+	return true
+}
+
+// etcdTokens reports all tokens known to the registry or used by the hosts
+// of any cluster, ordered by token.
+func (mgr *pxeManagerT) etcdTokens(ctx context.Context) ([]hostmgr.EtcdToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdRequestTimeout)
+	defer cancel()
+
+	tokens := mgr.etcdTokenUsage()
+
+	registered, _, err := mgr.discovery.Tokens(ctx)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	for _, name := range registered {
+		token, exists := tokens[name]
+		if !exists {
+			token = newEtcdToken(name)
+			tokens[name] = token
+		}
+
+		err = mgr.addEtcdTokenMembers(ctx, token)
+		if discovery.IsNotFound(err) {
+			// deleted in the meantime
+			if !exists {
+				delete(tokens, name)
+			}
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	list := []hostmgr.EtcdToken{}
+	for _, token := range tokens {
+		list = append(list, *token)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Token < list[j].Token })
+
+	return list, nil
+}
+
+// etcdToken reports the token given by name. In case it is neither known to
+// the registry nor used by any host, an error asserted by
+// discovery.IsNotFound is returned.
+func (mgr *pxeManagerT) etcdToken(ctx context.Context, name string) (hostmgr.EtcdToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdRequestTimeout)
+	defer cancel()
+
+	token, used := mgr.etcdTokenUsage()[name]
+	if !used {
+		token = newEtcdToken(name)
+	}
+
+	err := mgr.addEtcdTokenMembers(ctx, token)
+	if discovery.IsNotFound(err) && used {
+		// used by hosts, but unknown to the registry
+	} else if err != nil {
+		return hostmgr.EtcdToken{}, microerror.Mask(err)
+	}
+
+	return *token, nil
+}
+
+// etcdTokenUsage collects the tokens used by the hosts of all clusters.
+// Hosts without token of their own use the default token of their cluster.
+func (mgr *pxeManagerT) etcdTokenUsage() map[string]*hostmgr.EtcdToken {
+	tokens := map[string]*hostmgr.EtcdToken{}
+	get := func(name string) *hostmgr.EtcdToken {
+		if _, exists := tokens[name]; !exists {
+			tokens[name] = newEtcdToken(name)
+		}
+		return tokens[name]
+	}
+
+	for _, m := range append([]*pxeManagerT{mgr}, mgr.clusters...) {
+		defaultToken := m.cluster.Config.DefaultEtcdClusterToken
+		if defaultToken != "" {
+			token := get(defaultToken)
+			token.Default = append(token.Default, m.name)
+		}
+
+		for _, host := range m.cluster.GetAllHosts() {
+			name := host.EtcdClusterToken
+			if name == "" {
+				name = defaultToken
+			}
+			if name == "" {
+				continue
+			}
+			token := get(name)
+			token.Hosts = append(token.Hosts, hostmgr.EtcdTokenHost{Cluster: m.name, Serial: host.Serial})
+		}
+	}
+
+	return tokens
+}
+
+// addEtcdTokenMembers reads the size and members of token from the registry.
+func (mgr *pxeManagerT) addEtcdTokenMembers(ctx context.Context, token *hostmgr.EtcdToken) error {
+	size, _, err := mgr.discovery.Size(ctx, token.Token)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	members, _, err := mgr.discovery.Members(ctx, token.Token)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	token.Registered = true
+	token.Size, _ = strconv.Atoi(size.Value)
+	token.Members = []hostmgr.EtcdTokenMember{}
+	for _, member := range members {
+		token.Members = append(token.Members, hostmgr.EtcdTokenMember{ID: member.Key, Value: member.Value})
+	}
+
+	return nil
+}
+
+func newEtcdToken(name string) *hostmgr.EtcdToken {
+	return &hostmgr.EtcdToken{
+		Token:   name,
+		Members: []hostmgr.EtcdTokenMember{},
+		Hosts:   []hostmgr.EtcdTokenHost{},
+	}
+}
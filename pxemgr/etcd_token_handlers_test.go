@@ -0,0 +1,143 @@
+package pxemgr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func TestEtcdTokens(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_ok.yaml")
+	h.pxeCfg.EtcdDiscoveryBackend = EtcdDiscoveryBackendEmbedded
+	h.pxeCfg.EtcdQuorumSize = 3
+
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+	router := mux.NewRouter()
+	mgr.defineEtcdTokenRoutes(router)
+
+	defaultToken := h.cluster.Config.DefaultEtcdClusterToken
+	retiredToken := "0123456789abcdef0123456789abcdef"
+	unknownToken := "fedcba9876543210fedcba9876543210"
+
+	if _, err := h.cluster.CreateNewHost("host1"); err != nil {
+		t.Fatalf("creating host: %s", err)
+	}
+	host, err := h.cluster.CreateNewHost("host2")
+	if err != nil {
+		t.Fatalf("creating host: %s", err)
+	}
+	host.EtcdClusterToken = unknownToken
+	if err := host.Commit("set etcd cluster token"); err != nil {
+		t.Fatalf("committing host: %s", err)
+	}
+
+	ctx := context.Background()
+	if err := mgr.storeEtcdDiscoveryToken(ctx, retiredToken, 3); err != nil {
+		t.Fatalf("storing token: %s", err)
+	}
+	if _, err := mgr.discovery.Register(ctx, retiredToken, "1a2b", "node-1=http://10.0.0.1:2380", true); err != nil {
+		t.Fatalf("registering member: %s", err)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "http://127.0.0.1:4080"+path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("GET", "/admin/etcd/tokens", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing tokens returned status %d: %s", w.Code, w.Body.String())
+	}
+	var tokens []hostmgr.EtcdToken
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("decoding tokens: %s", err)
+	}
+	expected := map[string]hostmgr.EtcdToken{
+		defaultToken: {
+			Token:      defaultToken,
+			Size:       3,
+			Members:    []hostmgr.EtcdTokenMember{},
+			Registered: true,
+			Default:    []string{""},
+			Hosts:      []hostmgr.EtcdTokenHost{{Serial: "host1"}},
+		},
+		retiredToken: {
+			Token:      retiredToken,
+			Size:       3,
+			Members:    []hostmgr.EtcdTokenMember{{ID: "1a2b", Value: "node-1=http://10.0.0.1:2380"}},
+			Registered: true,
+			Hosts:      []hostmgr.EtcdTokenHost{},
+		},
+		unknownToken: {
+			Token:   unknownToken,
+			Members: []hostmgr.EtcdTokenMember{},
+			Hosts:   []hostmgr.EtcdTokenHost{{Serial: "host2"}},
+		},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %#v", len(expected), tokens)
+	}
+	for _, token := range tokens {
+		if !reflect.DeepEqual(token, expected[token.Token]) {
+			t.Errorf("expected token %#v, got %#v", expected[token.Token], token)
+		}
+	}
+
+	if w := do("PUT", "/admin/etcd/tokens/"+retiredToken+"/size", `{"Size": 5}`); w.Code != http.StatusNoContent {
+		t.Fatalf("setting size returned status %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("PUT", "/admin/etcd/tokens/"+unknownToken+"/size", `{"Size": 5}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected setting size of unknown token to return 404, got %d", w.Code)
+	}
+	if w := do("DELETE", "/admin/etcd/tokens/"+retiredToken+"/members/1a2b", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("removing member returned status %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/admin/etcd/tokens/"+retiredToken+"/members/1a2b", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected removing unknown member to return 404, got %d", w.Code)
+	}
+
+	w = do("GET", "/admin/etcd/tokens/"+retiredToken, "")
+	var token hostmgr.EtcdToken
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil {
+		t.Fatalf("decoding token: %s", err)
+	}
+	if token.Size != 5 || len(token.Members) != 0 {
+		t.Fatalf("unexpected token %#v", token)
+	}
+
+	// tokens in use are only deleted by force
+	if w := do("DELETE", "/admin/etcd/tokens/"+defaultToken, ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected deleting default token to return 409, got %d", w.Code)
+	}
+	if w := do("DELETE", "/admin/etcd/tokens/"+retiredToken, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting token returned status %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/admin/etcd/tokens/"+retiredToken, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted token to return 404, got %d", w.Code)
+	}
+	if w := do("DELETE", "/admin/etcd/tokens/"+unknownToken, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected deleting unregistered token to return 404, got %d", w.Code)
+	}
+}
@@ -3,48 +3,236 @@ package pxemgr
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/template"
+	"unicode/utf8"
 
 	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v2"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
+// fileMetaSuffix names the sidecar file keeping the metadata of a file, eg.
+// conf/bonding.conf.meta.yaml for conf/bonding.conf.
+const fileMetaSuffix = ".meta.yaml"
+
 // Files is map[string]string for files that we fetched from disk and then filled with data.
+// They are keyed by their slash separated path relative to the files
+// directory and base64 encoded.
 type Files map[string]string
 
-func (mgr *pxeManagerT) RenderFiles(ctx interface{}) (*Files, error) {
+// FileMeta is the metadata of a file of the files directory, read from its
+// sidecar file.
+type FileMeta struct {
+	// Path places the file on the host at the given absolute path by adding
+	// it to storage.files of the Ignition config. Files without path are
+	// only available to templates via .Files.
+	Path string `yaml:"path"`
+	// Mode, User and Group are the permissions and owner of the file on the
+	// host. Files default to mode 0644 and the owner chosen by Ignition.
+	Mode  *int   `yaml:"mode"`
+	User  string `yaml:"user"`
+	Group string `yaml:"group"`
+	// Raw files are not rendered as template. Files not being valid UTF-8,
+	// eg. binaries, are always raw.
+	Raw bool `yaml:"raw"`
+}
+
+// storageFile is a rendered file placed on the host via storage.files.
+type storageFile struct {
+	FileMeta
+	Contents []byte
+}
+
+// filesSource is a file found within a files directory.
+type filesSource struct {
+	path string
+	meta FileMeta
+}
+
+// RenderFiles renders the files of the files directory of the cluster and the
+// ones of the given profile. Files of the profile replace the ones of the
+// cluster at the same relative path. Files whose metadata give a path are
+// returned as storage files, too.
+func (mgr *pxeManagerT) RenderFiles(ctx interface{}, profileName string) (*Files, []storageFile, error) {
+	dirs := []string{mgr.filesDir}
+	if profile, ok := mgr.config.profile(profileName); ok && profile.FilesDir != "" {
+		dirs = append(dirs, profile.FilesDir)
+	}
+
+	sources := map[string]filesSource{}
+	for i, dir := range dirs {
+		err := collectFiles(dir, i > 0, sources)
+		if err != nil {
+			_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("Failed to read files dir: %s", dir), "stack", err)
+			return nil, nil, microerror.Mask(err)
+		}
+	}
+
+	names := []string{}
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	files := Files{}
-	dirList, err := ioutil.ReadDir(mgr.filesDir)
-	if err != nil {
-		_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("Failed to read files dir: %s", mgr.filesDir), "stack", err)
-		return nil, microerror.Mask(err)
+	var storageFiles []storageFile
+	for _, name := range names {
+		source := sources[name]
+		data, err := renderFile(source, ctx)
+		if err != nil {
+			_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("Failed to render file: %s", source.path), "stack", err)
+			return nil, nil, microerror.Mask(err)
+		}
+
+		files[name] = base64.StdEncoding.EncodeToString(data)
+		if source.meta.Path != "" {
+			storageFiles = append(storageFiles, storageFile{FileMeta: source.meta, Contents: data})
+		}
 	}
+	return &files, storageFiles, nil
+}
 
-	for _, dir := range dirList {
-		fileList, err := ioutil.ReadDir(path.Join(mgr.filesDir, dir.Name()))
+// collectFiles adds the files found within dir and its subdirectories to
+// sources, keyed by their relative path. Missing directories are ignored in
+// case optional is set.
+func collectFiles(dir string, optional bool, sources map[string]filesSource) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) && optional {
+		return nil
+	}
+
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
-			_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("Failed to read dir: %s", path.Join(mgr.filesDir, dir.Name())), "stack", err)
-			return nil, microerror.Mask(err)
+			return microerror.Mask(err)
 		}
+		if fi.IsDir() || strings.HasSuffix(p, fileMetaSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		meta, err := loadFileMeta(p)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		sources[filepath.ToSlash(rel)] = filesSource{path: p, meta: meta}
+
+		return nil
+	})
+}
+
+// loadFileMeta reads the sidecar metadata of the file at p, if any.
+func loadFileMeta(p string) (FileMeta, error) {
+	meta := FileMeta{}
+
+	data, err := ioutil.ReadFile(p + fileMetaSuffix)
+	if os.IsNotExist(err) {
+		return meta, nil
+	} else if err != nil {
+		return meta, microerror.Mask(err)
+	}
+
+	err = yaml.UnmarshalStrict(data, &meta)
+	if err != nil {
+		return meta, microerror.Maskf(invalidConfigError, "%s%s: %s", p, fileMetaSuffix, err)
+	}
+	if meta.Path != "" && !path.IsAbs(meta.Path) {
+		return meta, microerror.Maskf(invalidConfigError, "%s%s: path %s is not absolute", p, fileMetaSuffix, meta.Path)
+	}
+
+	return meta, nil
+}
+
+// renderFile renders the file given by source as template, unless it is raw.
+func renderFile(source filesSource, ctx interface{}) ([]byte, error) {
+	raw, err := ioutil.ReadFile(source.path)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if source.meta.Raw || !utf8.Valid(raw) {
+		return raw, nil
+	}
+
+	tmpl, err := template.New(filepath.Base(source.path)).Funcs(templateFuncs).Parse(string(raw))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var data bytes.Buffer
+	err = tmpl.Execute(&data, ctx)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return data.Bytes(), nil
+}
 
-		for _, file := range fileList {
-			tmpl, err := template.ParseFiles(path.Join(mgr.filesDir, dir.Name(), file.Name()))
-			if err != nil {
-				_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("Failed to file: %s", path.Join(mgr.filesDir, dir.Name(), file.Name())), "stack", err)
-				return nil, microerror.Mask(err)
-			}
+// addStorageFiles adds files to storage.files of the rendered Ignition
+// template, using the format of the spec version of the template. The
+// template is returned as JSON.
+func addStorageFiles(template []byte, files []storageFile) ([]byte, error) {
+	dataJSON, err := sigsyaml.YAMLToJSON(template)
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to unmarshal input: %v", err)
+	}
+	if len(files) == 0 {
+		return dataJSON, nil
+	}
 
-			var data bytes.Buffer
-			err = tmpl.Execute(&data, ctx)
-			if err != nil {
-				_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("Failed to execute tmpl for  %s", path.Join(mgr.filesDir, dir.Name(), file.Name())), "stack", err)
-				return nil, microerror.Mask(err)
-			}
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(dataJSON, &cfg); err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to unmarshal input: %v", err)
+	}
+	spec2 := false
+	if ignition, ok := cfg["ignition"].(map[string]interface{}); ok {
+		version, _ := ignition["version"].(string)
+		spec2 = strings.HasPrefix(version, "2.")
+	}
 
-			files[dir.Name()+"/"+file.Name()] = base64.StdEncoding.EncodeToString(data.Bytes())
+	storage, ok := cfg["storage"].(map[string]interface{})
+	if !ok {
+		storage = map[string]interface{}{}
+		cfg["storage"] = storage
+	}
+	entries, _ := storage["files"].([]interface{})
+	for _, file := range files {
+		entry := map[string]interface{}{
+			"path": file.Path,
+			"contents": map[string]interface{}{
+				"source": "data:;base64," + base64.StdEncoding.EncodeToString(file.Contents),
+			},
+		}
+		if spec2 {
+			entry["filesystem"] = "root"
+			// spec 2 defaults to mode 0000, spec 3 to 0644
+			entry["mode"] = 0644
+		} else {
+			entry["overwrite"] = true
+		}
+		if file.Mode != nil {
+			entry["mode"] = *file.Mode
+		}
+		if file.User != "" {
+			entry["user"] = map[string]interface{}{"name": file.User}
 		}
+		if file.Group != "" {
+			entry["group"] = map[string]interface{}{"name": file.Group}
+		}
+		entries = append(entries, entry)
+	}
+	storage["files"] = entries
+
+	dataJSON, err = json.Marshal(cfg)
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to marshal output: %v", err)
 	}
-	return &files, nil
+	return dataJSON, nil
 }
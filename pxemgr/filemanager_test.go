@@ -0,0 +1,139 @@
+package pxemgr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v33types "github.com/coreos/ignition/v2/config/v3_3/types"
+	"github.com/giantswarm/micrologger"
+)
+
+func TestRenderFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pxemgr_files_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := string([]byte{0x7f, 'E', 'L', 'F', 0xff, 0xfe, '{', '{'})
+	files := map[string]string{
+		"cluster/top.conf":                       "top {{ .Name }}",
+		"cluster/conf/nested/deep.conf":          "deep {{ .Name }}",
+		"cluster/conf/raw.conf":                  "raw {{ .Name }}",
+		"cluster/conf/raw.conf.meta.yaml":        "raw: true\n",
+		"cluster/conf/shared.conf":               "cluster",
+		"cluster/bin/tool":                       binary,
+		"cluster/bin/tool.meta.yaml":             "path: /opt/bin/tool\nmode: 0755\nuser: core\ngroup: core\n",
+		"worker/conf/shared.conf":                "worker {{ .Name }}",
+		"worker/conf/shared.conf.meta.yaml":      "path: /etc/shared.conf\n",
+		"worker/conf/worker.conf":                "only worker",
+		"invalid/conf/relative.conf":             "relative",
+		"invalid/conf/relative.conf.meta.yaml":   "path: etc/relative.conf\n",
+		"unknownkey/conf/unknown.conf":           "unknown",
+		"unknownkey/conf/unknown.conf.meta.yaml": "owner: core\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	mgr := &pxeManagerT{
+		filesDir: filepath.Join(dir, "cluster"),
+		config: &Configuration{
+			Profiles: []Profile{
+				{Name: "worker", FilesDir: filepath.Join(dir, "worker")},
+				{Name: "missing", FilesDir: filepath.Join(dir, "missing")},
+				{Name: "invalid", FilesDir: filepath.Join(dir, "invalid")},
+				{Name: "unknownkey", FilesDir: filepath.Join(dir, "unknownkey")},
+			},
+		},
+		logger: logger,
+	}
+	ctx := struct{ Name string }{Name: "node"}
+
+	rendered, storageFiles, err := mgr.RenderFiles(ctx, "worker")
+	if err != nil {
+		t.Fatalf("rendering files: %s", err)
+	}
+	expected := map[string]string{
+		"top.conf":              "top node",
+		"conf/nested/deep.conf": "deep node",
+		"conf/raw.conf":         "raw {{ .Name }}",
+		"conf/shared.conf":      "worker node",
+		"conf/worker.conf":      "only worker",
+		"bin/tool":              binary,
+	}
+	if len(*rendered) != len(expected) {
+		t.Fatalf("expected files %v, got %v", expected, *rendered)
+	}
+	for name, content := range expected {
+		if (*rendered)[name] != base64.StdEncoding.EncodeToString([]byte(content)) {
+			t.Errorf("unexpected content of %s: %s", name, (*rendered)[name])
+		}
+	}
+
+	mode := 0755
+	expectedStorageFiles := []storageFile{
+		{FileMeta: FileMeta{Path: "/opt/bin/tool", Mode: &mode, User: "core", Group: "core"}, Contents: []byte(binary)},
+		{FileMeta: FileMeta{Path: "/etc/shared.conf"}, Contents: []byte("worker node")},
+	}
+	if !reflect.DeepEqual(storageFiles, expectedStorageFiles) {
+		t.Fatalf("expected storage files %#v, got %#v", expectedStorageFiles, storageFiles)
+	}
+
+	// other profiles only get the files of the cluster
+	rendered, storageFiles, err = mgr.RenderFiles(ctx, "missing")
+	if err != nil {
+		t.Fatalf("rendering files: %s", err)
+	}
+	if _, ok := (*rendered)["conf/worker.conf"]; ok || len(storageFiles) != 1 {
+		t.Fatalf("unexpected files %v, %#v", *rendered, storageFiles)
+	}
+
+	for _, profile := range []string{"invalid", "unknownkey"} {
+		if _, _, err := mgr.RenderFiles(ctx, profile); !IsInvalidConfig(err) {
+			t.Errorf("expected invalid config error for profile %s, got %#v", profile, err)
+		}
+	}
+
+	// storage files are added to the Ignition config in the format of its
+	// spec version
+	for _, template := range []string{"ignition:\n  version: 2.2.0\n", "ignition:\n  version: 3.3.0\nstorage:\n  files:\n    - path: /etc/hostname\n"} {
+		data, err := addStorageFiles([]byte(template), expectedStorageFiles)
+		if err != nil {
+			t.Fatalf("adding storage files: %s", err)
+		}
+		data, report, err := convertTemplateToJSON(data, "3.3", false)
+		if err != nil {
+			t.Fatalf("converting template: %s", err)
+		}
+		if !report.Empty() {
+			t.Fatalf("unexpected report %s", report)
+		}
+		var cfg v33types.Config
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			t.Fatalf("decoding config: %s", err)
+		}
+		tool := cfg.Storage.Files[len(cfg.Storage.Files)-2]
+		if tool.Path != "/opt/bin/tool" || *tool.Mode != 0755 || *tool.User.Name != "core" || *tool.Group.Name != "core" || !*tool.Overwrite {
+			t.Errorf("unexpected file %#v", tool)
+		}
+		if !strings.HasPrefix(*tool.Contents.Source, "data:;base64,") {
+			t.Errorf("unexpected contents %s", *tool.Contents.Source)
+		}
+	}
+}
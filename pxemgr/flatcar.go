@@ -0,0 +1,61 @@
+package pxemgr
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+)
+
+const (
+	vmlinuzFile = "flatcar_production_pxe.vmlinuz"
+	initrdFile  = "flatcar_production_pxe_image.cpio.gz"
+)
+
+// flatcarProvisioner boots the Flatcar PXE images of the default Flatcar
+// version, which install Flatcar using the Ignition config of the host.
+type flatcarProvisioner struct {
+	imagesURL   string
+	ignitionURL string
+	// imagesDir keeps the images of the default Flatcar version.
+	imagesDir string
+	autologin bool
+}
+
+func (p flatcarProvisioner) configURL(serial, uuid, token string) string {
+	if token == "" {
+		// iPXE fills in the serial and uuid of the host
+		return p.ignitionURL + "?uuid=${uuid}&serial=${serial}"
+	}
+
+	query := url.Values{}
+	query.Set("uuid", uuid)
+	query.Set("serial", serial)
+	query.Set("token", token)
+	return p.ignitionURL + "?" + query.Encode()
+}
+
+func (p flatcarProvisioner) bootScript(configURL string, kernelArgs []string) string {
+	extraFlags := ""
+	for _, arg := range kernelArgs {
+		extraFlags += " " + arg
+	}
+	if p.autologin {
+		extraFlags += " flatcar.autologin"
+	}
+
+	// for ignition we use only 1phase installation without mayu-infopusher
+	kernel := fmt.Sprintf("kernel %s/vmlinuz flatcar.first_boot=1 initrd=initrd.cpio.gz flatcar.config.url=%s systemd.journald.max_level_console=debug verbose log_buf_len=10M "+extraFlags+"\n", p.imagesURL, configURL)
+	initrd := fmt.Sprintf("initrd %s/initrd.cpio.gz\n", p.imagesURL)
+
+	return "#!ipxe\ndhcp\n" + kernel + initrd + "boot\n"
+}
+
+func (p flatcarProvisioner) image(name string) string {
+	switch name {
+	case "vmlinuz":
+		return path.Join(p.imagesDir, vmlinuzFile)
+	case "initrd.cpio.gz":
+		return path.Join(p.imagesDir, initrdFile)
+	}
+	return ""
+}
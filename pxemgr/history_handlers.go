@@ -0,0 +1,79 @@
+package pxemgr
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// RollbackRequest is the payload of the rollback API call.
+type RollbackRequest struct {
+	Revision string
+}
+
+func (mgr *pxeManagerT) hostHistory(serial string, w http.ResponseWriter, r *http.Request) {
+	revisions, err := mgr.cluster.HostHistory(serial)
+	if err != nil {
+		mgr.historyError(w, err)
+		return
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(revisions)
+}
+
+func (mgr *pxeManagerT) hostDiff(serial string, w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" {
+		w.WriteHeader(400)
+		_, _ = w.Write([]byte("missing revision to diff from"))
+		return
+	}
+
+	diff, err := mgr.cluster.HostDiff(serial, from, to)
+	if err != nil {
+		mgr.historyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-diff")
+	w.WriteHeader(200)
+	_, _ = w.Write([]byte(diff))
+}
+
+func (mgr *pxeManagerT) hostRollback(serial string, w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	payload := RollbackRequest{}
+	err := decoder.Decode(&payload)
+	if err != nil || payload.Revision == "" {
+		w.WriteHeader(400)
+		_, _ = w.Write([]byte("unable to parse json data in rollback request"))
+		return
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	err = mgr.cluster.RollbackHost(serial, payload.Revision, commitMessage(r, "rollback", "roll back host %s to revision %s", serial, payload.Revision))
+	if err != nil {
+		mgr.historyError(w, err)
+		return
+	}
+	w.WriteHeader(202)
+}
+
+func (mgr *pxeManagerT) historyError(w http.ResponseWriter, err error) {
+	switch {
+	case hostmgr.IsGitDisabled(err):
+		mgr.httpError(w, "git history is disabled for this cluster", http.StatusNotImplemented)
+	case hostmgr.IsInvalidRevision(err), hostmgr.IsInvalidSerial(err):
+		mgr.httpError(w, err.Error(), http.StatusBadRequest)
+	case hostmgr.IsNotFound(err):
+		mgr.httpError(w, err.Error(), http.StatusNotFound)
+	default:
+		mgr.httpError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -6,114 +6,292 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
 	"text/template"
+	"time"
 
 	"github.com/giantswarm/microerror"
-	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/yaml"
 
 	"github.com/giantswarm/mayu/hostmgr"
 )
 
+// WriteIgnitionConfig renders the Ignition config of host to wr. Configs
+// found invalid against the Ignition spec are not written.
 func (mgr *pxeManagerT) WriteIgnitionConfig(host hostmgr.Host, wr io.Writer) error {
+	return mgr.writeIgnitionConfig(host, false, wr)
+}
+
+func (mgr *pxeManagerT) writeIgnitionConfig(host hostmgr.Host, maskSecrets bool, wr io.Writer) error {
+	ignitionJSON, report, err := mgr.renderIgnitionConfig(host, maskSecrets)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !report.Valid() {
+		return microerror.Maskf(invalidIgnitionError, "Ignition %s config of host %s is invalid:\n%s", report.Version, host.Serial, report.String())
+	}
+
+	fmt.Fprintln(wr, string(ignitionJSON[:]))
+	return nil
+}
+
+// WriteIgnitionConfigs renders the Ignition config of every profile of the
+// default and the additional clusters to wr, using placeholder hosts. Hosts
+// not getting a configured profile are rendered as default profile. Profiles
+// using the nocloud provisioner get their user-data rendered instead. Secrets
+// are masked instead of being decrypted.
+func (mgr *pxeManagerT) WriteIgnitionConfigs(wr io.Writer) error {
+	profiles := []string{}
+	for _, profile := range mgr.config.Profiles {
+		profiles = append(profiles, profile.Name)
+	}
+	if _, ok := mgr.config.profile(defaultProfileName); !ok {
+		profiles = append(profiles, defaultProfileName)
+	}
+
+	for _, profile := range profiles {
+		if p, _ := mgr.config.profile(profile); p.Provisioner.Type == provisionerNoCloud {
+			if err := mgr.writeUserData(hostmgr.Host{Profile: profile}, wr); err != nil {
+				return microerror.Maskf(executionFailedError, "profile %s: %s", profile, err)
+			}
+			continue
+		}
+
+		if mgr.name == "" {
+			fmt.Fprintf(wr, "ignition config of profile %s:\n", profile)
+		} else {
+			fmt.Fprintf(wr, "ignition config of profile %s in cluster %s:\n", profile, mgr.name)
+		}
+		if err := mgr.writeIgnitionConfig(hostmgr.Host{Profile: profile}, true, wr); err != nil {
+			return microerror.Maskf(executionFailedError, "profile %s: %s", profile, err)
+		}
+	}
+
+	for _, child := range mgr.clusters {
+		if err := child.WriteIgnitionConfigs(wr); err != nil {
+			return microerror.Maskf(executionFailedError, "cluster %s: %s", child.name, err)
+		}
+	}
+
+	return nil
+}
+
+// templateData are the variables of the templates rendered for a host.
+type templateData struct {
+	Host             hostmgr.Host
+	Inventory        *hostmgr.Inventory
+	EtcdDiscoveryUrl string
+	ClusterNetwork   Network
+	MayuHost         string
+	MayuPort         int
+	MayuURL          string
+	PostBootURL      string
+	NoTLS            bool
+	Token            string
+	TemplatesEnv     map[string]interface{}
+	Files            Files
+}
+
+// templateData returns the template variables of host together with the
+// files of its profile to be placed on the host. Encrypted template variables
+// are decrypted, or replaced by a placeholder in case maskSecrets is set.
+func (mgr *pxeManagerT) templateData(host hostmgr.Host, maskSecrets bool) (*templateData, []storageFile, error) {
 	etcdClusterToken := mgr.cluster.Config.DefaultEtcdClusterToken
 
 	if host.EtcdClusterToken != "" {
 		etcdClusterToken = host.EtcdClusterToken
 	}
 
-	mergedTemplatesEnv := mgr.config.TemplatesEnv
-	for k, v := range host.Overrides {
-		mergedTemplatesEnv[k] = v
+	templatesEnv := mergeTemplateVars(mgr.templateVarsLayers(host))
+	if maskSecrets {
+		templatesEnv = maskTemplatesEnv(templatesEnv)
+	} else {
+		var err error
+		templatesEnv, err = decryptSecrets(templatesEnv, mgr.secretsIdentities)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+	}
+
+	inventory, err := host.Inventory()
+	if err != nil && !hostmgr.IsNotFound(err) {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	// tokens are credentials of the host, which are masked like secrets. The
+	// installed host requests follow-up URLs from its internal address.
+	token := mgr.hostToken(host.Serial, firstMAC(host), host.InternalAddr)
+	postBootToken := mgr.postBootToken(host.Serial, firstMAC(host))
+	if maskSecrets && token != "" {
+		token = secretMask
+		postBootToken = secretMask
+	}
+	postBootURL := mgr.apiURL() + mgr.pathPrefix + "/admin/host/" + host.Serial + "/boot_complete"
+	if postBootToken != "" {
+		postBootURL += "?token=" + url.QueryEscape(postBootToken)
 	}
 
-	ctx := struct {
-		Host             hostmgr.Host
-		EtcdDiscoveryUrl string
-		ClusterNetwork   Network
-		MayuHost         string
-		MayuPort         int
-		MayuURL          string
-		PostBootURL      string
-		NoTLS            bool
-		TemplatesEnv     map[string]interface{}
-		Files            Files
-	}{
+	ctx := &templateData{
 		Host:             host,
+		Inventory:        inventory,
 		ClusterNetwork:   mgr.config.Network,
 		EtcdDiscoveryUrl: fmt.Sprintf("%s/%s", mgr.etcdDiscoveryUrl, etcdClusterToken),
 		MayuHost:         mgr.config.Network.BindAddr,
 		MayuPort:         mgr.apiPort,
 		MayuURL:          mgr.apiURL(),
-		PostBootURL:      mgr.apiURL() + "/admin/host/" + host.Serial + "/boot_complete",
+		PostBootURL:      postBootURL,
 		NoTLS:            mgr.noTLS,
-		TemplatesEnv:     mergedTemplatesEnv,
+		Token:            token,
+		TemplatesEnv:     templatesEnv,
 	}
 
-	files, err := mgr.RenderFiles(ctx)
+	files, storageFiles, err := mgr.RenderFiles(ctx, host.Profile)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, nil, microerror.Mask(err)
 	}
-
 	ctx.Files = *files
-	tmpl, err := getTemplate(mgr.ignitionConfig, mgr.templateSnippets)
+
+	return ctx, storageFiles, nil
+}
+
+// renderIgnitionConfig renders the Ignition config of host and validates it
+// against the Ignition spec. The config is returned together with the
+// validation report, even if it is invalid. Encrypted template variables are
+// decrypted, or replaced by a placeholder in case maskSecrets is set.
+func (mgr *pxeManagerT) renderIgnitionConfig(host hostmgr.Host, maskSecrets bool) ([]byte, hostmgr.IgnitionReport, error) {
+	ctx, storageFiles, err := mgr.templateData(host, maskSecrets)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, hostmgr.IgnitionReport{}, microerror.Mask(err)
+	}
+
+	tmpl, err := mgr.ignitionTemplate(host.Profile)
+	if err != nil {
+		return nil, hostmgr.IgnitionReport{}, microerror.Mask(err)
 	}
 
 	var data bytes.Buffer
 	if err = tmpl.Execute(&data, ctx); err != nil {
-		return microerror.Mask(err)
+		return nil, hostmgr.IgnitionReport{}, microerror.Mask(err)
 	}
-	ignitionJSON, err := convertTemplateToJSON(data.Bytes(), false)
+	rendered := data.Bytes()
+
+	// Butane templates are translated to Ignition first, their problems are
+	// reported together with the ones of the resulting config
+	var butaneReport hostmgr.IgnitionReport
+	if isButane(rendered) {
+		rendered, err = translateButane(rendered, mgr.profileFilesDir(host.Profile), &butaneReport)
+		if err != nil {
+			return nil, hostmgr.IgnitionReport{}, microerror.Mask(err)
+		}
+		if rendered == nil {
+			butaneReport.Time = time.Now().UTC()
+			if butaneReport.Version == "" {
+				butaneReport.Version = mgr.ignitionVersion(host)
+			}
+			return nil, butaneReport, nil
+		}
+	}
+
+	if len(storageFiles) > 0 {
+		rendered, err = addStorageFiles(rendered, storageFiles)
+		if err != nil {
+			return nil, hostmgr.IgnitionReport{}, microerror.Mask(err)
+		}
+	}
+	ignitionJSON, report, err := convertTemplateToJSON(rendered, mgr.ignitionVersion(host), false)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, hostmgr.IgnitionReport{}, microerror.Mask(err)
 	}
-	host.State = hostmgr.Installing
-	fmt.Fprintln(wr, string(ignitionJSON[:]))
-	return nil
+	report.Errors = append(butaneReport.Errors, report.Errors...)
+	report.Warnings = append(butaneReport.Warnings, report.Warnings...)
+
+	return ignitionJSON, report, nil
 }
 
-var snippetsFiles []string
+// ignitionVersion returns the Ignition spec version configured for the
+// profile of host. It is empty in case the version of the template is kept.
+func (mgr *pxeManagerT) ignitionVersion(host hostmgr.Host) string {
+	if profile, ok := mgr.config.profile(host.Profile); ok && profile.IgnitionVersion != "" {
+		return profile.IgnitionVersion
+	}
+
+	return mgr.config.DefaultIgnitionVersion
+}
 
-func maybeInitSnippets(snippets string) {
-	if snippetsFiles != nil {
-		return
+// profileFilesDir returns the files directory of the given profile, or the
+// one of the cluster in case the profile does not give one.
+func (mgr *pxeManagerT) profileFilesDir(profileName string) string {
+	if profile, ok := mgr.config.profile(profileName); ok && profile.FilesDir != "" {
+		return profile.FilesDir
 	}
-	snippetsFiles = []string{}
 
-	if len(snippets) > 0 {
-		if _, err := os.Stat(snippets); err == nil {
-			if fis, err := ioutil.ReadDir(snippets); err == nil {
-				for _, fi := range fis {
-					snippetsFiles = append(snippetsFiles, path.Join(snippets, fi.Name()))
-				}
-			}
+	return mgr.filesDir
+}
+
+// ignitionTemplate returns the Ignition template of the given profile. It
+// consists of the base template of the profile, or the cluster in case the
+// profile does not give one, and the shared snippets of the cluster followed
+// by the snippets of the profile.
+func (mgr *pxeManagerT) ignitionTemplate(profileName string) (*template.Template, error) {
+	base := mgr.ignitionConfig
+	snippetsDirs := []string{mgr.templateSnippets}
+	if profile, ok := mgr.config.profile(profileName); ok {
+		if profile.IgnitionConfig != "" {
+			base = profile.IgnitionConfig
 		}
+		snippetsDirs = append(snippetsDirs, profile.TemplateSnippets...)
 	}
+
+	tmpl, err := getTemplate(base, snippetsDirs)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return tmpl, nil
 }
 
-func join(sep string, i []interface{}) string {
-	var s []string
-	for _, si := range i {
-		s = append(s, si.(string))
+// snippetsFiles returns the files within the snippets directory. Missing
+// directories do not provide any snippets.
+func snippetsFiles(snippets string) ([]string, error) {
+	if snippets == "" {
+		return nil, nil
 	}
-	return strings.Join(s, sep)
+
+	fis, err := ioutil.ReadDir(snippets)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var files []string
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			files = append(files, path.Join(snippets, fi.Name()))
+		}
+	}
+	return files, nil
 }
 
-func getTemplate(path, snippets string) (*template.Template, error) {
-	maybeInitSnippets(snippets)
+// getTemplate parses the template at path together with the snippets found
+// in the snippets directories. Snippet files parsed later replace the
+// templates defined by earlier ones.
+func getTemplate(path string, snippetsDirs []string) (*template.Template, error) {
 	templates := []string{path}
-	templates = append(templates, snippetsFiles...)
+	for _, snippets := range snippetsDirs {
+		files, err := snippetsFiles(snippets)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		templates = append(templates, files...)
+	}
 
 	name := filepath.Base(path)
 	tmpl := template.New(name)
-	tmpl.Funcs(map[string]interface{}{
-		"join": join,
-	})
+	tmpl.Funcs(templateFuncs)
 
 	var err error
 	tmpl, err = tmpl.ParseFiles(templates...)
@@ -124,30 +302,71 @@ func getTemplate(path, snippets string) (*template.Template, error) {
 	return tmpl, nil
 }
 
-func convertTemplateToJSON(dataIn []byte, pretty bool) ([]byte, error) {
-	cfg := Config{}
+// convertTemplateToJSON converts the rendered Ignition template dataIn to
+// JSON. The config is translated to the given spec version, unless it is
+// empty. The template and, in case it got translated, the resulting config
+// are validated against their spec. The config is nil in case it cannot be
+// decoded or translated, which the report lists as error.
+func convertTemplateToJSON(dataIn []byte, version string, pretty bool) ([]byte, hostmgr.IgnitionReport, error) {
+	report := hostmgr.IgnitionReport{Time: time.Now().UTC()}
+
+	dataJSON, err := yaml.YAMLToJSON(dataIn)
+	if err != nil {
+		return nil, report, microerror.Maskf(executionFailedError, "failed to unmarshal input: %v", err)
+	}
 
-	if err := yaml.Unmarshal(dataIn, &cfg); err != nil {
-		return nil, microerror.Maskf(executionFailedError, "failed to unmarshal input: %v", err)
+	var header struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
 	}
+	if err := json.Unmarshal(dataJSON, &header); err != nil {
+		return nil, report, microerror.Maskf(executionFailedError, "failed to unmarshal input: %v", err)
+	}
+	from, err := parseIgnitionVersion(header.Ignition.Version)
+	if err != nil {
+		return nil, report, microerror.Maskf(executionFailedError, "template: %v", err)
+	}
+	to := from
+	if version != "" {
+		to, err = parseIgnitionVersion(version)
+		if err != nil {
+			return nil, report, microerror.Mask(err)
+		}
+	}
+	report.Version = to
 
-	var (
-		dataOut []byte
-		err     error
-	)
+	// configs failing to decode or translate are reported as invalid
+	validateIgnition(dataJSON, from, &report)
+	cfg, err := decodeIgnition(dataJSON, from)
+	if err != nil {
+		addIgnitionReportEntry(&report.Errors, "", err.Error())
+		return nil, report, nil
+	}
+	if to != from {
+		cfg, err = translateIgnition(cfg, from, to)
+		if err != nil {
+			addIgnitionReportEntry(&report.Errors, "", err.Error())
+			return nil, report, nil
+		}
+	}
 
+	var dataOut []byte
 	if pretty {
-		dataOut, err = json.MarshalIndent(&cfg, "", "  ")
+		dataOut, err = json.MarshalIndent(cfg, "", "  ")
 		if err != nil {
-			return nil, microerror.Maskf(executionFailedError, "failed to marshal output: %v", err)
+			return nil, report, microerror.Maskf(executionFailedError, "failed to marshal output: %v", err)
 		}
 		dataOut = append(dataOut, '\n')
 	} else {
-		dataOut, err = json.Marshal(&cfg)
+		dataOut, err = json.Marshal(cfg)
 		if err != nil {
-			return nil, microerror.Maskf(executionFailedError, "failed to marshal output: %v", err)
+			return nil, report, microerror.Maskf(executionFailedError, "failed to marshal output: %v", err)
 		}
 	}
+	if to != from {
+		validateIgnition(dataOut, to, &report)
+	}
 
-	return dataOut, nil
+	return dataOut, report, nil
 }
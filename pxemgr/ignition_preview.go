@@ -0,0 +1,134 @@
+package pxemgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// prepareIgnitionHost sets the attributes of host which change when it
+// requests its Ignition config.
+func prepareIgnitionHost(host *hostmgr.Host) {
+	host.State = hostmgr.Installing
+	host.Hostname = strings.Replace(host.InternalAddr.String(), ".", "-", 4)
+}
+
+// recordIgnitionConfig records the Ignition config host received, with
+// encrypted template variables masked. Failures are logged only, as they do
+// not keep the host from installing.
+func (mgr *pxeManagerT) recordIgnitionConfig(host *hostmgr.Host) {
+	masked, _, err := mgr.renderIgnitionConfig(*host, true)
+	if err == nil && masked != nil {
+		err = host.SetLastIgnition(masked)
+	}
+	if err != nil {
+		_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("failed to record ignition config of host %s", host.Serial), "stack", err)
+	}
+}
+
+// previewIgnitionConfig renders the Ignition config host would receive when
+// requesting it now, without changing any state. Hosts not known yet are
+// rendered as they would be created without inventory. profile replaces the
+// profile of host, unless it is empty. Encrypted template variables are
+// masked.
+func (mgr *pxeManagerT) previewIgnitionConfig(serial, profile string) (hostmgr.Host, []byte, hostmgr.IgnitionReport, error) {
+	var host hostmgr.Host
+	if existing, exists := mgr.cluster.HostWithSerial(serial); exists {
+		host = *existing
+	} else {
+		host = hostmgr.Host{Serial: serial}
+		mgr.initNewHost(&host, nil)
+	}
+	if profile != "" {
+		host.Profile = profile
+	}
+	prepareIgnitionHost(&host)
+
+	config, report, err := mgr.renderIgnitionConfig(host, true)
+	if err != nil {
+		return host, nil, report, microerror.Mask(err)
+	}
+
+	return host, config, report, nil
+}
+
+// ignitionDiff returns the unified diff between the Ignition config host
+// received last and config.
+func ignitionDiff(host hostmgr.Host, config []byte) (string, error) {
+	from := "/dev/null"
+	var last []byte
+	record, err := host.LastIgnition()
+	if hostmgr.IsNotFound(err) {
+		// compare against nothing
+	} else if err != nil {
+		return "", microerror.Mask(err)
+	} else {
+		from = fmt.Sprintf("received %s", record.Time.Format("2006-01-02T15:04:05Z07:00"))
+		last, err = indentJSON(record.Config)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+	}
+
+	current, err := indentJSON(config)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(last)),
+		B:        difflib.SplitLines(string(current)),
+		FromFile: from,
+		ToFile:   "preview",
+		Context:  3,
+	})
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return diff, nil
+}
+
+func indentJSON(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.Indent(&b, data, "", "  "); err != nil {
+		return nil, microerror.Mask(err)
+	}
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// WriteIgnitionPreview renders the Ignition config the host given by serial
+// would receive to wr, or the diff against the config it received last. No
+// state is changed and encrypted template variables are masked. profile
+// replaces the profile of the host, unless it is empty.
+func (mgr *pxeManagerT) WriteIgnitionPreview(serial, profile string, diff bool, wr io.Writer) error {
+	serial = strings.ToLower(serial)
+	owner := mgr.clusterFor(serial, nil)
+
+	host, config, report, err := owner.previewIgnitionConfig(serial, profile)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !report.Valid() {
+		return microerror.Maskf(invalidIgnitionError, "Ignition %s config of host %s is invalid:\n%s", report.Version, serial, report.String())
+	}
+
+	if !diff {
+		fmt.Fprintln(wr, string(config))
+		return nil
+	}
+	d, err := ignitionDiff(host, config)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	_, err = io.WriteString(wr, d)
+	return microerror.Mask(err)
+}
@@ -0,0 +1,152 @@
+package pxemgr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func TestHostIgnition(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_ok.yaml")
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	host, err := h.cluster.CreateNewHost("test1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.InternalAddr = net.ParseIP("10.0.0.5")
+	host.State = hostmgr.Configured
+	if err := host.Commit("set address"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(serial, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mgr.hostIgnition(serial, w, httptest.NewRequest("GET", "http://127.0.0.1:4080/admin/host/"+serial+"/ignition?"+query, nil))
+		return w
+	}
+
+	if w := get("test1234", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d without dry_run, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := get("unknown", "dry_run=true"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d for unknown host, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w := get("test1234", "dry_run=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	preview := w.Body.String()
+
+	// the preview does not change the host
+	host, _ = h.cluster.HostWithSerial("test1234")
+	if host.State != hostmgr.Configured || host.Hostname != "" {
+		t.Fatalf("preview changed host %#v", host)
+	}
+	if _, err := host.LastIgnition(); !hostmgr.IsNotFound(err) {
+		t.Fatalf("expected no ignition to be recorded, got %#v", err)
+	}
+	w = get("test1234", "dry_run=true&diff=true")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "--- /dev/null\n+++ preview\n") {
+		t.Fatalf("unexpected diff %d: %s", w.Code, w.Body.String())
+	}
+
+	// the preview is what the host receives, which gets recorded
+	mgr.ignitionGenerator(h.w, h.req)
+	if h.w.Code != http.StatusOK {
+		t.Fatalf("ignition handler returned wrong status code: got %v want %v", h.w.Code, http.StatusOK)
+	}
+	if h.w.Body.String() != preview {
+		t.Fatalf("expected preview\n%s\nto equal served config\n%s", preview, h.w.Body.String())
+	}
+	host, _ = h.cluster.HostWithSerial("test1234")
+	if _, err := host.LastIgnition(); err != nil {
+		t.Fatalf("expected ignition to be recorded: %s", err)
+	}
+	w = get("test1234", "dry_run=true&diff=true")
+	if w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Fatalf("expected no changes, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// unknown hosts are rendered as they would be created
+	var b bytes.Buffer
+	if err := mgr.WriteIgnitionPreview("Unknown", "", false, &b); err != nil {
+		t.Fatalf("previewing unknown host: %s", err)
+	}
+	if _, exists := h.cluster.HostWithSerial("unknown"); exists {
+		t.Fatalf("preview created host")
+	}
+}
+
+func TestReadOnlyManager(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	config := strings.Replace(configOK, "  primary_nic:\n", `  extra_nics:
+  - interface_name: em2
+    ip_range:
+      start: 10.1.0.10
+      end: 10.1.0.20
+  primary_nic:
+`, 1)
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_nics.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+
+	host, err := h.cluster.CreateNewHost("test1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.InternalAddr = net.ParseIP("10.0.0.5")
+	host.State = hostmgr.Configured
+	if err := host.Commit("set address"); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_nics.yaml")
+	h.pxeCfg.ReadOnly = true
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	// neither an etcd discovery token nor addresses get committed
+	if h.cluster.Config.DefaultEtcdClusterToken != "" {
+		t.Fatalf("expected no default etcd cluster token, got '%s'", h.cluster.Config.DefaultEtcdClusterToken)
+	}
+	host, _ = h.cluster.HostWithSerial("test1234")
+	if len(host.AdditionalAddrs) != 0 {
+		t.Fatalf("expected no additional addresses, got %v", host.AdditionalAddrs)
+	}
+
+	var b bytes.Buffer
+	if err := mgr.WriteIgnitionPreview("test1234", "", false, &b); err != nil {
+		t.Fatalf("previewing host: %s", err)
+	}
+}
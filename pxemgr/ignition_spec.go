@@ -0,0 +1,121 @@
+package pxemgr
+
+import (
+	"encoding/json"
+	"strings"
+
+	v22types "github.com/coreos/ignition/config/v2_2/types"
+	v30types "github.com/coreos/ignition/v2/config/v3_0/types"
+	v31translate "github.com/coreos/ignition/v2/config/v3_1/translate"
+	v31types "github.com/coreos/ignition/v2/config/v3_1/types"
+	v32translate "github.com/coreos/ignition/v2/config/v3_2/translate"
+	v32types "github.com/coreos/ignition/v2/config/v3_2/types"
+	v33translate "github.com/coreos/ignition/v2/config/v3_3/translate"
+	v33types "github.com/coreos/ignition/v2/config/v3_3/types"
+	"github.com/giantswarm/microerror"
+)
+
+// ignitionSpecs are the Ignition spec versions mayu renders. Configs are
+// translated from one version to the next in this order.
+var ignitionSpecs = []string{"2.2.0", "3.0.0", "3.1.0", "3.2.0", "3.3.0"}
+
+// parseIgnitionVersion normalizes the spec version, eg. 3.3 to 3.3.0, and
+// checks that it is supported.
+func parseIgnitionVersion(version string) (string, error) {
+	normalized := version
+	if strings.Count(normalized, ".") == 1 {
+		normalized += ".0"
+	}
+	if ignitionSpecIndex(normalized) < 0 {
+		return "", microerror.Maskf(invalidConfigError, "unsupported Ignition spec version '%s', use one of %s", version, strings.Join(ignitionSpecs, ", "))
+	}
+
+	return normalized, nil
+}
+
+func ignitionSpecIndex(version string) int {
+	for i, spec := range ignitionSpecs {
+		if spec == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeIgnition decodes the JSON Ignition config data into the upstream
+// config types of the given spec version.
+func decodeIgnition(data []byte, version string) (interface{}, error) {
+	decode := func(cfg interface{}) error {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return microerror.Maskf(executionFailedError, "failed to decode Ignition %s config: %v", version, err)
+		}
+		return nil
+	}
+
+	switch version {
+	case "2.2.0":
+		var cfg v22types.Config
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	case "3.0.0":
+		var cfg v30types.Config
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	case "3.1.0":
+		var cfg v31types.Config
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	case "3.2.0":
+		var cfg v32types.Config
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	case "3.3.0":
+		var cfg v33types.Config
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
+	return nil, microerror.Maskf(invalidConfigError, "unsupported Ignition spec version '%s'", version)
+}
+
+// translateIgnition translates cfg of the spec version from to the spec
+// version to. Configs are only translated to newer versions.
+func translateIgnition(cfg interface{}, from, to string) (interface{}, error) {
+	fromIndex := ignitionSpecIndex(from)
+	toIndex := ignitionSpecIndex(to)
+	if fromIndex < 0 || toIndex < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "unsupported translation of Ignition config from spec %s to %s", from, to)
+	}
+	if toIndex < fromIndex {
+		return nil, microerror.Maskf(executionFailedError, "cannot translate Ignition config from spec %s to the older spec %s", from, to)
+	}
+
+	for i := fromIndex; i < toIndex; i++ {
+		switch c := cfg.(type) {
+		case v22types.Config:
+			translated, err := translate22to30(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+			cfg = translated
+		case v30types.Config:
+			cfg = v31translate.Translate(c)
+		case v31types.Config:
+			cfg = v32translate.Translate(c)
+		case v32types.Config:
+			cfg = v33translate.Translate(c)
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,259 @@
+package pxemgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v33types "github.com/coreos/ignition/v2/config/v3_3/types"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+const ignition22Template = `ignition:
+  version: 2.2.0
+networkd:
+  units:
+    - name: 10-eth0.network
+      contents: |
+        [Match]
+        Name=eth0
+passwd:
+  users:
+    - name: core
+      sshAuthorizedKeys:
+        - ssh-ed25519 AAAA
+storage:
+  disks:
+    - device: /dev/sda
+      wipeTable: true
+      partitions:
+        - label: docker
+          number: 1
+          size: 2097152
+  files:
+    - filesystem: root
+      path: /etc/hostname
+      mode: 0644
+      contents:
+        source: data:,node-1
+systemd:
+  units:
+    - name: docker.service
+      enable: true
+    - name: update-engine.service
+      mask: true
+    - name: docker.service
+      dropins:
+        - name: 40-docker.conf
+          contents: |
+            [Service]
+            Environment=DOCKER_OPTS=--debug
+`
+
+func TestConvertTemplateToJSON(t *testing.T) {
+	// the template keeps its spec version by default
+	data, report, err := convertTemplateToJSON([]byte(ignition22Template), "", false)
+	if err != nil {
+		t.Fatalf("converting template: %s", err)
+	}
+	if !strings.Contains(string(data), `"version":"2.2.0"`) || !strings.Contains(string(data), `"networkd":{"units":[`) {
+		t.Fatalf("unexpected config %s", data)
+	}
+	if !report.Empty() || report.Version != "2.2.0" {
+		t.Fatalf("unexpected report %#v", report)
+	}
+
+	// spec 2.2 is translated to spec 3
+	data, report, err = convertTemplateToJSON([]byte(ignition22Template), "3.3", false)
+	if err != nil {
+		t.Fatalf("translating template: %s", err)
+	}
+	var cfg v33types.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("decoding config: %s", err)
+	}
+	if !report.Empty() || report.Version != "3.3.0" {
+		t.Fatalf("unexpected report %#v", report)
+	}
+	if cfg.Ignition.Version != "3.3.0" {
+		t.Fatalf("expected version 3.3.0, got %s", cfg.Ignition.Version)
+	}
+	if len(cfg.Storage.Files) != 2 {
+		t.Fatalf("expected 2 files, got %#v", cfg.Storage.Files)
+	}
+	hostname := cfg.Storage.Files[0]
+	if hostname.Path != "/etc/hostname" || *hostname.Mode != 0644 || *hostname.Contents.Source != "data:,node-1" || !*hostname.Overwrite {
+		t.Fatalf("unexpected file %#v", hostname)
+	}
+	network := cfg.Storage.Files[1]
+	if network.Path != "/etc/systemd/network/10-eth0.network" || *network.Contents.Source != "data:,%5BMatch%5D%0AName=eth0%0A" {
+		t.Fatalf("unexpected networkd file %s: %s", network.Path, *network.Contents.Source)
+	}
+	partition := cfg.Storage.Disks[0].Partitions[0]
+	if *partition.SizeMiB != 1024 || *partition.Label != "docker" || !*cfg.Storage.Disks[0].WipeTable {
+		t.Fatalf("unexpected partition %#v", partition)
+	}
+	units := cfg.Systemd.Units
+	if len(units) != 2 || !*units[0].Enabled || len(units[0].Dropins) != 1 || !*units[1].Mask {
+		t.Fatalf("unexpected units %#v", units)
+	}
+	if cfg.Passwd.Users[0].Name != "core" || cfg.Passwd.Users[0].SSHAuthorizedKeys[0] != "ssh-ed25519 AAAA" {
+		t.Fatalf("unexpected users %#v", cfg.Passwd.Users)
+	}
+
+	// fields of spec 3 are kept
+	template33 := `ignition:
+  version: 3.3.0
+storage:
+  luks:
+    - name: data
+      device: /dev/sdb
+kernelArguments:
+  shouldExist:
+    - quiet
+`
+	data, report, err = convertTemplateToJSON([]byte(template33), "", false)
+	if err != nil {
+		t.Fatalf("converting template: %s", err)
+	}
+	if !strings.Contains(string(data), `"device":"/dev/sdb","keyFile"`) || !strings.Contains(string(data), `"kernelArguments":{"shouldExist":["quiet"]}`) {
+		t.Fatalf("unexpected config %s", data)
+	}
+	if !report.Empty() {
+		t.Fatalf("unexpected report %#v", report)
+	}
+
+	failures := []struct {
+		template string
+		version  string
+	}{
+		// configs are not translated to older specs
+		{template33, "2.2"},
+		{template33, "3.0"},
+		// unknown versions
+		{ignition22Template, "4.0"},
+		{strings.Replace(ignition22Template, "2.2.0", "1.0.0", 1), ""},
+		// features dropped by spec 3
+		{strings.Replace(ignition22Template, "filesystem: root", "filesystem: data", 1), "3.0"},
+		{strings.Replace(ignition22Template, "- name: core", "- name: core\n      create: {}", 1), "3.0"},
+		{strings.Replace(ignition22Template, "size: 2097152", "size: 1000", 1), "3.0"},
+	}
+	for i, f := range failures {
+		_, report, err := convertTemplateToJSON([]byte(f.template), f.version, false)
+		if err == nil && report.Valid() {
+			t.Errorf("case %d: expected converting template to spec '%s' to fail", i, f.version)
+		}
+	}
+
+	// problems are reported with their location
+	invalid33 := `ignition:
+  version: 3.3.0
+storage:
+  files:
+    - path: etc/hostname
+      mode: 99999
+systemd:
+  units:
+    - name: docker.service
+    - name: docker.service
+unknown: true
+`
+	_, report, err = convertTemplateToJSON([]byte(invalid33), "", false)
+	if err != nil {
+		t.Fatalf("converting template: %s", err)
+	}
+	expectedErrors := []hostmgr.IgnitionReportEntry{
+		{Path: "$.storage.files.0.path", Message: "path not absolute"},
+		{Path: "$.storage.files.0.mode", Message: "illegal file mode"},
+		{Path: "$.systemd.units.1", Message: "duplicate entry defined"},
+	}
+	if !reflect.DeepEqual(report.Errors, expectedErrors) {
+		t.Fatalf("expected errors %#v, got %#v", expectedErrors, report.Errors)
+	}
+	if !strings.Contains(report.String(), "warning at $.unknown: Unused key unknown") {
+		t.Fatalf("expected unknown key to be reported, got %s", report)
+	}
+}
+
+func TestIgnitionVersion(t *testing.T) {
+	mgr := &pxeManagerT{
+		config: &Configuration{
+			DefaultIgnitionVersion: "3.3",
+			Profiles: []Profile{
+				{Name: "legacy", IgnitionVersion: "2.2"},
+				{Name: "worker"},
+			},
+		},
+	}
+
+	for profile, expected := range map[string]string{"legacy": "2.2", "worker": "3.3", "": "3.3"} {
+		if version := mgr.ignitionVersion(hostmgr.Host{Profile: profile}); version != expected {
+			t.Errorf("expected version %s for profile '%s', got %s", expected, profile, version)
+		}
+	}
+}
+
+func TestIgnitionTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pxemgr_templates_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"ignition.yaml":         `base {{template "net"}} {{template "extra"}}`,
+		"storage.yaml":          `storage {{template "net"}} {{template "extra"}} {{template "disks"}}`,
+		"snippets/net.yaml":     `{{define "net"}}shared-net{{end}}`,
+		"snippets/extra.yaml":   `{{define "extra"}}shared-extra{{end}}`,
+		"storage/net.yaml":      `{{define "net"}}storage-net{{end}}`,
+		"storage/disks.yaml":    `{{define "disks"}}storage-disks{{end}}`,
+		"worker/extra.yaml":     `{{define "extra"}}worker-extra{{end}}`,
+		"worker/ignored/x.yaml": `{{define "extra"}}ignored{{end}}`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+	}
+
+	mgr := &pxeManagerT{
+		ignitionConfig:   filepath.Join(dir, "ignition.yaml"),
+		templateSnippets: filepath.Join(dir, "snippets"),
+		config: &Configuration{
+			Profiles: []Profile{
+				{Name: "storage", IgnitionConfig: filepath.Join(dir, "storage.yaml"), TemplateSnippets: []string{filepath.Join(dir, "storage")}},
+				{Name: "worker", TemplateSnippets: []string{filepath.Join(dir, "worker"), filepath.Join(dir, "missing")}},
+				{Name: "core"},
+			},
+		},
+	}
+
+	expected := map[string]string{
+		"storage": "storage storage-net shared-extra storage-disks",
+		"worker":  "base shared-net worker-extra",
+		"core":    "base shared-net shared-extra",
+		"":        "base shared-net shared-extra",
+	}
+	for profile, e := range expected {
+		tmpl, err := mgr.ignitionTemplate(profile)
+		if err != nil {
+			t.Fatalf("parsing template of profile '%s': %s", profile, err)
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, nil); err != nil {
+			t.Fatalf("executing template of profile '%s': %s", profile, err)
+		}
+		if b.String() != e {
+			t.Errorf("expected '%s' for profile '%s', got '%s'", e, profile, b.String())
+		}
+	}
+}
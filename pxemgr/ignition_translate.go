@@ -0,0 +1,350 @@
+package pxemgr
+
+import (
+	"net/url"
+	"path"
+
+	v22types "github.com/coreos/ignition/config/v2_2/types"
+	v30types "github.com/coreos/ignition/v2/config/v3_0/types"
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	// networkdDir keeps the networkd units of spec 2 configs translated to
+	// spec 3, which has no networkd section anymore.
+	networkdDir = "/etc/systemd/network"
+	// sectorsPerMiB converts the partition sizes of spec 2, given in 512
+	// byte sectors, to the MiB used by spec 3.
+	sectorsPerMiB = 2048
+)
+
+// translate22to30 translates an Ignition config from spec 2.2 to spec 3.0.
+// Networkd units become files below /etc/systemd/network. Configs using
+// features dropped by spec 3, eg. creating users via create or writing to
+// filesystems without known mount point, cannot be translated.
+func translate22to30(old v22types.Config) (v30types.Config, error) {
+	ret := v30types.Config{
+		Ignition: v30types.Ignition{
+			Version: "3.0.0",
+			Timeouts: v30types.Timeouts{
+				HTTPResponseHeaders: old.Ignition.Timeouts.HTTPResponseHeaders,
+				HTTPTotal:           old.Ignition.Timeouts.HTTPTotal,
+			},
+		},
+	}
+
+	for _, ref := range old.Ignition.Config.Append {
+		ret.Ignition.Config.Merge = append(ret.Ignition.Config.Merge, v30types.ConfigReference{
+			Source:       stringPtr(ref.Source),
+			Verification: v30types.Verification{Hash: ref.Verification.Hash},
+		})
+	}
+	if ref := old.Ignition.Config.Replace; ref != nil {
+		ret.Ignition.Config.Replace = v30types.ConfigReference{
+			Source:       stringPtr(ref.Source),
+			Verification: v30types.Verification{Hash: ref.Verification.Hash},
+		}
+	}
+	for _, ca := range old.Ignition.Security.TLS.CertificateAuthorities {
+		ret.Ignition.Security.TLS.CertificateAuthorities = append(ret.Ignition.Security.TLS.CertificateAuthorities, v30types.CaReference{
+			Source:       ca.Source,
+			Verification: v30types.Verification{Hash: ca.Verification.Hash},
+		})
+	}
+
+	passwd, err := translatePasswd22to30(old.Passwd)
+	if err != nil {
+		return v30types.Config{}, microerror.Mask(err)
+	}
+	ret.Passwd = passwd
+
+	storage, err := translateStorage22to30(old.Storage)
+	if err != nil {
+		return v30types.Config{}, microerror.Mask(err)
+	}
+	ret.Storage = storage
+
+	for _, unit := range old.Networkd.Units {
+		if unit.Contents != "" {
+			ret.Storage.Files = append(ret.Storage.Files, networkdFile(unit.Name, unit.Contents))
+		}
+		for _, dropin := range unit.Dropins {
+			ret.Storage.Files = append(ret.Storage.Files, networkdFile(path.Join(unit.Name+".d", dropin.Name), dropin.Contents))
+		}
+	}
+
+	// Spec 2 applies units given several times one after another, spec 3
+	// rejects them. Their settings are merged, later ones win.
+	units := map[string]int{}
+	for _, unit := range old.Systemd.Units {
+		i, exists := units[unit.Name]
+		if !exists {
+			i = len(ret.Systemd.Units)
+			units[unit.Name] = i
+			ret.Systemd.Units = append(ret.Systemd.Units, v30types.Unit{Name: unit.Name})
+		}
+		newUnit := &ret.Systemd.Units[i]
+
+		if unit.Contents != "" {
+			newUnit.Contents = stringPtr(unit.Contents)
+		}
+		if unit.Enabled != nil {
+			newUnit.Enabled = unit.Enabled
+		}
+		if unit.Enable {
+			newUnit.Enabled = boolPtr(true)
+		}
+		if unit.Mask {
+			newUnit.Mask = boolPtr(true)
+		}
+		for _, dropin := range unit.Dropins {
+			newDropin := v30types.Dropin{
+				Name:     dropin.Name,
+				Contents: stringPtr(dropin.Contents),
+			}
+			replaced := false
+			for j := range newUnit.Dropins {
+				if newUnit.Dropins[j].Name == dropin.Name {
+					newUnit.Dropins[j] = newDropin
+					replaced = true
+				}
+			}
+			if !replaced {
+				newUnit.Dropins = append(newUnit.Dropins, newDropin)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func translatePasswd22to30(old v22types.Passwd) (v30types.Passwd, error) {
+	ret := v30types.Passwd{}
+
+	for _, user := range old.Users {
+		if user.Create != nil {
+			return v30types.Passwd{}, microerror.Maskf(executionFailedError, "user '%s' uses create, which Ignition spec 3 does not support", user.Name)
+		}
+
+		newUser := v30types.PasswdUser{
+			Name:         user.Name,
+			PasswordHash: user.PasswordHash,
+			UID:          user.UID,
+			Gecos:        stringPtr(user.Gecos),
+			HomeDir:      stringPtr(user.HomeDir),
+			NoCreateHome: boolPtr(user.NoCreateHome),
+			PrimaryGroup: stringPtr(user.PrimaryGroup),
+			NoUserGroup:  boolPtr(user.NoUserGroup),
+			NoLogInit:    boolPtr(user.NoLogInit),
+			Shell:        stringPtr(user.Shell),
+			System:       boolPtr(user.System),
+		}
+		for _, key := range user.SSHAuthorizedKeys {
+			newUser.SSHAuthorizedKeys = append(newUser.SSHAuthorizedKeys, v30types.SSHAuthorizedKey(key))
+		}
+		for _, group := range user.Groups {
+			newUser.Groups = append(newUser.Groups, v30types.Group(group))
+		}
+		ret.Users = append(ret.Users, newUser)
+	}
+
+	for _, group := range old.Groups {
+		ret.Groups = append(ret.Groups, v30types.PasswdGroup{
+			Name:         group.Name,
+			Gid:          group.Gid,
+			PasswordHash: stringPtr(group.PasswordHash),
+			System:       boolPtr(group.System),
+		})
+	}
+
+	return ret, nil
+}
+
+func translateStorage22to30(old v22types.Storage) (v30types.Storage, error) {
+	ret := v30types.Storage{}
+
+	for _, disk := range old.Disks {
+		newDisk := v30types.Disk{
+			Device:    disk.Device,
+			WipeTable: boolPtr(disk.WipeTable),
+		}
+		for _, p := range disk.Partitions {
+			if p.Size%sectorsPerMiB != 0 || p.Start%sectorsPerMiB != 0 {
+				return v30types.Storage{}, microerror.Maskf(executionFailedError, "partition %d of disk %s is not aligned to MiB, which Ignition spec 3 requires", p.Number, disk.Device)
+			}
+			newDisk.Partitions = append(newDisk.Partitions, v30types.Partition{
+				Label:    stringPtr(p.Label),
+				Number:   p.Number,
+				SizeMiB:  intPtr(p.Size / sectorsPerMiB),
+				StartMiB: intPtr(p.Start / sectorsPerMiB),
+				TypeGUID: stringPtr(p.TypeGUID),
+				GUID:     stringPtr(p.GUID),
+			})
+		}
+		ret.Disks = append(ret.Disks, newDisk)
+	}
+
+	for _, raid := range old.Raid {
+		newRaid := v30types.Raid{
+			Name:  raid.Name,
+			Level: raid.Level,
+		}
+		if raid.Spares != 0 {
+			newRaid.Spares = intPtr(raid.Spares)
+		}
+		for _, device := range raid.Devices {
+			newRaid.Devices = append(newRaid.Devices, v30types.Device(device))
+		}
+		for _, option := range raid.Options {
+			newRaid.Options = append(newRaid.Options, v30types.RaidOption(option))
+		}
+		ret.Raid = append(ret.Raid, newRaid)
+	}
+
+	// Spec 2 places files, directories and links on named filesystems, spec
+	// 3 uses absolute paths. Filesystems are only known to be mounted in
+	// case they are the root filesystem or give their path.
+	mountpoints := map[string]string{"root": "/"}
+	for _, fs := range old.Filesystems {
+		if fs.Path != nil {
+			mountpoints[fs.Name] = *fs.Path
+		}
+		if fs.Mount == nil {
+			continue
+		}
+
+		newFS := v30types.Filesystem{
+			Device:         fs.Mount.Device,
+			Format:         stringPtr(fs.Mount.Format),
+			Label:          fs.Mount.Label,
+			UUID:           fs.Mount.UUID,
+			Path:           fs.Path,
+			WipeFilesystem: boolPtr(fs.Mount.WipeFilesystem),
+		}
+		for _, option := range fs.Mount.Options {
+			newFS.Options = append(newFS.Options, v30types.FilesystemOption(option))
+		}
+		if create := fs.Mount.Create; create != nil {
+			newFS.WipeFilesystem = boolPtr(fs.Mount.WipeFilesystem || create.Force)
+			for _, option := range create.Options {
+				newFS.Options = append(newFS.Options, v30types.FilesystemOption(option))
+			}
+		}
+		ret.Filesystems = append(ret.Filesystems, newFS)
+	}
+
+	translateNode := func(node v22types.Node) (v30types.Node, error) {
+		filesystem := node.Filesystem
+		if filesystem == "" {
+			filesystem = "root"
+		}
+		mountpoint, ok := mountpoints[filesystem]
+		if !ok {
+			return v30types.Node{}, microerror.Maskf(executionFailedError, "%s is placed on filesystem '%s', whose mount point is unknown", node.Path, filesystem)
+		}
+
+		newNode := v30types.Node{
+			Path:      path.Join(mountpoint, node.Path),
+			Overwrite: node.Overwrite,
+		}
+		if node.User != nil {
+			newNode.User = v30types.NodeUser{ID: node.User.ID, Name: stringPtr(node.User.Name)}
+		}
+		if node.Group != nil {
+			newNode.Group = v30types.NodeGroup{ID: node.Group.ID, Name: stringPtr(node.Group.Name)}
+		}
+		return newNode, nil
+	}
+
+	for _, file := range old.Files {
+		node, err := translateNode(file.Node)
+		if err != nil {
+			return v30types.Storage{}, microerror.Mask(err)
+		}
+		contents := v30types.FileContents{
+			Source:       stringPtr(file.Contents.Source),
+			Compression:  stringPtr(file.Contents.Compression),
+			Verification: v30types.Verification{Hash: file.Contents.Verification.Hash},
+		}
+
+		newFile := v30types.File{
+			Node:          node,
+			FileEmbedded1: v30types.FileEmbedded1{Mode: file.Mode},
+		}
+		if file.Append {
+			newFile.Append = []v30types.FileContents{contents}
+		} else {
+			newFile.Contents = contents
+			// spec 2 overwrites existing files by default, spec 3 does not
+			if newFile.Overwrite == nil && contents.Source != nil {
+				newFile.Overwrite = boolPtr(true)
+			}
+		}
+		ret.Files = append(ret.Files, newFile)
+	}
+
+	for _, dir := range old.Directories {
+		node, err := translateNode(dir.Node)
+		if err != nil {
+			return v30types.Storage{}, microerror.Mask(err)
+		}
+		ret.Directories = append(ret.Directories, v30types.Directory{
+			Node:               node,
+			DirectoryEmbedded1: v30types.DirectoryEmbedded1{Mode: dir.Mode},
+		})
+	}
+
+	for _, link := range old.Links {
+		node, err := translateNode(link.Node)
+		if err != nil {
+			return v30types.Storage{}, microerror.Mask(err)
+		}
+		ret.Links = append(ret.Links, v30types.Link{
+			Node: node,
+			LinkEmbedded1: v30types.LinkEmbedded1{
+				Target: link.Target,
+				Hard:   boolPtr(link.Hard),
+			},
+		})
+	}
+
+	return ret, nil
+}
+
+// networkdFile returns the file keeping the networkd unit or drop-in name.
+func networkdFile(name, contents string) v30types.File {
+	return v30types.File{
+		Node: v30types.Node{
+			Path:      path.Join(networkdDir, name),
+			Overwrite: boolPtr(true),
+		},
+		FileEmbedded1: v30types.FileEmbedded1{
+			Mode: intPtr(0644),
+			Contents: v30types.FileContents{
+				Source: stringPtr("data:," + url.PathEscape(contents)),
+			},
+		},
+	}
+}
+
+// stringPtr returns a pointer to s, or nil for the empty string, which spec
+// 2 uses for unset values.
+func stringPtr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// boolPtr returns a pointer to b, or nil for false, which spec 2 uses for
+// unset values.
+func boolPtr(b bool) *bool {
+	if !b {
+		return nil
+	}
+	return &b
+}
+
+func intPtr(i int) *int {
+	return &i
+}
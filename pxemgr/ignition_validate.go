@@ -0,0 +1,76 @@
+package pxemgr
+
+import (
+	v22 "github.com/coreos/ignition/config/v2_2"
+	v22report "github.com/coreos/ignition/config/validate/report"
+	v30 "github.com/coreos/ignition/v2/config/v3_0"
+	v31 "github.com/coreos/ignition/v2/config/v3_1"
+	v32 "github.com/coreos/ignition/v2/config/v3_2"
+	v33 "github.com/coreos/ignition/v2/config/v3_3"
+	"github.com/coreos/vcontext/report"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// validateIgnition validates the JSON Ignition config data against the
+// given spec version and adds the problems found to r.
+func validateIgnition(data []byte, version string, r *hostmgr.IgnitionReport) {
+	var rpt report.Report
+	var err error
+	switch version {
+	case "2.2.0":
+		var rpt22 v22report.Report
+		_, rpt22, err = v22.Parse(data)
+		for _, entry := range rpt22.Entries {
+			switch entry.Kind {
+			case v22report.EntryError:
+				addIgnitionReportEntry(&r.Errors, "", entry.Message)
+			case v22report.EntryWarning, v22report.EntryDeprecated:
+				addIgnitionReportEntry(&r.Warnings, "", entry.Message)
+			}
+		}
+		if err != nil && !rpt22.IsFatal() {
+			addIgnitionReportEntry(&r.Errors, "", err.Error())
+		}
+		return
+	case "3.0.0":
+		_, rpt, err = v30.Parse(data)
+	case "3.1.0":
+		_, rpt, err = v31.Parse(data)
+	case "3.2.0":
+		_, rpt, err = v32.Parse(data)
+	case "3.3.0":
+		_, rpt, err = v33.Parse(data)
+	default:
+		addIgnitionReportEntry(&r.Errors, "", "unsupported Ignition spec version "+version)
+		return
+	}
+
+	for _, entry := range rpt.Entries {
+		path := ""
+		if entry.Context.Len() != 0 {
+			path = entry.Context.String()
+		}
+		switch entry.Kind {
+		case report.Error:
+			addIgnitionReportEntry(&r.Errors, path, entry.Message)
+		case report.Warn:
+			addIgnitionReportEntry(&r.Warnings, path, entry.Message)
+		}
+	}
+	if err != nil && !rpt.IsFatal() {
+		addIgnitionReportEntry(&r.Errors, "", err.Error())
+	}
+}
+
+// addIgnitionReportEntry adds the problem to entries, unless it is known
+// already, eg. from validating a config before and after translating it.
+func addIgnitionReportEntry(entries *[]hostmgr.IgnitionReportEntry, path, message string) {
+	entry := hostmgr.IgnitionReportEntry{Path: path, Message: message}
+	for _, e := range *entries {
+		if e == entry {
+			return
+		}
+	}
+	*entries = append(*entries, entry)
+}
@@ -2,7 +2,10 @@ package pxemgr
 
 import (
 	"bytes"
+	"math/big"
 	"net"
+
+	"github.com/giantswarm/microerror"
 )
 
 func incIP(ip net.IP) net.IP {
@@ -26,3 +29,54 @@ func ipLessThanOrEqual(ip net.IP, upperBound net.IP) bool {
 func ipMoreThanOrEqual(ip net.IP, upperBound net.IP) bool {
 	return bytes.Compare(ip, upperBound) >= 0
 }
+
+// ipInRange checks whether ip is within the given range. Ranges missing their
+// start or end contain no addresses.
+func ipInRange(ip net.IP, r NetworkRange) bool {
+	start := net.ParseIP(r.Start)
+	end := net.ParseIP(r.End)
+	if start == nil || end == nil {
+		return false
+	}
+	ip = ip.To16()
+	return ipMoreThanOrEqual(ip, start) && ipLessThanOrEqual(ip, end)
+}
+
+// addToIP returns the address n addresses after ip, or before ip for negative
+// n. It fails in case the result leaves the address family of ip.
+func addToIP(ip net.IP, n int64) (net.IP, error) {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	sum := new(big.Int).Add(new(big.Int).SetBytes(ip), big.NewInt(n))
+	if sum.Sign() < 0 || sum.BitLen() > len(ip)*8 {
+		return nil, microerror.Maskf(executionFailedError, "adding %d to %s leaves the address space", n, ip)
+	}
+
+	ret := make(net.IP, len(ip))
+	b := sum.Bytes()
+	copy(ret[len(ret)-len(b):], b)
+	return ret, nil
+}
+
+// cidrHost returns the n-th address of the network given in CIDR notation.
+// Negative n count from the end of the network, eg. -1 is its last address.
+func cidrHost(cidr string, n int64) (net.IP, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	ones, bits := network.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	offset := big.NewInt(n)
+	if n < 0 {
+		offset.Add(offset, size)
+	}
+	if offset.Sign() < 0 || offset.Cmp(size) >= 0 {
+		return nil, microerror.Maskf(executionFailedError, "network %s has no host number %d", cidr, n)
+	}
+
+	return addToIP(network.IP, offset.Int64())
+}
@@ -1,7 +1,6 @@
 package pxemgr
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,91 +27,163 @@ const (
 )
 
 func (mgr *pxeManagerT) ipxeBootScript(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(200)
-	buffer := bytes.NewBufferString("")
-	extraFlags := ""
+	serial := hostSerial(r)
+	if len(mgr.clusters) > 0 || mgr.signURLs() || mgr.preregisteredOnly || mgr.hasNoCloudProfiles() {
+		if _, chained := r.URL.Query()["mac"]; serial == "" && chained {
+			// iPXE knows neither serial nor UUID of the machine, asking
+			// again would loop forever
+			_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("empty serial of machine %s", r.URL.Query().Get("mac")))
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("no serial ? :/"))
+			return
+		}
+		if serial == "" {
+			// dnsmasq does not know the serial of the machine, so let iPXE
+			// ask again once it is known to route the machine to its cluster
+			// and sign its config URL
+			w.WriteHeader(200)
+			_, _ = w.Write([]byte(fmt.Sprintf("#!ipxe\nchain %s/ipxebootscript?uuid=${uuid}&serial=${serial}&mac=${netX/mac}\n", mgr.pxeURL())))
+			return
+		}
+		if owner := mgr.clusterFor(serial, remoteIP(r)); owner != mgr {
+			owner.ipxeBootScript(w, r)
+			return
+		}
+	}
+
+	if mgr.preregisteredOnly && !mgr.isRegistered(serial) {
+		_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing to boot host %s, it is not pre-registered", serial))
+		w.WriteHeader(403)
+		_, _ = w.Write([]byte("host is not pre-registered"))
+		return
+	}
+
+	token := ""
+	if mgr.signURLs() {
+		mac := r.URL.Query().Get("mac")
+		if _, err := net.ParseMAC(mac); err != nil {
+			mac = ""
+		}
+		if err := mgr.checkMachine(serial, mac, remoteIP(r)); err != nil {
+			_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing to boot host %s", serial), "stack", err)
+			w.WriteHeader(403)
+			_, _ = w.Write([]byte("machine does not match host"))
+			return
+		}
+		token = mgr.hostToken(serial, mac, remoteIP(r))
+	}
+
+	profile := mgr.bootProfile(serial)
+	p := mgr.provisioner(profile)
+	configURL := p.configURL(serial, r.URL.Query().Get("uuid"), token)
+
+	var kernelArgs []string
 	if mgr.consoleTTY {
-		extraFlags += " console=ttyS0"
+		kernelArgs = append(kernelArgs, "console=ttyS0")
 		_ = mgr.logger.Log("level", "info", "message", "adding 'console=ttyS0' to kernel args")
 	}
 
-	if mgr.flatcarAutologin {
-		extraFlags += " flatcar.autologin"
+	if _, ok := p.(flatcarProvisioner); ok && mgr.flatcarAutologin {
 		_ = mgr.logger.Log("level", "info", "message", "adding flatcar.autologin to kernel args")
 	}
 
 	if mgr.systemdShell {
-		extraFlags += " rd.shell"
+		kernelArgs = append(kernelArgs, "rd.shell")
 		_ = mgr.logger.Log("level", "info", "message", "adding rd.shell to kernel args")
 	}
 
-	// for ignition we use only 1phase installation without mayu-infopusher
-	kernel := fmt.Sprintf("kernel %s/images/vmlinuz flatcar.first_boot=1 initrd=initrd.cpio.gz flatcar.config.url=%s?uuid=${uuid}&serial=${serial} systemd.journald.max_level_console=debug verbose log_buf_len=10M "+extraFlags+"\n", mgr.pxeURL(), mgr.ignitionURL())
-	initrd := fmt.Sprintf("initrd %s/images/initrd.cpio.gz\n", mgr.pxeURL())
-	// console=ttyS0,115200n8
-	buffer.WriteString("#!ipxe\n")
-	buffer.WriteString("dhcp\n")
-	buffer.WriteString(kernel)
-	buffer.WriteString(initrd)
-	buffer.WriteString("boot\n")
+	w.WriteHeader(200)
+	_, _ = w.Write([]byte(p.bootScript(configURL, kernelArgs)))
+}
 
-	_, _ = w.Write(buffer.Bytes())
+// initNewHost assigns addresses, profile and etcd cluster token to a new
+// host. Profiles are chosen based on the inventory of the host, if any.
+func (mgr *pxeManagerT) initNewHost(host *hostmgr.Host, inventory *hostmgr.Inventory) {
+	if host.InternalAddr == nil {
+		host.InternalAddr = mgr.getNextInternalIP()
+	}
+	// generate addresses for the extra NICs
+	host.AdditionalAddrs = make(map[string]net.IP)
+	for i, nic := range mgr.config.Network.ExtraNICs {
+		host.AdditionalAddrs[nic.InterfaceName] = mgr.getNextAdditionalIP(i)
+	}
+	if host.Profile == "" {
+		host.Profile = mgr.getNextProfile(inventory)
+		if host.Profile == "" {
+			host.Profile = defaultProfileName
+		}
+		host.FlatcarVersion = mgr.config.DefaultFlatcarVersion
+	}
+	if host.EtcdClusterToken == "" {
+		host.EtcdClusterToken = mgr.cluster.Config.DefaultEtcdClusterToken
+	}
+	if host.InternalAddr != nil {
+		host.Hostname = strings.Replace(host.InternalAddr.String(), ".", "-", 4)
+	}
 }
 
-func (mgr *pxeManagerT) maybeCreateHost(serial string) (*hostmgr.Host, error) {
+// maybeCreateHost returns the host given by serial and creates it in case it
+// is not yet known. When hostData is given for a new host, it is stored as the
+// host's inventory before the profile is chosen, so that profiles can match on
+// the reported hardware. The new host is recorded using the given message.
+func (mgr *pxeManagerT) maybeCreateHost(serial string, hostData *machinedata.HostData, msg string) (*hostmgr.Host, error) {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 	host, exists := mgr.cluster.HostWithSerial(serial)
 	if !exists {
 		var err error
-		host, err = mgr.cluster.CreateNewHost(serial)
+		host, err = mgr.createHost(serial, hostData, msg)
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
+	}
+	return host, nil
+}
 
-		if host.InternalAddr == nil {
-			host.InternalAddr = mgr.getNextInternalIP()
-		}
-		// generate addresses for the extra NICs
-		host.AdditionalAddrs = make(map[string]net.IP)
-		for i, nic := range mgr.config.Network.ExtraNICs {
-			host.AdditionalAddrs[nic.InterfaceName] = mgr.getNextAdditionalIP(i)
-		}
-		if host.Profile == "" {
-			host.Profile = mgr.getNextProfile()
-			if host.Profile == "" {
-				host.Profile = defaultProfileName
-			}
-			host.FlatcarVersion = mgr.config.DefaultFlatcarVersion
-		}
-		if host.EtcdClusterToken == "" {
-			host.EtcdClusterToken = mgr.cluster.Config.DefaultEtcdClusterToken
-		}
-		if host.InternalAddr != nil {
-			host.Hostname = strings.Replace(host.InternalAddr.String(), ".", "-", 4)
-		}
+// createHost creates and commits the host given by serial, using the
+// inventory given by hostData, if any. The caller must hold mgr.mu and ensure
+// that the host does not exist yet.
+func (mgr *pxeManagerT) createHost(serial string, hostData *machinedata.HostData, msg string) (*hostmgr.Host, error) {
+	host, err := mgr.cluster.CreateNewHost(serial)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
 
-		err = host.Save()
+	var inventory *hostmgr.Inventory
+	if hostData != nil {
+		inventory, err = host.SetInventory(*hostData)
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
+		applyInventory(host, inventory)
+	}
+
+	mgr.initNewHost(host, inventory)
+
+	err = host.Commit(msg)
+	if err != nil {
+		return nil, microerror.Mask(err)
 	}
 	return host, nil
 }
 
-func (mgr *pxeManagerT) ignitionGenerator(w http.ResponseWriter, r *http.Request) {
+// hostSerial returns the serial identifying the machine requesting r.
+func hostSerial(r *http.Request) string {
 	uuid := r.URL.Query().Get("uuid")
 	serial := r.URL.Query().Get("serial")
 
-	hostData := &machinedata.HostData{}
-
 	// If there is no reliable serial then use uuid for identification of machine.
 	// Case 1: serial from kvm vm is static and not unique so we need to use uuid.
 	// Case 2: serial sent by ipxe from vmware machines is truncated and not unique so we need to use uuid.
 	if serial == "" || serial == kvmStaticSerial || strings.Contains(serial, vmwareIdentifier) {
-		hostData.Serial = uuid
-	} else {
-		hostData.Serial = serial
+		return uuid
+	}
+	return serial
+}
+
+func (mgr *pxeManagerT) ignitionGenerator(w http.ResponseWriter, r *http.Request) {
+	hostData := &machinedata.HostData{
+		Serial: hostSerial(r),
 	}
 
 	if hostData.Serial == "" {
@@ -122,57 +193,117 @@ func (mgr *pxeManagerT) ignitionGenerator(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	host, err := mgr.maybeCreateHost(hostData.Serial)
+	if owner := mgr.clusterFor(hostData.Serial, remoteIP(r)); owner != mgr {
+		owner.ignitionGenerator(w, r)
+		return
+	}
+
+	if mgr.signURLs() {
+		if err := mgr.verifyHostToken(hostData.Serial, remoteIP(r), r.URL.Query().Get("token")); err != nil {
+			_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing ignition config of host %s", hostData.Serial), "stack", err)
+			w.WriteHeader(403)
+			_, _ = w.Write([]byte("invalid token"))
+			return
+		}
+	}
+
+	if mgr.preregisteredOnly && !mgr.isRegistered(hostData.Serial) {
+		_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing ignition config of host %s, it is not pre-registered", hostData.Serial))
+		w.WriteHeader(403)
+		_, _ = w.Write([]byte("host is not pre-registered"))
+		return
+	}
+
+	host, err := mgr.maybeCreateHost(hostData.Serial, nil, commitMessage(r, "ignition", "create host %s", hostData.Serial))
 	if err != nil {
-		_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("failed to create machine host %+v\n", hostData))
+		_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("failed to create machine host %+v\n", hostData), "stack", err)
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("creating host failed"))
+		return
 	}
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("got host %+v\n", host))
+	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("got host %+v\n", maskedHost(*host)))
 
-	host.State = hostmgr.Installing
-	host.Hostname = strings.Replace(host.InternalAddr.String(), ".", "-", 4)
-	_ = host.Save()
+	prepareIgnitionHost(host)
 
-	_ = mgr.cluster.Update()
-
-	buf := &bytes.Buffer{}
 	_ = mgr.logger.Log("level", "info", "message", "generating a ignition config")
-
-	if err := mgr.WriteIgnitionConfig(*host, buf); err != nil {
+	ignitionJSON, report, err := mgr.renderIgnitionConfig(*host, false)
+	if err != nil {
 		w.WriteHeader(500)
 		_, _ = w.Write([]byte("generating ignition config failed: " + err.Error()))
 
 		_ = mgr.logger.Log("level", "error", "message", "generating ignition config failed", "stack", err)
 		return
 	}
-	if _, err := buf.WriteTo(w); err != nil {
+
+	// the report of the last config is kept on the host until it renders
+	// without problems
+	message := "update state of host %s to installing"
+	host.IgnitionReport = nil
+	if !report.Empty() {
+		host.IgnitionReport = &report
+	}
+	if !report.Valid() {
+		message = "update state of host %s to installing, its ignition config is invalid"
+		ignitionValidationFailures.WithLabelValues(mgr.name, host.Profile).Inc()
+		_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("ignition %s config of host %s is invalid:\n%s", report.Version, host.Serial, report.String()))
+	} else if !report.Empty() {
+		_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("ignition %s config of host %s has warnings:\n%s", report.Version, host.Serial, report.String()))
+	}
+
+	if report.Valid() {
+		mgr.recordIgnitionConfig(host)
+	}
+
+	err = host.Commit(commitMessage(r, "ignition", message, host.Serial))
+	if err != nil {
+		_ = mgr.logger.Log("level", "error", "message", "committing updated host state=installing failed", "stack", err)
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("committing updated host state=installing failed"))
+		return
+	}
+
+	if !report.Valid() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		_ = json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	if _, err := fmt.Fprintln(w, string(ignitionJSON)); err != nil {
 		_ = mgr.logger.Log("level", "error", "message", "failed to write response", "stack", err)
 	}
 }
 
+// imagesHandler serves the images of the default provisioner below /images
+// and the ones of the provisioner of a profile below /images/<profile>.
 func (mgr *pxeManagerT) imagesHandler(w http.ResponseWriter, r *http.Request) {
-	var img *os.File
-	var err error
-
-	flatcarVersion := mgr.config.DefaultFlatcarVersion
-	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("sending Container Linux %s image", flatcarVersion))
+	p := r.URL.Path[strings.LastIndex(r.URL.Path, "/images/")+len("/images/"):]
+	profile, name := "", p
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		profile, name = p[:i], p[i+1:]
+	}
 
-	if strings.HasSuffix(r.URL.Path, "/vmlinuz") {
-		img, err = mgr.pxeKernelImage(flatcarVersion)
-	} else if strings.HasSuffix(r.URL.Path, "/initrd.cpio.gz") {
-		img, err = mgr.pxeInitRD(flatcarVersion)
-	} else {
-		panic(fmt.Sprintf("no handler provided for invalid URL path '%s'", r.URL.Path))
+	file := mgr.provisioner(profile).image(name)
+	if file == "" {
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte(fmt.Sprintf("no image %s", p)))
+		return
 	}
+	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("sending image %s", file))
 
+	img, err := os.Open(file)
 	if err != nil {
-		panic(err)
+		_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("failed to open image %s", file), "stack", err)
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte(fmt.Sprintf("no image %s", p)))
+		return
 	}
+	defer img.Close()
 
 	_ = setContentLength(w, img)
-	defer img.Close()
 	_, _ = io.Copy(w, img)
 }
 
@@ -186,13 +317,28 @@ func setContentLength(w http.ResponseWriter, f *os.File) error {
 }
 
 func (mgr *pxeManagerT) hostsList(w http.ResponseWriter, r *http.Request) {
-	hosts := mgr.cluster.GetAllHosts()
+	hosts := []hostmgr.Host{}
+	for _, host := range mgr.cluster.GetAllHosts() {
+		hosts = append(hosts, maskedHost(*host))
+	}
 
 	w.WriteHeader(200)
 	enc := json.NewEncoder(w)
 	_ = enc.Encode(hosts)
 }
 
+// clustersList returns the names of the additional clusters.
+func (mgr *pxeManagerT) clustersList(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for _, child := range mgr.clusters {
+		names = append(names, child.name)
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(names)
+}
+
 func (mgr *pxeManagerT) bootComplete(serial string, w http.ResponseWriter, r *http.Request) {
 	host, exists := mgr.cluster.HostWithSerial(serial)
 	if !exists {
@@ -201,6 +347,17 @@ func (mgr *pxeManagerT) bootComplete(serial string, w http.ResponseWriter, r *ht
 		return
 	}
 
+	// hosts report back using the signed post boot URL of their config, while
+	// calls without a token are admin calls, eg. of mayuctl
+	if token := r.URL.Query().Get("token"); mgr.signURLs() && token != "" {
+		if err := mgr.verifyPostBootToken(serial, token); err != nil {
+			_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing boot_complete of host %s", serial), "stack", err)
+			w.WriteHeader(403)
+			_, _ = w.Write([]byte("invalid token"))
+			return
+		}
+	}
+
 	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("host '%s' just finished booting", serial))
 
 	decoder := json.NewDecoder(r.Body)
@@ -216,13 +373,12 @@ func (mgr *pxeManagerT) bootComplete(serial string, w http.ResponseWriter, r *ht
 	host.LastBoot = time.Now()
 	host.FlatcarVersion = payload.FlatcarVersion
 
-	err = host.Save()
+	err = host.Commit(commitMessage(r, "boot_complete", "update state of host %s to running", serial))
 	if err != nil {
 		w.WriteHeader(500)
 		_, _ = w.Write([]byte("committing updated host state=running failed"))
 		return
 	}
-	_ = mgr.cluster.Update()
 	w.WriteHeader(202)
 }
 
@@ -244,13 +400,12 @@ func (mgr *pxeManagerT) setProviderId(serial string, w http.ResponseWriter, r *h
 	}
 
 	host.ProviderId = payload.ProviderId
-	err = host.Save()
+	err = host.Commit(commitMessage(r, "set_provider_id", "set provider id of host %s", serial))
 	if err != nil {
 		w.WriteHeader(500)
 		_, _ = w.Write([]byte("committing updated host provider id failed"))
 		return
 	}
-	_ = mgr.cluster.Update()
 	w.WriteHeader(202)
 }
 
@@ -272,13 +427,12 @@ func (mgr *pxeManagerT) setIPMIAddr(serial string, w http.ResponseWriter, r *htt
 	}
 
 	host.IPMIAddr = payload.IPMIAddr
-	err = host.Save()
+	err = host.Commit(commitMessage(r, "set_ipmi_addr", "set ipmi address of host %s", serial))
 	if err != nil {
 		w.WriteHeader(500)
 		_, _ = w.Write([]byte("committing updated host ipmi address failed"))
 		return
 	}
-	_ = mgr.cluster.Update()
 	w.WriteHeader(202)
 }
 
@@ -300,25 +454,175 @@ func (mgr *pxeManagerT) setEtcdClusterToken(serial string, w http.ResponseWriter
 	}
 
 	host.EtcdClusterToken = payload.EtcdClusterToken
-	err = host.Save()
+	err = host.Commit(commitMessage(r, "set_etcd_cluster_token", "set etcd cluster token of host %s", serial))
 	if err != nil {
 		w.WriteHeader(500)
 		_, _ = w.Write([]byte("committing updated host etcd cluster token failed"))
 		return
 	}
-	_ = mgr.cluster.Update()
 	w.WriteHeader(202)
 }
 
+func (mgr *pxeManagerT) setInventory(serial string, w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	payload := machinedata.HostData{}
+	err := decoder.Decode(&payload)
+	if err != nil {
+		w.WriteHeader(400)
+		_, _ = w.Write([]byte("unable to parse json data in set_inventory request"))
+		return
+	}
+	payload.Serial = serial
+
+	// the host must not get created concurrently, eg. by its ignition
+	// request, since its inventory would be dropped otherwise
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	host, exists := mgr.cluster.HostWithSerial(serial)
+	if !exists && mgr.preregisteredOnly && !mgr.isRegistered(serial) {
+		w.WriteHeader(403)
+		_, _ = w.Write([]byte("host is not pre-registered"))
+		return
+	}
+	if !exists {
+		// Hosts reporting their inventory before requesting ignition are
+		// registered right away, so that profiles can match on the hardware.
+		_, err = mgr.createHost(serial, &payload, commitMessage(r, "set_inventory", "register host %s with inventory", serial))
+		if err != nil {
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte("registering host with inventory failed"))
+			return
+		}
+		w.WriteHeader(202)
+		return
+	}
+
+	inventory, err := host.SetInventory(payload)
+	if err != nil {
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("committing updated host inventory failed"))
+		return
+	}
+
+	applyInventory(host, inventory)
+	err = host.Commit(commitMessage(r, "set_inventory", "update inventory of host %s", serial))
+	if err != nil {
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("committing updated host inventory failed"))
+		return
+	}
+	w.WriteHeader(202)
+}
+
+func (mgr *pxeManagerT) hostInventory(serial string, w http.ResponseWriter, r *http.Request) {
+	host, exists := mgr.cluster.HostWithSerial(serial)
+	if !exists {
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte("host doesn't exist"))
+		return
+	}
+
+	inventory, err := host.Inventory()
+	if hostmgr.IsNotFound(err) {
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte("host has no inventory"))
+		return
+	} else if err != nil {
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("reading host inventory failed"))
+		return
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(inventory)
+}
+
+// hostTemplatesEnv explains the template variables used to render the
+// templates of the host, including the layer each value is taken from.
+func (mgr *pxeManagerT) hostTemplatesEnv(serial string, w http.ResponseWriter, r *http.Request) {
+	host, exists := mgr.cluster.HostWithSerial(serial)
+	if !exists {
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte("host doesn't exist"))
+		return
+	}
+
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(explainTemplateVars(mgr.templateVarsLayers(*host)))
+}
+
+// hostIgnition renders the Ignition config the host would receive when
+// requesting it now, without changing its state. Only dry runs are supported.
+func (mgr *pxeManagerT) hostIgnition(serial string, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("dry_run") != "true" {
+		w.WriteHeader(400)
+		_, _ = w.Write([]byte("only dry_run=true is supported"))
+		return
+	}
+	if _, exists := mgr.cluster.HostWithSerial(serial); !exists {
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte("host doesn't exist"))
+		return
+	}
+
+	host, config, report, err := mgr.previewIgnitionConfig(serial, query.Get("profile"))
+	if err != nil {
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("generating ignition config failed: " + err.Error()))
+		return
+	}
+	if !report.Valid() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		_ = json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	if query.Get("diff") == "true" {
+		diff, err := ignitionDiff(host, config)
+		if err != nil {
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte("diffing ignition config failed: " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(diff))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = fmt.Fprintln(w, string(config))
+}
+
+// applyInventory copies the host attributes mayu tracks itself from the
+// reported inventory.
+func applyInventory(host *hostmgr.Host, inventory *hostmgr.Inventory) {
+	if macs := inventory.MacAddresses(); len(macs) > 0 {
+		host.MacAddresses = macs
+	}
+	if inventory.HostData.IPMIAddress != nil {
+		host.IPMIAddr = inventory.HostData.IPMIAddress
+	}
+}
+
 func (mgr *pxeManagerT) welcomeHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	_, _ = w.Write([]byte("this is the iPXE server of mayu " + mgr.version))
 }
 
-func (mgr *pxeManagerT) getNextProfile() string {
+func (mgr *pxeManagerT) getNextProfile(inventory *hostmgr.Inventory) string {
 	profileCount := mgr.cluster.GetProfileCount()
 
 	for _, profile := range mgr.config.Profiles {
+		if !profile.Match.Matches(inventory) {
+			continue
+		}
 		if profileCount[profile.Name] < profile.Quantity {
 			return profile.Name
 		}
@@ -327,13 +631,8 @@ func (mgr *pxeManagerT) getNextProfile() string {
 }
 
 func (mgr *pxeManagerT) getNextInternalIP() net.IP {
-	assignedIPs := map[string]struct{}{}
-	for _, host := range mgr.cluster.GetAllHosts() {
-		assignedIPs[host.InternalAddr.String()] = struct{}{}
-	}
-
 	IPisAvailable := func(ip net.IP) bool {
-		_, exists := assignedIPs[ip.String()]
+		_, exists := mgr.cluster.HostWithInternalAddr(ip)
 		return !exists
 	}
 
@@ -350,15 +649,8 @@ func (mgr *pxeManagerT) getNextInternalIP() net.IP {
 
 func (mgr *pxeManagerT) getNextAdditionalIP(nicIndex int) net.IP {
 	nicName := mgr.config.Network.ExtraNICs[nicIndex].InterfaceName
-	assignedIPs := map[string]struct{}{}
-	for _, host := range mgr.cluster.GetAllHosts() {
-		if _, exists := host.AdditionalAddrs[nicName]; exists {
-			assignedIPs[host.AdditionalAddrs[nicName].String()] = struct{}{}
-		}
-	}
-
 	IPisAvailable := func(ip net.IP) bool {
-		_, exists := assignedIPs[ip.String()]
+		_, exists := mgr.cluster.HostWithAdditionalAddr(nicName, ip)
 		return !exists
 	}
 
@@ -374,7 +666,7 @@ func (mgr *pxeManagerT) getNextAdditionalIP(nicIndex int) net.IP {
 }
 
 // check af all hosts have properly assigned IP addresses to all Network.ExtraNICs entries
-func (mgr *pxeManagerT) checkAdditionalNICAddresses() {
+func (mgr *pxeManagerT) checkAdditionalNICAddresses() error {
 	hosts := mgr.cluster.GetAllHosts()
 	// sort the array so we have the host ordered by the internal IP
 	// this will sort in a way how hosts are listed with mayuctl
@@ -407,6 +699,11 @@ func (mgr *pxeManagerT) checkAdditionalNICAddresses() {
 				}
 			}
 		}
-		_ = h.Save()
+		err := h.Commit(fmt.Sprintf("startup: check additional NIC addresses of host %s (by mayu)", h.Serial))
+		if err != nil {
+			return microerror.Mask(err)
+		}
 	}
+
+	return nil
 }
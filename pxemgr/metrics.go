@@ -0,0 +1,16 @@
+package pxemgr
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ignitionValidationFailures counts the Ignition configs not served since
+// they were found invalid against the Ignition spec. The default cluster is
+// labeled by an empty cluster name.
+var ignitionValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mayu",
+	Subsystem: "ignition",
+	Name:      "validation_failures_total",
+	Help:      "Number of rendered Ignition configs found invalid against the Ignition spec.",
+}, []string{"cluster", "profile"})
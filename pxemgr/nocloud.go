@@ -0,0 +1,182 @@
+package pxemgr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// noCloudProvisioner boots a kernel and initrd of any distribution using
+// cloud-init, which fetches its config from the NoCloud datasource served by
+// mayu.
+type noCloudProvisioner struct {
+	config    ProvisionerConfig
+	imagesURL string
+	// seedURL is the URL of the NoCloud datasource of all hosts.
+	seedURL        string
+	imagesCacheDir string
+}
+
+// configURL returns the NoCloud seed URL of the host, below which cloud-init
+// fetches meta-data and user-data.
+func (p noCloudProvisioner) configURL(serial, uuid, token string) string {
+	seedURL := p.seedURL + url.PathEscape(serial) + "/"
+	if token != "" {
+		seedURL += url.PathEscape(token) + "/"
+	}
+	return seedURL
+}
+
+func (p noCloudProvisioner) bootScript(configURL string, kernelArgs []string) string {
+	args := append([]string{"initrd=initrd", "ds=nocloud-net;s=" + configURL}, p.config.KernelArgs...)
+	args = append(args, kernelArgs...)
+
+	kernel := fmt.Sprintf("kernel %s/vmlinuz %s\n", p.imagesURL, strings.Join(args, " "))
+	initrd := fmt.Sprintf("initrd %s/initrd\n", p.imagesURL)
+
+	return "#!ipxe\ndhcp\n" + kernel + initrd + "boot\n"
+}
+
+func (p noCloudProvisioner) image(name string) string {
+	switch name {
+	case "vmlinuz":
+		return path.Join(p.imagesCacheDir, p.config.Kernel)
+	case "initrd":
+		return path.Join(p.imagesCacheDir, p.config.Initrd)
+	}
+	return ""
+}
+
+// renderUserData renders the cloud-init user-data of host. Encrypted template
+// variables are decrypted, or replaced by a placeholder in case maskSecrets is
+// set.
+func (mgr *pxeManagerT) renderUserData(host hostmgr.Host, maskSecrets bool) ([]byte, error) {
+	profile, _ := mgr.config.profile(host.Profile)
+	if profile.Provisioner.Type != provisionerNoCloud {
+		return nil, microerror.Maskf(executionFailedError, "profile %s does not use the %s provisioner", host.Profile, provisionerNoCloud)
+	}
+
+	ctx, _, err := mgr.templateData(host, maskSecrets)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	tmpl, err := getTemplate(profile.Provisioner.UserData, append([]string{mgr.templateSnippets}, profile.TemplateSnippets...))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var data bytes.Buffer
+	if err := tmpl.Execute(&data, ctx); err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return data.Bytes(), nil
+}
+
+// writeUserData renders the user-data of host to wr, with secrets masked.
+func (mgr *pxeManagerT) writeUserData(host hostmgr.Host, wr io.Writer) error {
+	userData, err := mgr.renderUserData(host, true)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if mgr.name == "" {
+		fmt.Fprintf(wr, "user-data of profile %s:\n", host.Profile)
+	} else {
+		fmt.Fprintf(wr, "user-data of profile %s in cluster %s:\n", host.Profile, mgr.name)
+	}
+	fmt.Fprintln(wr, string(userData))
+	return nil
+}
+
+// noCloudHandler serves the NoCloud datasource of a host. cloud-init requests
+// the meta-data first, whose instance-id is the machine ID of the host, so
+// requesting either the meta-data or the user-data creates unknown hosts.
+// Requesting the user-data marks the host as installing.
+func (mgr *pxeManagerT) noCloudHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serial := strings.ToLower(vars["serial"])
+
+	if owner := mgr.clusterFor(serial, remoteIP(r)); owner != mgr {
+		owner.noCloudHandler(w, r)
+		return
+	}
+
+	if mgr.signURLs() {
+		if err := mgr.verifyHostToken(serial, remoteIP(r), vars["token"]); err != nil {
+			_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing nocloud %s of host %s", vars["file"], serial), "stack", err)
+			w.WriteHeader(403)
+			_, _ = w.Write([]byte("invalid token"))
+			return
+		}
+	}
+
+	if mgr.preregisteredOnly && !mgr.isRegistered(serial) {
+		_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("refusing nocloud %s of host %s, it is not pre-registered", vars["file"], serial))
+		w.WriteHeader(403)
+		_, _ = w.Write([]byte("host is not pre-registered"))
+		return
+	}
+
+	switch vars["file"] {
+	case "meta-data", "user-data":
+	case "vendor-data":
+		w.WriteHeader(200)
+		return
+	default:
+		w.WriteHeader(404)
+		return
+	}
+
+	host, err := mgr.maybeCreateHost(serial, nil, commitMessage(r, "nocloud", "create host %s", serial))
+	if err != nil {
+		_ = mgr.logger.Log("level", "error", "message", fmt.Sprintf("failed to create host %s", serial), "stack", err)
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("creating host failed"))
+		return
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if profile, _ := mgr.config.profile(host.Profile); profile.Provisioner.Type != provisionerNoCloud {
+		w.WriteHeader(404)
+		_, _ = w.Write([]byte(fmt.Sprintf("host %s is not installed using %s", serial, provisionerNoCloud)))
+		return
+	}
+
+	if vars["file"] == "meta-data" {
+		w.WriteHeader(200)
+		_, _ = fmt.Fprintf(w, "instance-id: %s\nlocal-hostname: %s\n", host.MachineID, host.Hostname)
+		return
+	}
+
+	prepareIgnitionHost(host)
+
+	userData, err := mgr.renderUserData(*host, false)
+	if err != nil {
+		_ = mgr.logger.Log("level", "error", "message", "generating user-data failed", "stack", err)
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("generating user-data failed: " + err.Error()))
+		return
+	}
+
+	err = host.Commit(commitMessage(r, "nocloud", "update state of host %s to installing", host.Serial))
+	if err != nil {
+		_ = mgr.logger.Log("level", "error", "message", "committing updated host state=installing failed", "stack", err)
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte("committing updated host state=installing failed"))
+		return
+	}
+
+	w.WriteHeader(200)
+	_, _ = w.Write(userData)
+}
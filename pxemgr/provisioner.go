@@ -0,0 +1,65 @@
+package pxemgr
+
+const (
+	provisionerFlatcar = "flatcar"
+	provisionerNoCloud = "nocloud"
+)
+
+// provisioner installs the operating system of the hosts of a profile. It
+// gives the iPXE script booting a host, the images the script loads and the
+// URL the host fetches its config from.
+type provisioner interface {
+	// configURL returns the URL the host given by serial and uuid fetches
+	// its config from. token is empty in case URLs are not signed.
+	configURL(serial, uuid, token string) string
+	// bootScript returns the iPXE script booting a host, which fetches its
+	// config from configURL. kernelArgs are added to the kernel command line.
+	bootScript(configURL string, kernelArgs []string) string
+	// image returns the path of the image served as name below the images
+	// URL of the provisioner. It is empty for unknown images.
+	image(name string) string
+}
+
+// provisioner returns the provisioner of the given profile.
+func (mgr *pxeManagerT) provisioner(profileName string) provisioner {
+	profile, _ := mgr.config.profile(profileName)
+	if profile.Provisioner.Type == provisionerNoCloud {
+		return noCloudProvisioner{
+			config:         profile.Provisioner,
+			imagesURL:      mgr.pxeURL() + "/images/" + profile.Name,
+			seedURL:        mgr.pxeURL() + "/nocloud/",
+			imagesCacheDir: mgr.imagesCacheDir,
+		}
+	}
+
+	return flatcarProvisioner{
+		imagesURL:   mgr.pxeURL() + "/images",
+		ignitionURL: mgr.ignitionURL(),
+		imagesDir:   mgr.imagesCacheDir + "/" + mgr.config.DefaultFlatcarVersion,
+		autologin:   mgr.flatcarAutologin,
+	}
+}
+
+// hasNoCloudProfiles checks whether a profile of the cluster uses the nocloud
+// provisioner.
+func (mgr *pxeManagerT) hasNoCloudProfiles() bool {
+	for _, profile := range mgr.config.Profiles {
+		if profile.Provisioner.Type == provisionerNoCloud {
+			return true
+		}
+	}
+	return false
+}
+
+// bootProfile returns the profile of the host given by serial. Hosts not
+// known yet get the profile they would be created with without inventory.
+func (mgr *pxeManagerT) bootProfile(serial string) string {
+	if host, exists := mgr.cluster.HostWithSerial(serial); exists {
+		return host.Profile
+	}
+
+	if profile := mgr.getNextProfile(nil); profile != "" {
+		return profile
+	}
+	return defaultProfileName
+}
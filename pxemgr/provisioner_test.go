@@ -0,0 +1,136 @@
+package pxemgr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func TestNoCloudProvisioner(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	imagesDir := filepath.Join(h.dir, "images")
+	if err := os.MkdirAll(filepath.Join(imagesDir, "ubuntu"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(h.dir, name), []byte(data), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+	}
+	writeFile("images/ubuntu/vmlinuz", "kernel")
+	writeFile("images/ubuntu/initrd", "initrd")
+	writeFile("user-data.yaml", "#cloud-config\nhostname: {{ .Host.Hostname }}\nruncmd:\n  - curl -X PUT {{ .PostBootURL }}\n")
+
+	config := configOK + `
+profiles:
+  - name: storage
+    quantity: 1
+    provisioner:
+      type: nocloud
+      kernel: ubuntu/vmlinuz
+      initrd: ubuntu/initrd
+      kernel_args: ["ip=dhcp"]
+      user_data: ` + filepath.Join(h.dir, "user-data.yaml") + `
+`
+	writeFile("config_nocloud.yaml", config)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_nocloud.yaml")
+	h.pxeCfg.ImagesCacheDir = imagesDir
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	router := mux.NewRouter()
+	mgr.definePXERoutes(router)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "http://127.0.0.1:4081"+path, nil))
+		return w
+	}
+
+	// machines are asked for their serial first to choose their provisioner
+	if w := get("/ipxebootscript"); !strings.Contains(w.Body.String(), "chain ") {
+		t.Fatalf("expected boot script to chain, got %s", w.Body.String())
+	}
+	w := get("/ipxebootscript?serial=node1")
+	for _, expected := range []string{"/images/storage/vmlinuz ", "ds=nocloud-net;s=http://", "/nocloud/node1/ ip=dhcp", "initrd http://", "/images/storage/initrd\n"} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("expected boot script to contain %q, got %s", expected, w.Body.String())
+		}
+	}
+	if w := get("/images/storage/vmlinuz"); w.Code != http.StatusOK || w.Body.String() != "kernel" {
+		t.Errorf("expected kernel image, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/images/storage/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for unknown image, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// cloud-init requests the meta-data first, which creates the host
+	w = get("/nocloud/node1/meta-data")
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	host, exists := h.cluster.HostWithSerial("node1")
+	if !exists || host.Profile != "storage" {
+		t.Fatalf("expected host to be created with profile storage, got %#v", host)
+	}
+	if !strings.Contains(w.Body.String(), "instance-id: "+host.MachineID+"\n") {
+		t.Errorf("unexpected meta-data %s", w.Body.String())
+	}
+
+	w = get("/nocloud/node1/user-data")
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	host, _ = h.cluster.HostWithSerial("node1")
+	if host.State != hostmgr.Installing {
+		t.Errorf("expected host to be installing, got %v", host.State)
+	}
+	if !strings.Contains(w.Body.String(), "hostname: "+host.Hostname+"\n") || !strings.Contains(w.Body.String(), "/admin/host/node1/boot_complete") {
+		t.Errorf("unexpected user-data %s", w.Body.String())
+	}
+
+	// further machines get the default profile, which installs Flatcar
+	w = get("/ipxebootscript?serial=node2")
+	if !strings.Contains(w.Body.String(), "flatcar.config.url=") || !strings.Contains(w.Body.String(), "/images/vmlinuz ") {
+		t.Errorf("expected Flatcar boot script, got %s", w.Body.String())
+	}
+	if w := get("/nocloud/node2/user-data"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for host installing Flatcar, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestInvalidProvisioner(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	for name, provisioner := range map[string]string{
+		"unknown type":     "{type: other}",
+		"missing template": "{type: nocloud, kernel: vmlinuz, initrd: initrd}",
+	} {
+		config := configOK + "\nprofiles:\n  - name: storage\n    quantity: 1\n    provisioner: " + provisioner + "\n"
+		if err := ioutil.WriteFile(filepath.Join(h.dir, "config_provisioner.yaml"), []byte(config), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+		if _, err := loadConfiguration(filepath.Join(h.dir, "config_provisioner.yaml")); !IsInvalidConfig(err) {
+			t.Errorf("%s: expected invalid config error, got %#v", name, err)
+		}
+	}
+}
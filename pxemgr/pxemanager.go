@@ -1,6 +1,7 @@
 package pxemgr
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,13 +9,17 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"filippo.io/age"
+	"github.com/fsnotify/fsnotify"
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/micrologger"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/giantswarm/mayu/discovery"
 	"github.com/giantswarm/mayu/hostmgr"
 	"github.com/giantswarm/mayu/logging"
 )
@@ -22,10 +27,15 @@ import (
 type PXEManagerConfiguration struct {
 	ConfigFile               string
 	UseInternalEtcdDiscovery bool
+	EtcdDiscoveryBackend     string
 	EtcdQuorumSize           int
 	EtcdDiscoveryUrl         string
 	EtcdEndpoint             string
 	EtcdCAFile               string
+	EtcdCertFile             string
+	EtcdKeyFile              string
+	EtcdUsername             string
+	EtcdPassword             string
 	DNSmasqExecutable        string
 	DNSmasqTemplate          string
 	TFTPRoot                 string
@@ -45,6 +55,25 @@ type PXEManagerConfiguration struct {
 	FlatcarAutologin         bool
 	ConsoleTTY               bool
 	SystemdShell             bool
+	// SecretsKeyFile holds the age identities decrypting the encrypted
+	// values of templates_env and host overrides.
+	SecretsKeyFile string
+	// URLSigningKeyFile holds the key signing the tokens which bind the
+	// Ignition URL of a machine to its serial. Tokens are neither issued nor
+	// required in case it is empty. They expire after URLTokenTTL.
+	URLSigningKeyFile string
+	URLTokenTTL       time.Duration
+	// PreregisteredOnly refuses machines which are neither known nor
+	// pre-registered via serials.
+	PreregisteredOnly bool
+	// ReadOnly creates a manager which only renders configs, eg. for mayu
+	// render. It neither sets up the etcd discovery nor initializes the
+	// clusters, which may commit etcd discovery tokens and assign addresses.
+	ReadOnly bool
+
+	// OpenCluster opens the cluster kept within the given cluster directory.
+	// It is required in case the configuration defines additional clusters.
+	OpenCluster func(dir string) (*hostmgr.Cluster, error)
 
 	Logger micrologger.Logger
 }
@@ -63,21 +92,59 @@ type pxeManagerT struct {
 	imagesCacheDir           string
 	filesDir                 string
 	useInternalEtcdDiscovery bool
+	etcdDiscoveryBackend     string
 	defaultEtcdQuorumSize    int
 	etcdDiscoveryUrl         string
 	etcdEndpoint             string
 	etcdCAFile               string
+	etcdCertFile             string
+	etcdKeyFile              string
+	etcdUsername             string
+	etcdPassword             string
 	version                  string
 	configFile               string
 	flatcarAutologin         bool
 	consoleTTY               bool
 	systemdShell             bool
+	// secretsIdentities decrypt encrypted template variables. Rendering
+	// templates using them fails in case no key file is given.
+	secretsIdentities []age.Identity
+	// urlSigningKey signs the tokens of the Ignition URLs, see
+	// PXEManagerConfiguration.URLSigningKeyFile.
+	urlSigningKey     []byte
+	urlTokenTTL       time.Duration
+	preregisteredOnly bool
+
+	// name and pathPrefix identify additional clusters. Both are empty for
+	// the default cluster.
+	name       string
+	pathPrefix string
+	// serials are pre-registered with the cluster.
+	serials map[string]struct{}
+	// clusters are the additional clusters served next to the default
+	// cluster.
+	clusters []*pxeManagerT
+	// globalTemplatesEnv is the templates_env of the default cluster, which
+	// additional clusters extend. It is nil for the default cluster.
+	globalTemplatesEnv map[string]interface{}
 
 	config  *Configuration
 	cluster *hostmgr.Cluster
 	DNSmasq *DNSmasqInstance
 
+	// discovery keeps the registry of the internal etcd discovery. It is nil
+	// in case the discovery is proxied to the etcd v2 API by etcdProxy.
+	discovery discovery.Registry
+	etcdProxy *etcdProxy
+
 	mu *sync.Mutex
+	// reloadMu serializes reloads of the configuration. configMu is held for
+	// reading while serving requests and for writing while applying a
+	// reloaded configuration. Both are shared with the additional clusters.
+	reloadMu *sync.Mutex
+	configMu *sync.RWMutex
+	// configWatcher reloads the configuration on changes, see WatchConfig.
+	configWatcher *fsnotify.Watcher
 
 	apiRouter *mux.Router
 	pxeRouter *mux.Router
@@ -86,13 +153,31 @@ type pxeManagerT struct {
 }
 
 func PXEManager(c PXEManagerConfiguration, cluster *hostmgr.Cluster) (*pxeManagerT, error) {
-	conf, err := LoadConfig(c.ConfigFile)
+	conf, err := loadConfiguration(c.ConfigFile)
 	if err != nil {
-		return nil, microerror.Maskf(err, "failed to load config from file")
+		return nil, microerror.Mask(err)
+	}
+	if len(conf.Clusters) > 0 && c.OpenCluster == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%s defines additional clusters, but they cannot be opened", c.ConfigFile)
 	}
 
-	if conf.DefaultFlatcarVersion == "" {
-		return nil, microerror.Maskf(invalidConfigError, "No default_flatcar_version specified in %s", c.ConfigFile)
+	var secretsIdentities []age.Identity
+	if c.SecretsKeyFile != "" {
+		secretsIdentities, err = LoadSecretsKey(c.SecretsKeyFile)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var urlSigningKey []byte
+	if c.URLSigningKeyFile != "" {
+		urlSigningKey, err = loadURLSigningKey(c.URLSigningKeyFile)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		if c.URLTokenTTL <= 0 {
+			return nil, microerror.Maskf(invalidConfigError, "the validity of URL tokens must be positive")
+		}
 	}
 
 	if c.APIPort == c.PXEPort {
@@ -103,8 +188,19 @@ func PXEManager(c PXEManagerConfiguration, cluster *hostmgr.Cluster) (*pxeManage
 		return nil, microerror.Maskf(invalidConfigError, "External etcd discovery url is set and internal etcd discovery is activated. Please choose only one.")
 	} else if c.EtcdDiscoveryUrl == "" && !c.UseInternalEtcdDiscovery {
 		return nil, microerror.Maskf(invalidConfigError, "The internal etcd discovery is deactivated and no external discovery url is given.")
-	} else if c.UseInternalEtcdDiscovery && c.EtcdEndpoint == "" {
-		return nil, microerror.Maskf(invalidConfigError, "The internal etcd discovery is activated but no etcd endpoint is given.")
+	}
+
+	if c.EtcdDiscoveryBackend == "" {
+		c.EtcdDiscoveryBackend = EtcdDiscoveryBackendEmbedded
+	}
+	switch c.EtcdDiscoveryBackend {
+	case EtcdDiscoveryBackendEmbedded:
+	case EtcdDiscoveryBackendEtcd, EtcdDiscoveryBackendEtcdV2:
+		if c.UseInternalEtcdDiscovery && c.EtcdEndpoint == "" {
+			return nil, microerror.Maskf(invalidConfigError, "The internal etcd discovery is activated but no etcd endpoint is given.")
+		}
+	default:
+		return nil, microerror.Maskf(invalidConfigError, "Unknown etcd discovery backend '%s', use '%s', '%s' or '%s'.", c.EtcdDiscoveryBackend, EtcdDiscoveryBackendEmbedded, EtcdDiscoveryBackendEtcd, EtcdDiscoveryBackendEtcdV2)
 	}
 
 	c.EtcdDiscoveryUrl = strings.TrimRight(c.EtcdDiscoveryUrl, "/")
@@ -123,15 +219,25 @@ func PXEManager(c PXEManagerConfiguration, cluster *hostmgr.Cluster) (*pxeManage
 		imagesCacheDir:           c.ImagesCacheDir,
 		filesDir:                 c.FilesDir,
 		useInternalEtcdDiscovery: c.UseInternalEtcdDiscovery,
+		etcdDiscoveryBackend:     c.EtcdDiscoveryBackend,
 		defaultEtcdQuorumSize:    c.EtcdQuorumSize,
 		etcdDiscoveryUrl:         c.EtcdDiscoveryUrl,
 		etcdEndpoint:             c.EtcdEndpoint,
 		etcdCAFile:               c.EtcdCAFile,
+		etcdCertFile:             c.EtcdCertFile,
+		etcdKeyFile:              c.EtcdKeyFile,
+		etcdUsername:             c.EtcdUsername,
+		etcdPassword:             c.EtcdPassword,
 		configFile:               c.ConfigFile,
 		version:                  c.Version,
 		flatcarAutologin:         c.FlatcarAutologin,
 		consoleTTY:               c.ConsoleTTY,
 		systemdShell:             c.SystemdShell,
+		secretsIdentities:        secretsIdentities,
+		urlSigningKey:            urlSigningKey,
+		urlTokenTTL:              c.URLTokenTTL,
+		preregisteredOnly:        c.PreregisteredOnly,
+		serials:                  serialSet(conf.Serials),
 
 		config:  &conf,
 		cluster: cluster,
@@ -143,12 +249,130 @@ func PXEManager(c PXEManagerConfiguration, cluster *hostmgr.Cluster) (*pxeManage
 
 			Logger: c.Logger,
 		}),
-		mu: new(sync.Mutex),
+		mu:       new(sync.Mutex),
+		reloadMu: new(sync.Mutex),
+		configMu: new(sync.RWMutex),
 
 		logger: c.Logger,
 	}
 
-	// check for deprecated EtcdDiscoveryUrl
+	if mgr.useInternalEtcdDiscovery && !c.ReadOnly {
+		switch mgr.etcdDiscoveryBackend {
+		case EtcdDiscoveryBackendEmbedded:
+			mgr.discovery, err = discovery.NewStorageRegistry(discovery.StorageConfig{
+				Storage: cluster.Storage(),
+
+				Logger: c.Logger,
+			})
+		case EtcdDiscoveryBackendEtcd:
+			mgr.discovery, err = discovery.NewEtcdRegistry(discovery.EtcdConfig{
+				Endpoints: []string{mgr.etcdEndpoint},
+				CAFile:    mgr.etcdCAFile,
+				CertFile:  mgr.etcdCertFile,
+				KeyFile:   mgr.etcdKeyFile,
+				Username:  mgr.etcdUsername,
+				Password:  mgr.etcdPassword,
+
+				Logger: c.Logger,
+			})
+		case EtcdDiscoveryBackendEtcdV2:
+			mgr.etcdProxy, err = newEtcdProxy(etcdProxyConfig{
+				Connection: mgr.etcdConnection(),
+
+				Logger: c.Logger,
+			})
+		}
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	if !c.ReadOnly {
+		err = mgr.initCluster()
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	if mgr.useInternalEtcdDiscovery {
+		mgr.etcdDiscoveryUrl = mgr.config.TemplatesEnv["mayu_https_endpoint"].(string) + "/etcd"
+	}
+
+	for _, def := range conf.Clusters {
+		cluster, err := c.OpenCluster(def.ClusterDirectory)
+		if err != nil {
+			return nil, microerror.Maskf(executionFailedError, "opening cluster '%s': %s", def.Name, err)
+		}
+
+		child := mgr.newChild(def, cluster)
+		if !c.ReadOnly {
+			err = child.initCluster()
+			if err != nil {
+				return nil, microerror.Maskf(executionFailedError, "initializing cluster '%s': %s", def.Name, err)
+			}
+		}
+		mgr.clusters = append(mgr.clusters, child)
+
+		_ = c.Logger.Log("level", "info", "message", fmt.Sprintf("serving cluster '%s' from %s", def.Name, def.ClusterDirectory))
+	}
+
+	return mgr, nil
+}
+
+// serialSet returns the lower case serials.
+func serialSet(serials []string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, serial := range serials {
+		set[strings.ToLower(serial)] = struct{}{}
+	}
+	return set
+}
+
+// newChild returns the manager of the additional cluster given by def. It
+// shares the settings of mgr, but uses its own configuration and state.
+func (mgr *pxeManagerT) newChild(def ClusterDefinition, cluster *hostmgr.Cluster) *pxeManagerT {
+	conf := def.Configuration
+	if conf.DefaultFlatcarVersion == "" {
+		conf.DefaultFlatcarVersion = mgr.config.DefaultFlatcarVersion
+	}
+	if conf.DefaultIgnitionVersion == "" {
+		conf.DefaultIgnitionVersion = mgr.config.DefaultIgnitionVersion
+	}
+	if conf.Network.BindAddr == "" {
+		conf.Network.BindAddr = mgr.config.Network.BindAddr
+	}
+
+	child := *mgr
+	child.name = def.Name
+	child.pathPrefix = "/clusters/" + def.Name
+	child.serials = serialSet(def.Serials)
+	child.clusters = nil
+	child.config = &conf
+	child.globalTemplatesEnv = mgr.config.TemplatesEnv
+	child.cluster = cluster
+	child.DNSmasq = nil
+	child.mu = new(sync.Mutex)
+	child.apiRouter = nil
+	child.pxeRouter = nil
+
+	if def.IgnitionConfig != "" {
+		child.ignitionConfig = def.IgnitionConfig
+	}
+	if def.TemplateSnippets != "" {
+		child.templateSnippets = def.TemplateSnippets
+	}
+	if def.FilesDir != "" {
+		child.filesDir = def.FilesDir
+	}
+
+	return &child
+}
+
+// initCluster prepares the cluster state of mgr on startup.
+func (mgr *pxeManagerT) initCluster() error {
+	// check for deprecated EtcdDiscoveryUrl. This is not a schema migration
+	// of hostmgr, since converting the url depends on the etcd discovery in
+	// use.
 	if mgr.cluster.Config.EtcdDiscoveryURL != "" && mgr.cluster.Config.DefaultEtcdClusterToken == "" {
 		// transform discovery url to token
 		parts := strings.Split(mgr.cluster.Config.EtcdDiscoveryURL, "/")
@@ -157,17 +381,20 @@ func PXEManager(c PXEManagerConfiguration, cluster *hostmgr.Cluster) (*pxeManage
 
 		if mgr.useInternalEtcdDiscovery {
 			// convert token to internal etcd discovery
-			err := mgr.cluster.StoreEtcdDiscoveryToken(mgr.etcdEndpoint, mgr.etcdCAFile, token, mgr.defaultEtcdQuorumSize)
+			err := mgr.storeEtcdDiscoveryToken(context.Background(), token, mgr.defaultEtcdQuorumSize)
 			if err != nil {
-				return nil, microerror.Maskf(executionFailedError, fmt.Sprintf("Can't store discovery token in etcd. %s %s", baseUrl, mgr.etcdDiscoveryUrl))
+				return microerror.Maskf(executionFailedError, fmt.Sprintf("Can't store discovery token in etcd. %s %s", baseUrl, mgr.etcdDiscoveryUrl))
 			}
-			_ = c.Logger.Log("level", "warning", "message", "Transferred etcd token to internal discovery. Note that your machines still have the old discovery url in their cloud-config and that you need to transfer the current member data yourself.")
+			_ = mgr.logger.Log("level", "warning", "message", "Transferred etcd token to internal discovery. Note that your machines still have the old discovery url in their cloud-config and that you need to transfer the current member data yourself.")
 		} else if mgr.etcdDiscoveryUrl != baseUrl {
-			return nil, microerror.Maskf(invalidConfigError, fmt.Sprintf("Deprecated EtcdDiscoveryURL ('%s') in your cluster.json differs from the --etcd-discovery parameter ('%s').", baseUrl, mgr.etcdDiscoveryUrl))
+			return microerror.Maskf(invalidConfigError, fmt.Sprintf("Deprecated EtcdDiscoveryURL ('%s') in your cluster.json differs from the --etcd-discovery parameter ('%s').", baseUrl, mgr.etcdDiscoveryUrl))
 		}
 		mgr.cluster.Config.EtcdDiscoveryURL = ""
 		mgr.cluster.Config.DefaultEtcdClusterToken = token
-		_ = mgr.cluster.Commit(fmt.Sprintf("Convert deprecated etcd discovery url to default etcd token '%s'", token))
+		err := mgr.cluster.Commit(fmt.Sprintf("startup: convert deprecated etcd discovery url to default etcd token '%s' (by mayu)", token))
+		if err != nil {
+			return microerror.Mask(err)
+		}
 	}
 
 	if mgr.cluster.Config.DefaultEtcdClusterToken == "" {
@@ -178,50 +405,93 @@ func PXEManager(c PXEManagerConfiguration, cluster *hostmgr.Cluster) (*pxeManage
 		if mgr.useInternalEtcdDiscovery {
 			token, err = mgr.cluster.GenerateEtcdDiscoveryToken()
 			if err != nil {
-				return nil, microerror.Maskf(err, "Failed to generate etcd cluster token")
+				return microerror.Maskf(err, "Failed to generate etcd cluster token")
 			}
-			err := mgr.cluster.StoreEtcdDiscoveryToken(mgr.etcdEndpoint, mgr.etcdCAFile, token, mgr.defaultEtcdQuorumSize)
+			err := mgr.storeEtcdDiscoveryToken(context.Background(), token, mgr.defaultEtcdQuorumSize)
 			if err != nil {
-				return nil, microerror.Maskf(err, "Failed to store etcd cluster token in etcd")
+				return microerror.Maskf(err, "Failed to store etcd cluster token in etcd")
 			}
 		} else {
 			token, err = mgr.cluster.FetchEtcdDiscoveryToken(mgr.etcdDiscoveryUrl, mgr.defaultEtcdQuorumSize)
 			if err != nil {
-				return nil, microerror.Maskf(err, "Failed to fetch etcd cluster token from external registry")
+				return microerror.Maskf(err, "Failed to fetch etcd cluster token from external registry")
 			}
 		}
 		mgr.cluster.Config.DefaultEtcdClusterToken = token
-		_ = mgr.cluster.Commit(fmt.Sprintf("Set default etcd cluster to '%s'", token))
+		err = mgr.cluster.Commit(fmt.Sprintf("startup: set default etcd cluster to '%s' (by mayu)", token))
+		if err != nil {
+			return microerror.Mask(err)
+		}
 	}
 
-	if mgr.useInternalEtcdDiscovery {
-		mgr.etcdDiscoveryUrl = mgr.config.TemplatesEnv["mayu_https_endpoint"].(string) + "/etcd"
+	// we need to do this on boot time to ensure all newly added Network.ExtraNICs have properly assigned IP to all hosts
+	err := mgr.checkAdditionalNICAddresses()
+	if err != nil {
+		return microerror.Mask(err)
 	}
 
-	// we need to do this on boot time to ensure all newly added Network.ExtraNICs have properly assigned IP to all hosts
-	mgr.checkAdditionalNICAddresses()
+	return nil
+}
 
-	return mgr, nil
+// clusterFor returns the manager of the cluster the host given by serial
+// belongs to. Known hosts stay with the cluster keeping their state. New
+// hosts go to the cluster they are pre-registered with, or to the one whose
+// PXE or primary NIC address range contains remoteIP. All other hosts belong
+// to the default cluster.
+func (mgr *pxeManagerT) clusterFor(serial string, remoteIP net.IP) *pxeManagerT {
+	if len(mgr.clusters) == 0 {
+		return mgr
+	}
+	serial = strings.ToLower(serial)
+
+	if _, exists := mgr.cluster.HostWithSerial(serial); exists {
+		return mgr
+	}
+	for _, child := range mgr.clusters {
+		if _, exists := child.cluster.HostWithSerial(serial); exists {
+			return child
+		}
+	}
+	for _, child := range mgr.clusters {
+		if _, exists := child.serials[serial]; exists {
+			return child
+		}
+	}
+	if remoteIP != nil {
+		for _, child := range mgr.clusters {
+			if child.config.Network.contains(remoteIP) {
+				return child
+			}
+		}
+	}
+
+	return mgr
 }
 
-func withSerialParam(serialHandler func(serial string, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+// withHost passes the serial of the request path to the handler of the
+// cluster the host belongs to.
+func (mgr *pxeManagerT) withHost(handler func(mgr *pxeManagerT, serial string, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		serialHandler(params["serial"], w, r)
+		serial := mux.Vars(r)["serial"]
+		handler(mgr.clusterFor(serial, remoteIP(r)), serial, w, r)
+	}
+}
+
+// remoteIP returns the address of the client of r.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return net.ParseIP(r.RemoteAddr)
 	}
+	return net.ParseIP(host)
 }
 
 func (mgr *pxeManagerT) startIPXEserver() error {
 	mgr.pxeRouter = mux.NewRouter()
-
-	// ipxe script
-	mgr.pxeRouter.Methods("GET").PathPrefix("/ipxebootscript").HandlerFunc(mgr.ipxeBootScript)
-
-	// get ignition
-	mgr.pxeRouter.Methods("GET").PathPrefix("/ignition").HandlerFunc(mgr.ignitionGenerator)
-
-	// endpoint for fetching flatcar images defined by machine serial number
-	mgr.pxeRouter.Methods("GET").PathPrefix("/images/{serial}").HandlerFunc(mgr.imagesHandler)
+	mgr.definePXERoutes(mgr.pxeRouter)
+	for _, child := range mgr.clusters {
+		child.definePXERoutes(mgr.pxeRouter.PathPrefix(child.pathPrefix).Subrouter())
+	}
 
 	// serve static files like
 	mgr.pxeRouter.PathPrefix("/").Handler(http.FileServer(http.Dir(mgr.staticHTMLPath)))
@@ -230,7 +500,7 @@ func (mgr *pxeManagerT) startIPXEserver() error {
 	mgr.pxeRouter.Path("/").HandlerFunc(mgr.welcomeHandler)
 
 	logWrapper := logging.NewMicrologgerWrapper(mgr.logger)
-	loggedRouter := handlers.LoggingHandler(logWrapper, mgr.pxeRouter)
+	loggedRouter := handlers.LoggingHandler(logWrapper, mgr.withConfig(mgr.pxeRouter))
 
 	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("starting iPXE server at %s:%d", mgr.bindAddress, mgr.pxePort))
 
@@ -242,22 +512,36 @@ func (mgr *pxeManagerT) startIPXEserver() error {
 	return nil
 }
 
+func (mgr *pxeManagerT) definePXERoutes(router *mux.Router) {
+	// ipxe script
+	router.Methods("GET").PathPrefix("/ipxebootscript").HandlerFunc(mgr.ipxeBootScript)
+
+	// get ignition
+	router.Methods("GET").PathPrefix("/ignition").HandlerFunc(mgr.ignitionGenerator)
+
+	// endpoint for fetching the images of the provisioner of a profile
+	router.Methods("GET").PathPrefix("/images/").HandlerFunc(mgr.imagesHandler)
+
+	// NoCloud datasource of hosts installed using cloud-init
+	router.Methods("GET").Path("/nocloud/{serial}/{file}").HandlerFunc(mgr.noCloudHandler)
+	router.Methods("GET").Path("/nocloud/{serial}/{token}/{file}").HandlerFunc(mgr.noCloudHandler)
+}
+
 func (mgr *pxeManagerT) startAPIserver() error {
 	mgr.apiRouter = mux.NewRouter()
-	//  api endpoint for setting metadata of machine
-	mgr.apiRouter.Methods("PUT").PathPrefix("/admin/host/{serial}/boot_complete").HandlerFunc(withSerialParam(mgr.bootComplete))
-	mgr.apiRouter.Methods("PUT").PathPrefix("/admin/host/{serial}/set_provider_id").HandlerFunc(withSerialParam(mgr.setProviderId))
-	mgr.apiRouter.Methods("PUT").PathPrefix("/admin/host/{serial}/set_ipmi_addr").HandlerFunc(withSerialParam(mgr.setIPMIAddr))
-	mgr.apiRouter.Methods("PUT").PathPrefix("/admin/host/{serial}/set_etcd_cluster_token").HandlerFunc(withSerialParam(mgr.setEtcdClusterToken))
+	mgr.defineAdminRoutes(mgr.apiRouter)
+	for _, child := range mgr.clusters {
+		child.defineAdminRoutes(mgr.apiRouter.PathPrefix(child.pathPrefix).Subrouter())
+	}
+	mgr.apiRouter.Methods("GET").PathPrefix("/admin/clusters").HandlerFunc(mgr.clustersList)
 
-	// list all machines/hosts method
-	mgr.apiRouter.Methods("GET").PathPrefix("/admin/hosts").HandlerFunc(mgr.hostsList)
 	// etcd discovery
 	if mgr.useInternalEtcdDiscovery {
 		etcdRouter := mgr.apiRouter.PathPrefix("/etcd").Subrouter()
 		mgr.defineEtcdDiscoveryRoutes(etcdRouter)
+		mgr.defineEtcdTokenRoutes(mgr.apiRouter)
 
-		_ = mgr.logger.Log("level", "info", "message", "Enabling internal etcd discovery")
+		_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("Enabling internal etcd discovery using the '%s' backend", mgr.etcdDiscoveryBackend))
 	}
 
 	// serve static file assets
@@ -270,7 +554,7 @@ func (mgr *pxeManagerT) startAPIserver() error {
 	mgr.apiRouter.Path("/metrics").Handler(promhttp.Handler())
 
 	logWrapper := logging.NewMicrologgerWrapper(mgr.logger)
-	loggedRouter := handlers.LoggingHandler(logWrapper, mgr.apiRouter)
+	loggedRouter := handlers.LoggingHandler(logWrapper, mgr.withConfig(mgr.apiRouter))
 
 	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("starting API server at at %s:%d", mgr.bindAddress, mgr.apiPort))
 
@@ -290,18 +574,51 @@ func (mgr *pxeManagerT) startAPIserver() error {
 	return nil
 }
 
+func (mgr *pxeManagerT) defineAdminRoutes(router *mux.Router) {
+	//  api endpoint for setting metadata of machine
+	router.Methods("PUT").PathPrefix("/admin/host/{serial}/boot_complete").HandlerFunc(mgr.withHost((*pxeManagerT).bootComplete))
+	router.Methods("PUT").PathPrefix("/admin/host/{serial}/set_provider_id").HandlerFunc(mgr.withHost((*pxeManagerT).setProviderId))
+	router.Methods("PUT").PathPrefix("/admin/host/{serial}/set_ipmi_addr").HandlerFunc(mgr.withHost((*pxeManagerT).setIPMIAddr))
+	router.Methods("PUT").PathPrefix("/admin/host/{serial}/set_etcd_cluster_token").HandlerFunc(mgr.withHost((*pxeManagerT).setEtcdClusterToken))
+	router.Methods("PUT").PathPrefix("/admin/host/{serial}/set_inventory").HandlerFunc(mgr.withHost((*pxeManagerT).setInventory))
+	router.Methods("GET").PathPrefix("/admin/host/{serial}/inventory").HandlerFunc(mgr.withHost((*pxeManagerT).hostInventory))
+	router.Methods("GET").PathPrefix("/admin/host/{serial}/ignition").HandlerFunc(mgr.withHost((*pxeManagerT).hostIgnition))
+	router.Methods("GET").PathPrefix("/admin/host/{serial}/templates_env").HandlerFunc(mgr.withHost((*pxeManagerT).hostTemplatesEnv))
+	router.Methods("GET").PathPrefix("/admin/host/{serial}/history").HandlerFunc(mgr.withHost((*pxeManagerT).hostHistory))
+	router.Methods("GET").PathPrefix("/admin/host/{serial}/diff").HandlerFunc(mgr.withHost((*pxeManagerT).hostDiff))
+	router.Methods("PUT").PathPrefix("/admin/host/{serial}/rollback").HandlerFunc(mgr.withHost((*pxeManagerT).hostRollback))
+
+	// backup and restore of the whole cluster state
+	router.Methods("GET").PathPrefix("/admin/cluster/export").HandlerFunc(mgr.clusterExport)
+	router.Methods("PUT").PathPrefix("/admin/cluster/import").HandlerFunc(mgr.clusterImport)
+
+	// list all machines/hosts method
+	router.Methods("GET").PathPrefix("/admin/hosts").HandlerFunc(mgr.hostsList)
+}
+
 func (mgr *pxeManagerT) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mgr.apiRouter.ServeHTTP(w, r)
 }
 
+// dnsmasqNetworks returns the network of the default cluster and the ones of
+// the additional clusters served by dnsmasq.
+func (mgr *pxeManagerT) dnsmasqNetworks() (Network, []Network) {
+	mgr.config.Network.StaticHosts = []hostmgr.IPMac{}
+	mgr.config.Network.IgnoredHosts = []string{}
+
+	clusterNetworks := []Network{}
+	for _, child := range mgr.clusters {
+		clusterNetworks = append(clusterNetworks, child.config.Network)
+	}
+
+	return mgr.config.Network, clusterNetworks
+}
+
 func (mgr *pxeManagerT) updateDNSmasqs() error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	mgr.config.Network.StaticHosts = []hostmgr.IPMac{}
-	mgr.config.Network.IgnoredHosts = []string{}
-
-	err := mgr.DNSmasq.updateConf(mgr.config.Network)
+	_, err := mgr.DNSmasq.updateConf(mgr.dnsmasqNetworks())
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -351,15 +668,36 @@ func (mgr *pxeManagerT) apiURL() string {
 }
 
 func (mgr *pxeManagerT) pxeURL() string {
-	u := url.URL{Scheme: "http", Host: net.JoinHostPort(mgr.config.Network.BindAddr, strconv.Itoa(mgr.pxePort))}
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(mgr.config.Network.BindAddr, strconv.Itoa(mgr.pxePort)), Path: mgr.pathPrefix}
 	return u.String()
 }
 
 func (mgr *pxeManagerT) ignitionURL() string {
-	u := url.URL{Scheme: "http", Host: net.JoinHostPort(mgr.config.Network.BindAddr, strconv.Itoa(mgr.pxePort)), Path: "/ignition"}
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(mgr.config.Network.BindAddr, strconv.Itoa(mgr.pxePort)), Path: mgr.pathPrefix + "/ignition"}
 	return u.String()
 }
 
+// actor identifies the client of the API request given by r within the
+// cluster history. Clients can name themselves using the X-Mayu-Actor header,
+// otherwise their remote address is used.
+func actor(r *http.Request) string {
+	if a := r.Header.Get("X-Mayu-Actor"); a != "" {
+		return a
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// commitMessage builds the message recording a change made by the API call
+// given by call on behalf of the client of r.
+func commitMessage(r *http.Request, call, format string, args ...interface{}) string {
+	return fmt.Sprintf("%s: %s (by %s)", call, fmt.Sprintf(format, args...), actor(r))
+}
+
 func (mgr *pxeManagerT) httpError(w http.ResponseWriter, msg string, status int) {
 	_ = mgr.logger.Log("level", "warning", "message", msg)
 	http.Error(w, msg, status)
@@ -5,14 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/giantswarm/micrologger"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/giantswarm/mayu-infopusher/machinedata"
 
@@ -112,7 +116,9 @@ func setUp(t *testing.T) *helper {
 
 	h.pxeCfg = PXEManagerConfiguration{
 		UseInternalEtcdDiscovery: true,
-		NoTLS:                    true,
+		// The fake etcd only answers the v2 keys API.
+		EtcdDiscoveryBackend: EtcdDiscoveryBackendEtcdV2,
+		NoTLS:                true,
 		// This port is declared only to allow PXEMAnager instantiation (APIPort and
 		// PXEPort must be different), the server is not going to be started and we
 		// are going to test the handler method directly
@@ -176,4 +182,313 @@ func TestFinalCloudConfigChecksErrorOk(t *testing.T) {
 	if !strings.Contains(actual, "update-engine.service") {
 		t.Errorf("response body contains incomplete template: %s", actual)
 	}
+
+	// --show-templates renders the default profile
+	b := new(bytes.Buffer)
+	if err := mgr.WriteIgnitionConfigs(b); err != nil {
+		t.Fatalf("rendering ignition configs: %s", err)
+	}
+	if b.String() != "ignition config of profile default:\n"+expected {
+		t.Errorf("unexpected ignition configs: %s", b.String())
+	}
+}
+
+func TestFinalCloudConfigChecksErrorErr(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_err.yaml")
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	// the template renders units below systemd instead of systemd.units
+	mgr.ignitionGenerator(h.w, h.req)
+
+	if status := h.w.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+
+	var report hostmgr.IgnitionReport
+	if err := json.NewDecoder(h.w.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding report: %s", err)
+	}
+	if report.Valid() || report.Version != "2.2.0" {
+		t.Errorf("unexpected report %#v", report)
+	}
+
+	host, exists := h.cluster.HostWithSerial("test1234")
+	if !exists {
+		t.Fatalf("expected host to be registered")
+	}
+	if host.IgnitionReport == nil || host.IgnitionReport.Valid() {
+		t.Errorf("expected invalid report to be recorded on host, got %#v", host.IgnitionReport)
+	}
+	if failures := testutil.ToFloat64(ignitionValidationFailures.WithLabelValues("", host.Profile)); failures != 1 {
+		t.Errorf("expected validation failure to be counted once, got %v", failures)
+	}
+}
+
+func TestSetInventoryMatchesProfile(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	config := configOK + `
+profiles:
+  - name: storage
+    quantity: 1
+    match:
+      mac_prefixes: ["0c:c4:7a"]
+  - name: core
+    quantity: 2
+`
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_profiles.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_profiles.yaml")
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	cases := []struct {
+		serial          string
+		mac             string
+		expectedProfile string
+	}{
+		{"plain", "52:54:00:12:34:56", "core"},
+		{"supermicro", "0C:C4:7A:00:00:01", "storage"},
+	}
+
+	for _, c := range cases {
+		hostData := machinedata.HostData{
+			NetDevs: []machinedata.NetDev{{Name: "eth0", MacAddress: c.mac}},
+		}
+		b := new(bytes.Buffer)
+		_ = json.NewEncoder(b).Encode(hostData)
+
+		w := httptest.NewRecorder()
+		mgr.setInventory(c.serial, w, httptest.NewRequest("PUT", "http://127.0.0.1:4080/admin/host/"+c.serial+"/set_inventory", b))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusAccepted)
+		}
+
+		host, exists := h.cluster.HostWithSerial(c.serial)
+		if !exists {
+			t.Fatalf("expected host '%s' to be registered", c.serial)
+		}
+		if host.Profile != c.expectedProfile {
+			t.Errorf("expected host '%s' to get profile '%s', got '%s'", c.serial, c.expectedProfile, host.Profile)
+		}
+
+		inventory, err := host.Inventory()
+		if err != nil {
+			t.Fatalf("reading inventory of host '%s': %s", c.serial, err)
+		}
+		if inventory.Revision != 1 || inventory.HostData.Serial != c.serial {
+			t.Errorf("unexpected inventory for host '%s': %#v", c.serial, inventory)
+		}
+	}
+
+	// the inventory is kept when the host gets created concurrently
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = mgr.maybeCreateHost("concurrent", nil, "create host concurrent")
+	}()
+	go func() {
+		defer wg.Done()
+		w := httptest.NewRecorder()
+		mgr.setInventory("concurrent", w, httptest.NewRequest("PUT", "http://127.0.0.1:4080/admin/host/concurrent/set_inventory", strings.NewReader(`{}`)))
+		if w.Code != http.StatusAccepted {
+			t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusAccepted)
+		}
+	}()
+	wg.Wait()
+
+	host, _ := h.cluster.HostWithSerial("concurrent")
+	if _, err := host.Inventory(); err != nil {
+		t.Fatalf("expected inventory of concurrently created host: %s", err)
+	}
+}
+
+func TestCheckAdditionalNICAddresses(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	config := strings.Replace(configOK, "  primary_nic:\n", `  extra_nics:
+  - interface_name: em2
+    ip_range:
+      start: 10.1.0.10
+      end: 10.1.0.20
+  primary_nic:
+`, 1)
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_nics.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+
+	for i, addr := range []string{"10.1.0.10", "", "192.168.0.1", ""} {
+		host, err := h.cluster.CreateNewHost(fmt.Sprintf("serial-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		host.InternalAddr = net.ParseIP(fmt.Sprintf("1.1.1.%d", i+1))
+		if addr != "" {
+			host.AdditionalAddrs = map[string]net.IP{"em2": net.ParseIP(addr)}
+		}
+		if err := host.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_nics.yaml")
+	if _, err := PXEManager(h.pxeCfg, h.cluster); err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	expected := map[string]string{
+		"serial-0": "10.1.0.10",
+		"serial-1": "10.1.0.11",
+		"serial-2": "10.1.0.12",
+		"serial-3": "10.1.0.13",
+	}
+	for serial, addr := range expected {
+		host, _ := h.cluster.HostWithSerial(serial)
+		if got := host.AdditionalAddrs["em2"].String(); got != addr {
+			t.Errorf("expected address %s of host %s, got %s", addr, serial, got)
+		}
+	}
+}
+
+func TestClustersRouteHosts(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	clustersDir, err := ioutil.TempDir("", "pxmgr_clusters_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clustersDir)
+
+	config := configOK + `
+clusters:
+  - name: lab
+    cluster_directory: lab
+    serials: ["PreReg"]
+    network:
+      pxe:
+        pxe_interface:
+          ip_range:
+            start: 10.1.0.10
+            end: 10.1.0.20
+      primary_nic:
+        ip_range:
+          start: 10.1.1.1
+          end: 10.1.1.10
+`
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_clusters.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_clusters.yaml")
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+
+	var lab *hostmgr.Cluster
+	h.pxeCfg.OpenCluster = func(dir string) (*hostmgr.Cluster, error) {
+		lab, err = hostmgr.NewCluster(filepath.Join(clustersDir, dir), logger)
+		return lab, err
+	}
+
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	cases := []struct {
+		serial       string
+		remoteAddr   string
+		expectedAddr string
+		expectLab    bool
+	}{
+		{"prereg", "192.0.2.1:1234", "10.1.1.1", true},
+		{"bysubnet", "10.1.0.15:1234", "10.1.1.2", true},
+		{"other", "192.0.2.1:1234", "1.1.1.1", false},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "http://127.0.0.1:4080/ignition?serial="+c.serial, nil)
+		req.RemoteAddr = c.remoteAddr
+		w := httptest.NewRecorder()
+		mgr.ignitionGenerator(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code for host '%s': got %v want %v", c.serial, w.Code, http.StatusOK)
+		}
+
+		cluster, other := h.cluster, lab
+		if c.expectLab {
+			cluster, other = lab, h.cluster
+		}
+		host, exists := cluster.HostWithSerial(c.serial)
+		if !exists {
+			t.Fatalf("expected host '%s' to be created in its cluster", c.serial)
+		}
+		if host.InternalAddr.String() != c.expectedAddr {
+			t.Errorf("expected host '%s' to get address %s, got %s", c.serial, c.expectedAddr, host.InternalAddr)
+		}
+		if _, exists := other.HostWithSerial(c.serial); exists {
+			t.Errorf("expected host '%s' not to be created in the other cluster", c.serial)
+		}
+	}
+
+	// machines are asked for their serial first, then get the boot script of
+	// their cluster
+	w := httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript", nil))
+	if !strings.Contains(w.Body.String(), "chain ") {
+		t.Errorf("expected boot script to chain, got %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript?serial=prereg", nil))
+	if !strings.Contains(w.Body.String(), "/clusters/lab/ignition?") {
+		t.Errorf("expected boot script of cluster lab, got %s", w.Body.String())
+	}
+
+	// host API calls are handled by the cluster of the host
+	router := mux.NewRouter()
+	mgr.defineAdminRoutes(router)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "http://127.0.0.1:4080/admin/host/prereg/boot_complete", strings.NewReader(`{"FlatcarVersion":"1.2.3"}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusAccepted)
+	}
+	host, _ := lab.HostWithSerial("prereg")
+	if host.State != hostmgr.Running || host.FlatcarVersion != "1.2.3" {
+		t.Errorf("expected host to be running version 1.2.3, got %v %s", host.State, host.FlatcarVersion)
+	}
 }
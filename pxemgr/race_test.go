@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package pxemgr
+
+// raceEnabled reports whether the tests run using the race detector.
+const raceEnabled = true
@@ -0,0 +1,278 @@
+package pxemgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/giantswarm/microerror"
+)
+
+// reloadDelay collects the file changes made within a short time, eg. by
+// editors writing several files, into one reload.
+const reloadDelay = 500 * time.Millisecond
+
+// withConfig serves requests using one configuration. Reloads are applied
+// between requests. Requests of the etcd discovery are not held back, as they
+// might long-poll and do not depend on the configuration.
+func (mgr *pxeManagerT) withConfig(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/etcd/") {
+			mgr.configMu.RLock()
+			defer mgr.configMu.RUnlock()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Reload loads the configuration file again and applies it together with the
+// templates, snippets and files it references. The new configuration is
+// validated by rendering the Ignition config of every profile first, so the
+// running configuration is kept in case it is invalid. Additional clusters
+// can be changed, but not added, removed or moved without a restart. The
+// dnsmasq configuration is rewritten and dnsmasq restarted only in case the
+// networks changed.
+func (mgr *pxeManagerT) Reload() error {
+	mgr.reloadMu.Lock()
+	defer mgr.reloadMu.Unlock()
+
+	conf, err := loadConfiguration(mgr.configFile)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if len(conf.Clusters) != len(mgr.clusters) {
+		return microerror.Maskf(invalidConfigError, "adding or removing clusters requires a restart")
+	}
+	for i, def := range conf.Clusters {
+		if def.Name != mgr.clusters[i].name || def.ClusterDirectory != mgr.config.Clusters[i].ClusterDirectory {
+			return microerror.Maskf(invalidConfigError, "changing the name or directory of cluster '%s' requires a restart", mgr.clusters[i].name)
+		}
+	}
+
+	next := *mgr
+	next.config = &conf
+	next.serials = serialSet(conf.Serials)
+	next.clusters = nil
+	if next.useInternalEtcdDiscovery {
+		endpoint, ok := conf.TemplatesEnv["mayu_https_endpoint"].(string)
+		if !ok {
+			return microerror.Maskf(invalidConfigError, "templates_env of %s lacks mayu_https_endpoint", mgr.configFile)
+		}
+		next.etcdDiscoveryUrl = endpoint + "/etcd"
+	}
+	for i, def := range conf.Clusters {
+		next.clusters = append(next.clusters, next.newChild(def, mgr.clusters[i].cluster))
+	}
+
+	err = next.WriteIgnitionConfigs(ioutil.Discard)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	dnsmasqRunning := mgr.DNSmasq != nil && mgr.DNSmasq.running()
+	if dnsmasqRunning {
+		_, err = mgr.DNSmasq.renderConf(next.dnsmasqNetworks())
+		if err != nil {
+			return microerror.Maskf(invalidConfigError, "dnsmasq template: %s", err)
+		}
+	}
+
+	mgr.configMu.Lock()
+	mgr.apply(&next)
+	for i, child := range mgr.clusters {
+		child.apply(next.clusters[i])
+	}
+	mgr.configMu.Unlock()
+
+	_ = mgr.logger.Log("level", "info", "message", fmt.Sprintf("reloaded configuration from %s", mgr.configFile))
+
+	for _, m := range append([]*pxeManagerT{mgr}, mgr.clusters...) {
+		m.mu.Lock()
+		err = m.checkAdditionalNICAddresses()
+		m.mu.Unlock()
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	if dnsmasqRunning {
+		err = mgr.reloadDNSmasq()
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// reloadDNSmasq rewrites the dnsmasq configuration and restarts dnsmasq in
+// case the configuration changed.
+func (mgr *pxeManagerT) reloadDNSmasq() error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	changed, err := mgr.DNSmasq.updateConf(mgr.dnsmasqNetworks())
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !changed {
+		return nil
+	}
+
+	err = mgr.DNSmasq.Restart()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// apply takes over the settings of next, which got reloaded from the
+// configuration file.
+func (mgr *pxeManagerT) apply(next *pxeManagerT) {
+	mgr.config = next.config
+	mgr.globalTemplatesEnv = next.globalTemplatesEnv
+	mgr.serials = next.serials
+	mgr.ignitionConfig = next.ignitionConfig
+	mgr.templateSnippets = next.templateSnippets
+	mgr.filesDir = next.filesDir
+	mgr.etcdDiscoveryUrl = next.etcdDiscoveryUrl
+}
+
+// reloadPaths returns the directories keeping the configuration file and the
+// templates, snippets and files of all clusters.
+func (mgr *pxeManagerT) reloadPaths() []string {
+	paths := []string{filepath.Dir(mgr.configFile)}
+
+	var add func(m *pxeManagerT)
+	add = func(m *pxeManagerT) {
+		paths = append(paths, filepath.Dir(m.ignitionConfig), m.templateSnippets)
+		paths = append(paths, subdirectories(m.filesDir)...)
+		for _, profile := range m.config.Profiles {
+			if profile.IgnitionConfig != "" {
+				paths = append(paths, filepath.Dir(profile.IgnitionConfig))
+			}
+			if profile.Provisioner.UserData != "" {
+				paths = append(paths, filepath.Dir(profile.Provisioner.UserData))
+			}
+			paths = append(paths, profile.TemplateSnippets...)
+			paths = append(paths, subdirectories(profile.FilesDir)...)
+		}
+		for _, child := range m.clusters {
+			add(child)
+		}
+	}
+	add(mgr)
+
+	seen := map[string]bool{}
+	dirs := []string{}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		p = filepath.Clean(p)
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			dirs = append(dirs, p)
+		}
+	}
+	return dirs
+}
+
+// subdirectories returns dir and all directories below it.
+func subdirectories(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+
+	dirs := []string{}
+	_ = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	return dirs
+}
+
+// WatchConfig reloads the configuration whenever the configuration file or
+// the templates, snippets and files of a cluster change. Failed reloads are
+// logged and keep the running configuration.
+func (mgr *pxeManagerT) WatchConfig() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for _, dir := range mgr.reloadPaths() {
+		err = w.Add(dir)
+		if err != nil {
+			w.Close()
+			return microerror.Mask(err)
+		}
+	}
+
+	mgr.configWatcher = w
+	go mgr.watchConfig(w)
+
+	return nil
+}
+
+// Close stops watching the configuration.
+func (mgr *pxeManagerT) Close() error {
+	if mgr.configWatcher == nil {
+		return nil
+	}
+
+	err := mgr.configWatcher.Close()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (mgr *pxeManagerT) watchConfig(w *fsnotify.Watcher) {
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(filepath.Base(event.Name), ".") {
+				continue
+			}
+			reload = time.After(reloadDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			_ = mgr.logger.Log("level", "warning", "message", "watching the configuration failed, changes might be missed", "stack", err)
+		case <-reload:
+			reload = nil
+			err := mgr.Reload()
+			if err != nil {
+				_ = mgr.logger.Log("level", "error", "message", "reloading the configuration failed, keeping the running configuration", "stack", err)
+				continue
+			}
+			// watch the directories referenced by the new configuration
+			mgr.configMu.RLock()
+			dirs := mgr.reloadPaths()
+			mgr.configMu.RUnlock()
+			for _, dir := range dirs {
+				if err := w.Add(dir); err != nil {
+					_ = mgr.logger.Log("level", "warning", "message", fmt.Sprintf("unable to watch '%s'", dir), "stack", err)
+				}
+			}
+		}
+	}
+}
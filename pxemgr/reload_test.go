@@ -0,0 +1,84 @@
+package pxemgr
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+)
+
+func TestReload(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+
+	configFile := filepath.Join(h.dir, "config.yaml")
+	writeFile := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+	}
+	writeFile(configFile, configOK)
+	h.pxeCfg.ConfigFile = configFile
+
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	writeFile(configFile, configOK+"\n  reloaded: true\nprofiles:\n  - name: core\n    quantity: 1\n")
+	if err := mgr.Reload(); err != nil {
+		t.Fatalf("reloading: %s", err)
+	}
+	if mgr.config.TemplatesEnv["reloaded"] != true || len(mgr.config.Profiles) != 1 {
+		t.Fatalf("configuration not reloaded: %#v", mgr.config)
+	}
+
+	// invalid configs, templates and cluster changes keep the running
+	// configuration
+	failures := map[string]func(){
+		"invalid ignition config": func() { writeFile(configFile, configErr) },
+		"broken template":         func() { writeFile(h.pxeCfg.IgnitionConfig, "{{") },
+		"missing config":          func() { writeFile(configFile, "default_flatcar_version: [") },
+		"added cluster": func() {
+			writeFile(configFile, configOK+"\nclusters:\n  - name: lab\n    cluster_directory: lab\n")
+		},
+	}
+	for name, f := range failures {
+		f()
+		if err := mgr.Reload(); err == nil {
+			t.Errorf("%s: expected reloading to fail", name)
+		}
+		if mgr.config.TemplatesEnv["reloaded"] != true || mgr.config.TemplatesEnv["update"] != "no_updates" {
+			t.Errorf("%s: running configuration changed: %#v", name, mgr.config.TemplatesEnv)
+		}
+		writeFile(configFile, configOK+"\n  reloaded: true\n")
+		writeFile(h.pxeCfg.IgnitionConfig, ignition)
+	}
+
+	// changes are picked up by watching the configuration
+	if err := mgr.WatchConfig(); err != nil {
+		t.Fatalf("watching configuration: %s", err)
+	}
+	defer mgr.Close()
+	writeFile(configFile, configOK+"\n  reloaded: watched\n")
+	for i := 0; ; i++ {
+		mgr.configMu.RLock()
+		reloaded := mgr.config.TemplatesEnv["reloaded"]
+		mgr.configMu.RUnlock()
+		if reloaded == "watched" {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("configuration not reloaded after change, got %#v", reloaded)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,193 @@
+package pxemgr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+const (
+	// Encrypted values of template variables are strings of the form
+	// ENC[age,<base64 encoded age ciphertext>].
+	secretPrefix = "ENC[age,"
+	secretSuffix = "]"
+
+	// secretMask replaces encrypted values wherever they are shown.
+	secretMask = "REDACTED"
+)
+
+// IsSecret checks whether s is an encrypted value.
+func IsSecret(s string) bool {
+	return strings.HasPrefix(s, secretPrefix) && strings.HasSuffix(s, secretSuffix)
+}
+
+// EncryptSecret encrypts plaintext to the given recipients and returns it in
+// the format used for encrypted template variables.
+func EncryptSecret(plaintext []byte, recipients ...age.Recipient) (string, error) {
+	var b bytes.Buffer
+	w, err := age.Encrypt(&b, recipients...)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return "", microerror.Mask(err)
+	}
+	if err := w.Close(); err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return secretPrefix + base64.StdEncoding.EncodeToString(b.Bytes()) + secretSuffix, nil
+}
+
+// LoadSecretsKey reads the age identities of the key file at path, which
+// decrypt the encrypted template variables.
+func LoadSecretsKey(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "secrets key file %s: %s", path, err)
+	}
+
+	return identities, nil
+}
+
+// decryptSecret decrypts the encrypted value s.
+func decryptSecret(s string, identities []age.Identity) (string, error) {
+	if len(identities) == 0 {
+		return "", microerror.Maskf(executionFailedError, "no secrets key file given to decrypt secrets")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(s, secretPrefix), secretSuffix))
+	if err != nil {
+		return "", microerror.Maskf(executionFailedError, "invalid secret: %s", err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), identities...)
+	if err != nil {
+		return "", microerror.Maskf(executionFailedError, "decrypting secret: %s", err)
+	}
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", microerror.Maskf(executionFailedError, "decrypting secret: %s", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// mapSecrets returns a copy of v with every encrypted string, also within maps
+// and lists, replaced by the result of f.
+func mapSecrets(v interface{}, f func(string) (string, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if !IsSecret(v) {
+			return v, nil
+		}
+		return f(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			mapped, err := mapSecrets(e, f)
+			if err != nil {
+				return nil, microerror.Maskf(executionFailedError, "%s: %s", k, err)
+			}
+			m[k] = mapped
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			mapped, err := mapSecrets(e, f)
+			if err != nil {
+				return nil, microerror.Maskf(executionFailedError, "%v: %s", k, err)
+			}
+			m[k] = mapped
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			mapped, err := mapSecrets(e, f)
+			if err != nil {
+				return nil, microerror.Maskf(executionFailedError, "%d: %s", i, err)
+			}
+			l[i] = mapped
+		}
+		return l, nil
+	default:
+		return v, nil
+	}
+}
+
+// decryptSecrets returns a copy of vars with all encrypted values decrypted.
+// Decrypted values are kept in memory only while rendering templates.
+func decryptSecrets(vars map[string]interface{}, identities []age.Identity) (map[string]interface{}, error) {
+	decrypted, err := mapSecrets(vars, func(s string) (string, error) {
+		return decryptSecret(s, identities)
+	})
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "template variable %s", err)
+	}
+
+	return decrypted.(map[string]interface{}), nil
+}
+
+// maskSecrets returns a copy of v with all encrypted values replaced by
+// secretMask.
+func maskSecrets(v interface{}) interface{} {
+	masked, _ := mapSecrets(v, func(string) (string, error) {
+		return secretMask, nil
+	})
+	return masked
+}
+
+// maskTemplatesEnv returns a copy of vars with all encrypted values replaced
+// by secretMask.
+func maskTemplatesEnv(vars map[string]interface{}) map[string]interface{} {
+	if vars == nil {
+		return nil
+	}
+	return maskSecrets(vars).(map[string]interface{})
+}
+
+// maskedConfiguration returns a copy of c, which can be logged without
+// showing encrypted values.
+func maskedConfiguration(c Configuration) Configuration {
+	c.TemplatesEnv = maskTemplatesEnv(c.TemplatesEnv)
+
+	if c.Profiles != nil {
+		profiles := make([]Profile, len(c.Profiles))
+		for i, profile := range c.Profiles {
+			profile.TemplatesEnv = maskTemplatesEnv(profile.TemplatesEnv)
+			profiles[i] = profile
+		}
+		c.Profiles = profiles
+	}
+	if c.Clusters != nil {
+		clusters := make([]ClusterDefinition, len(c.Clusters))
+		for i, def := range c.Clusters {
+			def.Configuration = maskedConfiguration(def.Configuration)
+			clusters[i] = def
+		}
+		c.Clusters = clusters
+	}
+
+	return c
+}
+
+// maskedHost returns a copy of host, which can be logged or listed without
+// showing encrypted overrides.
+func maskedHost(host hostmgr.Host) hostmgr.Host {
+	host.Overrides = maskTemplatesEnv(host.Overrides)
+	return host
+}
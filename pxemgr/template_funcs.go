@@ -0,0 +1,251 @@
+package pxemgr
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v2"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// templateFuncs are the functions available within the Ignition template,
+// its snippets and the templates of the files directory. See
+// docs/templates.md for their usage.
+var templateFuncs = template.FuncMap{
+	"join": join,
+
+	"base64":  base64Encode,
+	"indent":  indent,
+	"nindent": nindent,
+	"toJson":  toJSON,
+	"toYaml":  toYAML,
+	"sha256":  sha256Sum,
+
+	"default":  defaultValue,
+	"required": required,
+
+	"ipAdd":    ipAdd,
+	"cidrHost": templateCIDRHost,
+	"netmask":  netmask,
+
+	"mac":          inventoryMAC,
+	"nicNames":     inventoryNICNames,
+	"connectedNIC": inventoryConnectedNIC,
+	"ipmiAddress":  inventoryIPMIAddress,
+}
+
+// join joins the elements of the list, eg. a list of templates_env or the
+// result of nicNames, using sep.
+func join(sep string, list interface{}) (string, error) {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", microerror.Maskf(executionFailedError, "cannot join %T", list)
+	}
+
+	var s []string
+	for i := 0; i < rv.Len(); i++ {
+		s = append(s, fmt.Sprint(rv.Index(i).Interface()))
+	}
+	return strings.Join(s, sep), nil
+}
+
+func base64Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// indent prefixes every line of s with the given number of spaces.
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// nindent is indent starting with a newline, so that multi-line values can be
+// placed below a YAML key.
+func nindent(spaces int, s string) string {
+	return "\n" + indent(spaces, s)
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(jsonCompatible(v))
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	return string(data), nil
+}
+
+func toYAML(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// jsonCompatible converts the maps decoded from YAML, eg. the values of
+// templates_env, to maps that can be encoded to JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[k] = jsonCompatible(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = jsonCompatible(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func sha256Sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// isEmpty checks whether v is missing or the zero value of its type. Empty
+// slices and maps are considered empty as well.
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+	}
+}
+
+// defaultValue returns v, or def in case v is empty. It is meant to be used
+// in pipelines like {{ .TemplatesEnv.mtu | default 1500 }}.
+func defaultValue(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || isEmpty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+// required fails rendering with the given message in case v is empty.
+func required(message string, v interface{}) (interface{}, error) {
+	if isEmpty(v) {
+		return nil, microerror.Maskf(executionFailedError, "%s", message)
+	}
+	return v, nil
+}
+
+// parseTemplateIP parses addresses given as string or net.IP, eg.
+// .Host.InternalAddr.
+func parseTemplateIP(v interface{}) (net.IP, error) {
+	if ip, ok := v.(net.IP); ok && ip != nil {
+		return ip, nil
+	}
+	ip := net.ParseIP(fmt.Sprint(v))
+	if ip == nil {
+		return nil, microerror.Maskf(executionFailedError, "invalid IP address '%v'", v)
+	}
+	return ip, nil
+}
+
+// ipAdd returns the address n addresses after ip.
+func ipAdd(n int, ip interface{}) (string, error) {
+	parsed, err := parseTemplateIP(ip)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	ret, err := addToIP(parsed, int64(n))
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	return ret.String(), nil
+}
+
+func templateCIDRHost(n int, cidr string) (string, error) {
+	ip, err := cidrHost(cidr, int64(n))
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	return ip.String(), nil
+}
+
+// netmask returns the netmask of a network given in CIDR notation or of an
+// IPv4 prefix length, eg. .ClusterNetwork.PrimaryNIC.SubnetSize.
+func netmask(v interface{}) (string, error) {
+	s := fmt.Sprint(v)
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		return net.IP(network.Mask).String(), nil
+	}
+
+	ones, err := strconv.Atoi(s)
+	if err != nil || ones < 0 || ones > 32 {
+		return "", microerror.Maskf(executionFailedError, "invalid prefix length '%s'", s)
+	}
+	return net.IP(net.CIDRMask(ones, 32)).String(), nil
+}
+
+// inventoryMAC returns the MAC address of the NIC of the given name, or an
+// empty string in case the inventory does not know it.
+func inventoryMAC(inv *hostmgr.Inventory, name string) string {
+	if inv == nil {
+		return ""
+	}
+	for _, dev := range inv.HostData.NetDevs {
+		if dev.Name == name {
+			return strings.ToLower(dev.MacAddress)
+		}
+	}
+	return ""
+}
+
+func inventoryNICNames(inv *hostmgr.Inventory) []string {
+	names := []string{}
+	if inv == nil {
+		return names
+	}
+	for _, dev := range inv.HostData.NetDevs {
+		names = append(names, dev.Name)
+	}
+	return names
+}
+
+func inventoryConnectedNIC(inv *hostmgr.Inventory) string {
+	if inv == nil {
+		return ""
+	}
+	return inv.HostData.ConnectedNIC
+}
+
+func inventoryIPMIAddress(inv *hostmgr.Inventory) string {
+	if inv == nil || inv.HostData.IPMIAddress == nil {
+		return ""
+	}
+	return inv.HostData.IPMIAddress.String()
+}
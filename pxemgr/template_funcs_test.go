@@ -0,0 +1,130 @@
+package pxemgr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/giantswarm/mayu-infopusher/machinedata"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	inventory := &hostmgr.Inventory{
+		HostData: machinedata.HostData{
+			NetDevs: []machinedata.NetDev{
+				{Name: "eth0", MacAddress: "0C:C4:7A:00:00:01"},
+				{Name: "eth1", MacAddress: "0c:c4:7a:00:00:02"},
+			},
+			ConnectedNIC: "eth1",
+			IPMIAddress:  net.ParseIP("10.0.0.100"),
+		},
+	}
+	data := map[string]interface{}{
+		"Addr":      net.ParseIP("10.0.4.31"),
+		"Inventory": inventory,
+		"None":      (*hostmgr.Inventory)(nil),
+		"Env": map[interface{}]interface{}{
+			"dns":  []interface{}{"8.8.8.8", "1.1.1.1"},
+			"port": 123,
+			"name": "",
+		},
+	}
+
+	cases := []struct {
+		template string
+		expected string
+	}{
+		{`{{ "hello" | base64 }}`, "aGVsbG8="},
+		{`{{ "hello" | sha256 }}`, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{`{{ "a\nb" | indent 2 }}`, "  a\n  b"},
+		{`x:{{ "a\nb" | nindent 2 }}`, "x:\n  a\n  b"},
+		{`{{ .Env.dns | toJson }}`, `["8.8.8.8","1.1.1.1"]`},
+		{`{{ .Env | toJson }}`, `{"dns":["8.8.8.8","1.1.1.1"],"name":"","port":123}`},
+		{`{{ .Env.dns | toYaml }}`, "- 8.8.8.8\n- 1.1.1.1"},
+		{`{{ .Env.name | default "node" }}`, "node"},
+		{`{{ .Env.missing | default 1500 }}`, "1500"},
+		{`{{ .Env.port | default 80 }}`, "123"},
+		{`{{ .Env.port | required "port is required" }}`, "123"},
+		{`{{ .Addr | ipAdd 1 }}`, "10.0.4.32"},
+		{`{{ "10.0.4.255" | ipAdd 1 }}`, "10.0.5.0"},
+		{`{{ "fd00::ff" | ipAdd -255 }}`, "fd00::"},
+		{`{{ "10.0.4.0/24" | cidrHost 10 }}`, "10.0.4.10"},
+		{`{{ "10.0.4.0/24" | cidrHost -2 }}`, "10.0.4.254"},
+		{`{{ "10.0.4.0/22" | netmask }}`, "255.255.252.0"},
+		{`{{ "24" | netmask }}`, "255.255.255.0"},
+		{`{{ mac .Inventory "eth0" }}`, "0c:c4:7a:00:00:01"},
+		{`{{ mac .Inventory "eth9" }}`, ""},
+		{`{{ mac .None "eth0" }}`, ""},
+		{`{{ nicNames .Inventory | join "," }}`, "eth0,eth1"},
+		{`{{ connectedNIC .Inventory }} {{ ipmiAddress .Inventory }}`, "eth1 10.0.0.100"},
+		{`{{ ipmiAddress .None }}{{ connectedNIC .None }}`, ""},
+	}
+	for i, c := range cases {
+		actual, err := executeTestTemplate(c.template, data)
+		if err != nil {
+			t.Errorf("case %d: executing %s: %s", i, c.template, err)
+			continue
+		}
+		if actual != c.expected {
+			t.Errorf("case %d: expected %s to render '%s', got '%s'", i, c.template, c.expected, actual)
+		}
+	}
+
+	failures := []string{
+		`{{ .Env.name | required "name is required" }}`,
+		`{{ .Env.missing | required "missing is required" }}`,
+		`{{ "255.255.255.255" | ipAdd 1 }}`,
+		`{{ "invalid" | ipAdd 1 }}`,
+		`{{ "10.0.4.0/24" | cidrHost 256 }}`,
+		`{{ "10.0.4.0/24" | cidrHost -257 }}`,
+		`{{ "33" | netmask }}`,
+	}
+	for i, f := range failures {
+		if actual, err := executeTestTemplate(f, data); err == nil {
+			t.Errorf("case %d: expected %s to fail, got '%s'", i, f, actual)
+		}
+	}
+}
+
+func executeTestTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("test").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func TestRenderFilesFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pxemgr_files_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "my-service"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "my-service", "config.ini"), []byte(`gateway={{ "10.0.4.0/24" | cidrHost 1 }}`), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+
+	mgr := &pxeManagerT{filesDir: dir, config: &Configuration{}}
+	files, _, err := mgr.RenderFiles(nil, "")
+	if err != nil {
+		t.Fatalf("rendering files: %s", err)
+	}
+	if expected := base64Encode("gateway=10.0.4.1"); (*files)["my-service/config.ini"] != expected {
+		t.Errorf("expected %s, got %s", expected, (*files)["my-service/config.ini"])
+	}
+}
@@ -0,0 +1,71 @@
+package pxemgr
+
+import (
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// templateVarsLayer is a set of template variables given by a single source.
+type templateVarsLayer struct {
+	source string
+	vars   map[string]interface{}
+}
+
+// templateVarsLayers returns the layers of template variables of host, lowest
+// precedence first: the templates_env of the default cluster, the one of an
+// additional cluster, the one of the profile of host and the overrides of
+// host.
+func (mgr *pxeManagerT) templateVarsLayers(host hostmgr.Host) []templateVarsLayer {
+	layers := []templateVarsLayer{}
+	if mgr.globalTemplatesEnv != nil {
+		layers = append(layers,
+			templateVarsLayer{source: hostmgr.TemplateVarSourceGlobal, vars: mgr.globalTemplatesEnv},
+			templateVarsLayer{source: hostmgr.TemplateVarSourceCluster, vars: mgr.config.TemplatesEnv},
+		)
+	} else {
+		layers = append(layers, templateVarsLayer{source: hostmgr.TemplateVarSourceGlobal, vars: mgr.config.TemplatesEnv})
+	}
+	if profile, ok := mgr.config.profile(host.Profile); ok {
+		layers = append(layers, templateVarsLayer{source: hostmgr.TemplateVarSourceProfile, vars: profile.TemplatesEnv})
+	}
+	layers = append(layers, templateVarsLayer{source: hostmgr.TemplateVarSourceHost, vars: host.Overrides})
+
+	return layers
+}
+
+// mergeTemplateVars returns a new map of the variables of all layers. Layers
+// replace the variables of lower layers as a whole. The maps of the layers are
+// not modified.
+func mergeTemplateVars(layers []templateVarsLayer) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, layer := range layers {
+		for k, v := range layer.vars {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+// explainTemplateVars returns the variables of all layers together with the
+// layer giving their value and the values it overrides. Encrypted values are
+// masked.
+func explainTemplateVars(layers []templateVarsLayer) map[string]hostmgr.TemplateVar {
+	explained := map[string]hostmgr.TemplateVar{}
+	for _, layer := range layers {
+		for k, v := range layer.vars {
+			v = jsonCompatible(maskSecrets(v))
+			current, exists := explained[k]
+			if exists {
+				current.Overridden = append(current.Overridden, hostmgr.TemplateVarValue{
+					Source: current.Source,
+					Value:  current.Value,
+				})
+			}
+			current.Source = layer.source
+			current.Value = v
+			explained[k] = current
+		}
+	}
+
+	return explained
+}
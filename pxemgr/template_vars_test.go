@@ -0,0 +1,127 @@
+package pxemgr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func TestMergeTemplateVars(t *testing.T) {
+	mgr := &pxeManagerT{
+		globalTemplatesEnv: map[string]interface{}{"ntp": "pool.ntp.org", "mtu": 1500, "docker_args": ""},
+		config: &Configuration{
+			TemplatesEnv: map[string]interface{}{"mtu": 9000},
+			Profiles: []Profile{
+				{Name: "storage", TemplatesEnv: map[string]interface{}{"docker_args": "--storage-driver=overlay2", "raid": true}},
+			},
+		},
+	}
+
+	host := hostmgr.Host{Profile: "storage", Overrides: map[string]interface{}{"docker_args": "--debug"}}
+	merged := mergeTemplateVars(mgr.templateVarsLayers(host))
+	expected := map[string]interface{}{"ntp": "pool.ntp.org", "mtu": 9000, "docker_args": "--debug", "raid": true}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, merged)
+	}
+
+	// overrides of one host must not leak into the variables of others
+	merged = mergeTemplateVars(mgr.templateVarsLayers(hostmgr.Host{Profile: "worker"}))
+	expected = map[string]interface{}{"ntp": "pool.ntp.org", "mtu": 9000, "docker_args": ""}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, merged)
+	}
+	if len(mgr.config.TemplatesEnv) != 1 || len(mgr.globalTemplatesEnv) != 3 || len(mgr.config.Profiles[0].TemplatesEnv) != 2 {
+		t.Fatalf("layers got modified: %#v", mgr)
+	}
+
+	explained := explainTemplateVars(mgr.templateVarsLayers(host))
+	expectedVar := hostmgr.TemplateVar{
+		Value:  "--debug",
+		Source: hostmgr.TemplateVarSourceHost,
+		Overridden: []hostmgr.TemplateVarValue{
+			{Source: hostmgr.TemplateVarSourceGlobal, Value: ""},
+			{Source: hostmgr.TemplateVarSourceProfile, Value: "--storage-driver=overlay2"},
+		},
+	}
+	if !reflect.DeepEqual(explained["docker_args"], expectedVar) {
+		t.Fatalf("expected %#v, got %#v", expectedVar, explained["docker_args"])
+	}
+	if mtu := explained["mtu"]; mtu.Source != hostmgr.TemplateVarSourceCluster || mtu.Value != 9000 || len(mtu.Overridden) != 1 {
+		t.Fatalf("unexpected mtu %#v", mtu)
+	}
+}
+
+func TestHostTemplatesEnv(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	config := configOK + `
+profiles:
+  - name: core
+    quantity: 1
+    templates_env:
+      http_proxy_enabled: false
+`
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_vars.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_vars.yaml")
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	host, err := h.cluster.CreateNewHost("vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Profile = "core"
+	host.Overrides = map[string]interface{}{"update": "update"}
+	if err := host.Commit("set profile and overrides"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mgr.hostTemplatesEnv("vars", w, httptest.NewRequest("GET", "http://127.0.0.1:4080/admin/host/vars/templates_env", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+
+	var vars map[string]hostmgr.TemplateVar
+	if err := json.NewDecoder(w.Body).Decode(&vars); err != nil {
+		t.Fatalf("decoding template variables: %s", err)
+	}
+	if v := vars["http_proxy_enabled"]; v.Source != hostmgr.TemplateVarSourceProfile || v.Value != false {
+		t.Errorf("unexpected http_proxy_enabled %#v", v)
+	}
+	if v := vars["update"]; v.Source != hostmgr.TemplateVarSourceHost || v.Value != "update" || v.Overridden[0].Value != "no_updates" {
+		t.Errorf("unexpected update %#v", v)
+	}
+	if v := vars["http_proxy"].Value.(map[string]interface{}); v["uri"] != "uri" {
+		t.Errorf("unexpected http_proxy %#v", v)
+	}
+	if mgr.config.TemplatesEnv["update"] != "no_updates" {
+		t.Errorf("templates_env got modified: %#v", mgr.config.TemplatesEnv)
+	}
+
+	w = httptest.NewRecorder()
+	mgr.hostTemplatesEnv("unknown", w, httptest.NewRequest("GET", "http://127.0.0.1:4080/admin/host/unknown/templates_env", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusNotFound)
+	}
+}
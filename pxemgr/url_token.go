@@ -0,0 +1,194 @@
+package pxemgr
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+// minURLSigningKeyLength is the minimum length of the URL signing key in
+// bytes.
+const minURLSigningKeyLength = 32
+
+// loadURLSigningKey reads the key signing URL tokens from path. Surrounding
+// white space is ignored.
+func loadURLSigningKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	key := bytes.TrimSpace(data)
+	if len(key) < minURLSigningKeyLength {
+		return nil, microerror.Maskf(invalidConfigError, "URL signing key %s must have at least %d bytes", path, minURLSigningKeyLength)
+	}
+
+	return key, nil
+}
+
+// Purposes of tokens, which are part of their signature so that a token
+// cannot be used for other requests than it got issued for.
+const (
+	tokenPurposeIgnition = "ignition"
+	tokenPurposePostBoot = "boot_complete"
+)
+
+// signURLs returns whether URLs of hosts carry tokens.
+func (mgr *pxeManagerT) signURLs() bool {
+	return len(mgr.urlSigningKey) > 0
+}
+
+// hostToken returns a token for the host given by serial, which is valid for
+// the configured time. The token binds the serial to the machine booting as
+// the host, given by its MAC address as reported by iPXE and the IP address
+// requests of the machine come from. The token is empty in case URLs are not
+// signed.
+func (mgr *pxeManagerT) hostToken(serial, mac string, ip net.IP) string {
+	if !mgr.signURLs() {
+		return ""
+	}
+
+	mac = normalizeMAC(mac)
+	expiry := time.Now().Add(mgr.urlTokenTTL).Unix()
+	return fmt.Sprintf("%d.%s.%s", expiry, tokenMAC(mac), mgr.tokenSignature(tokenPurposeIgnition, serial, mac, ip, expiry))
+}
+
+// postBootToken returns the token of the post boot URL of the host given by
+// serial. Hosts may report back long after they got installed, so the token
+// does not expire and is not bound to an address, but only to the serial and
+// the MAC address of the host. The token is empty in case URLs are not
+// signed.
+func (mgr *pxeManagerT) postBootToken(serial, mac string) string {
+	if !mgr.signURLs() {
+		return ""
+	}
+
+	mac = normalizeMAC(mac)
+	return fmt.Sprintf("0.%s.%s", tokenMAC(mac), mgr.tokenSignature(tokenPurposePostBoot, serial, mac, nil, 0))
+}
+
+// verifyHostToken checks that token got issued for the host given by serial
+// to the machine requesting from ip, and did not expire yet. The machine must
+// still be allowed to act as the host, see checkMachine.
+func (mgr *pxeManagerT) verifyHostToken(serial string, ip net.IP, token string) error {
+	expiry, mac, signature, err := parseToken(token)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(mgr.tokenSignature(tokenPurposeIgnition, serial, mac, ip, expiry))) {
+		return microerror.Maskf(invalidTokenError, "token was not issued for host %s at %s", serial, ip)
+	}
+	if time.Now().Unix() > expiry {
+		return microerror.Maskf(invalidTokenError, "token expired at %s", time.Unix(expiry, 0).UTC().Format(time.RFC3339))
+	}
+
+	return mgr.checkMachine(serial, mac, ip)
+}
+
+// verifyPostBootToken checks that token is the post boot token of the host
+// given by serial, see postBootToken.
+func (mgr *pxeManagerT) verifyPostBootToken(serial string, token string) error {
+	expiry, mac, signature, err := parseToken(token)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(mgr.tokenSignature(tokenPurposePostBoot, serial, mac, nil, expiry))) {
+		return microerror.Maskf(invalidTokenError, "token was not issued for host %s", serial)
+	}
+
+	return mgr.checkMachine(serial, mac, nil)
+}
+
+// parseToken splits token into its expiry, MAC address and signature.
+func parseToken(token string) (int64, string, string, error) {
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 {
+		return 0, "", "", microerror.Maskf(invalidTokenError, "malformed token")
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", "", microerror.Maskf(invalidTokenError, "malformed token")
+	}
+	mac := ""
+	if parts[1] != tokenMAC("") {
+		mac = parts[1]
+	}
+
+	return expiry, mac, parts[2], nil
+}
+
+// checkMachine checks whether the machine with the given MAC and IP address
+// may act as the host given by serial. Known hosts must boot from one of
+// their MAC addresses, and no machine may use the internal address of
+// another host.
+func (mgr *pxeManagerT) checkMachine(serial, mac string, ip net.IP) error {
+	if ip != nil {
+		if other, exists := mgr.cluster.HostWithInternalAddr(ip); exists && !strings.EqualFold(other.Serial, serial) {
+			return microerror.Maskf(invalidTokenError, "address %s belongs to host %s", ip, other.Serial)
+		}
+	}
+
+	host, exists := mgr.cluster.HostWithSerial(serial)
+	if !exists || len(host.MacAddresses) == 0 {
+		return nil
+	}
+	mac = normalizeMAC(mac)
+	for _, known := range host.MacAddresses {
+		if normalizeMAC(known) == mac {
+			return nil
+		}
+	}
+	return microerror.Maskf(invalidTokenError, "MAC address '%s' does not belong to host %s", mac, serial)
+}
+
+// firstMAC returns the first known MAC address of host, if any.
+func firstMAC(host hostmgr.Host) string {
+	if len(host.MacAddresses) == 0 {
+		return ""
+	}
+	return host.MacAddresses[0]
+}
+
+// normalizeMAC returns mac as lower case hex digits without separators.
+func normalizeMAC(mac string) string {
+	return strings.NewReplacer(":", "", "-", "").Replace(strings.ToLower(mac))
+}
+
+// tokenMAC returns the MAC address as part of a token, which must not be
+// empty.
+func tokenMAC(mac string) string {
+	if mac == "" {
+		return "-"
+	}
+	return mac
+}
+
+func (mgr *pxeManagerT) tokenSignature(purpose, serial, mac string, ip net.IP, expiry int64) string {
+	h := hmac.New(sha256.New, mgr.urlSigningKey)
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%d", purpose, strings.ToLower(serial), mac, ip, expiry)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// isRegistered returns whether the host given by serial is known to the
+// cluster or pre-registered with it.
+func (mgr *pxeManagerT) isRegistered(serial string) bool {
+	serial = strings.ToLower(serial)
+	if _, exists := mgr.cluster.HostWithSerial(serial); exists {
+		return true
+	}
+	_, listed := mgr.serials[serial]
+	return listed
+}
@@ -0,0 +1,351 @@
+package pxemgr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger"
+	"github.com/gorilla/mux"
+
+	"github.com/giantswarm/mayu/client"
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+func TestHostToken(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	host, err := h.cluster.CreateNewHost("Known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.InternalAddr = net.ParseIP("10.0.0.2")
+	host.MacAddresses = []string{"00:16:3E:A0:B7:DF"}
+	if err := host.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := &pxeManagerT{
+		cluster:       h.cluster,
+		urlSigningKey: []byte(strings.Repeat("k", minURLSigningKeyLength)),
+		urlTokenTTL:   time.Hour,
+	}
+	ip := net.ParseIP("192.0.2.1")
+
+	token := mgr.hostToken("Test1234", "", ip)
+	if err := mgr.verifyHostToken("test1234", ip, token); err != nil {
+		t.Fatalf("expected token to be valid, got %#v", err)
+	}
+	known := mgr.hostToken("known", "00-16-3e-a0-b7-df", ip)
+	if err := mgr.verifyHostToken("known", ip, known); err != nil {
+		t.Fatalf("expected token of known host to be valid, got %#v", err)
+	}
+
+	other := &pxeManagerT{
+		cluster:       h.cluster,
+		urlSigningKey: []byte(strings.Repeat("o", minURLSigningKeyLength)),
+		urlTokenTTL:   time.Hour,
+	}
+	expired := &pxeManagerT{
+		cluster:       h.cluster,
+		urlSigningKey: mgr.urlSigningKey,
+		urlTokenTTL:   -time.Minute,
+	}
+
+	cases := []struct {
+		name   string
+		serial string
+		ip     string
+		token  string
+	}{
+		{"other host", "test5678", "192.0.2.1", token},
+		{"other address", "test1234", "192.0.2.2", token},
+		{"address of other host", "test1234", "10.0.0.2", mgr.hostToken("test1234", "", net.ParseIP("10.0.0.2"))},
+		{"other MAC", "known", "192.0.2.1", mgr.hostToken("known", "00:16:3e:00:00:01", ip)},
+		{"no MAC", "known", "192.0.2.1", mgr.hostToken("known", "", ip)},
+		{"changed MAC", "known", "192.0.2.1", strings.Replace(known, "00163ea0b7df", "00163e000001", 1)},
+		{"other key", "test1234", "192.0.2.1", other.hostToken("test1234", "", ip)},
+		{"expired", "test1234", "192.0.2.1", expired.hostToken("test1234", "", ip)},
+		{"empty", "test1234", "192.0.2.1", ""},
+		{"malformed", "test1234", "192.0.2.1", "abc.def"},
+		{"changed expiry", "test1234", "192.0.2.1", "9" + token},
+	}
+	for _, c := range cases {
+		if err := mgr.verifyHostToken(c.serial, net.ParseIP(c.ip), c.token); !IsInvalidToken(err) {
+			t.Errorf("%s: expected invalid token error, got %#v", c.name, err)
+		}
+	}
+
+	postBoot := mgr.postBootToken("known", "00:16:3e:a0:b7:df")
+	if err := mgr.verifyPostBootToken("known", postBoot); err != nil {
+		t.Fatalf("expected post boot token to be valid, got %#v", err)
+	}
+	for name, token := range map[string]string{
+		"other MAC":      mgr.postBootToken("known", "00:16:3e:00:00:01"),
+		"ignition token": known,
+		"other key":      other.postBootToken("known", "00:16:3e:a0:b7:df"),
+	} {
+		if err := mgr.verifyPostBootToken("known", token); !IsInvalidToken(err) {
+			t.Errorf("%s: expected invalid post boot token error, got %#v", name, err)
+		}
+	}
+	if err := mgr.verifyHostToken("known", ip, postBoot); !IsInvalidToken(err) {
+		t.Errorf("expected post boot token to be refused for ignition, got %#v", err)
+	}
+
+	if token := (&pxeManagerT{}).hostToken("test1234", "", ip); token != "" {
+		t.Errorf("expected no token without signing key, got %s", token)
+	}
+}
+
+func TestSignedIgnitionURLs(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	keyFile := filepath.Join(h.dir, "url-signing.key")
+	if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("s", minURLSigningKeyLength)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// files pass the token on to follow-up requests
+	writeFile := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(h.dir, "files", name), []byte(data), 0644); err != nil { // nolint
+			t.Fatal(err)
+		}
+	}
+	writeFile("report.sh", "curl -X PUT {{ .PostBootURL }}\n")
+	writeFile("report.sh"+fileMetaSuffix, "path: /opt/bin/report.sh\n")
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_ok.yaml")
+	h.pxeCfg.URLSigningKeyFile = keyFile
+	h.pxeCfg.URLTokenTTL = time.Hour
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	// the boot script asks for the serial first to sign the URL
+	w := httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript", nil))
+	if !strings.Contains(w.Body.String(), "chain ") || !strings.Contains(w.Body.String(), "mac=${netX/mac}") {
+		t.Fatalf("expected boot script to chain, got %s", w.Body.String())
+	}
+
+	// machines without serial and UUID are not chained again
+	w = httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript?uuid=&serial=&mac=52:54:00:12:34:56", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for chained request without serial, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript?uuid=abc&serial=test1234", nil))
+	match := regexp.MustCompile(`flatcar\.config\.url=(\S+)`).FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("expected boot script to contain the config url, got %s", w.Body.String())
+	}
+	configURL, err := url.Parse(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := configURL.Query().Get("token")
+	if configURL.Query().Get("serial") != "test1234" || token == "" {
+		t.Fatalf("expected signed config url of host test1234, got %s", configURL)
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mgr.ignitionGenerator(w, httptest.NewRequest("GET", "http://127.0.0.1:4080/ignition?"+query, nil))
+		return w
+	}
+
+	// the token only works for the machine it got issued to
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://127.0.0.1:4080/ignition?"+configURL.RawQuery, nil)
+	req.RemoteAddr = "192.0.2.99:1234"
+	mgr.ignitionGenerator(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for request of other machine, got %d", http.StatusForbidden, w.Code)
+	}
+
+	for _, query := range []string{
+		"serial=test1234",
+		"serial=test1234&token=" + url.QueryEscape("1."+strings.SplitN(token, ".", 2)[1]),
+		"serial=test5678&token=" + url.QueryEscape(token),
+	} {
+		if w := get(query); w.Code != http.StatusForbidden {
+			t.Errorf("expected status %d for %s, got %d", http.StatusForbidden, query, w.Code)
+		}
+	}
+	if len(h.cluster.GetAllHosts()) != 0 {
+		t.Fatalf("expected refused requests not to create hosts")
+	}
+
+	w = get(configURL.RawQuery)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var cfg struct {
+		Storage struct {
+			Files []struct {
+				Contents struct {
+					Source string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Storage.Files) != 1 {
+		t.Fatalf("expected one storage file, got %s", w.Body.String())
+	}
+	script, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(cfg.Storage.Files[0].Contents.Source, "data:;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postBoot := regexp.MustCompile(`boot_complete\?token=(\S+)`).FindStringSubmatch(string(script))
+	if postBoot == nil {
+		t.Fatalf("expected file with signed post boot url, got %s", script)
+	}
+
+	// hosts report back using the post boot token, which is neither bound to
+	// the address of the host nor expires
+	host, _ := h.cluster.HostWithSerial("test1234")
+	bootComplete := func(token string) int {
+		w := httptest.NewRecorder()
+		target := "http://127.0.0.1:4080/admin/host/test1234/boot_complete"
+		if token != "" {
+			target += "?token=" + url.QueryEscape(token)
+		}
+		mgr.bootComplete("test1234", w, httptest.NewRequest("PUT", target, strings.NewReader(`{}`)))
+		return w.Code
+	}
+	for _, token := range []string{"1.abc", "1.-.abc", "0.-.abc", token} {
+		if code := bootComplete(token); code != http.StatusForbidden {
+			t.Errorf("expected status %d for token '%s', got %d", http.StatusForbidden, token, code)
+		}
+	}
+	if code := bootComplete(postBoot[1]); code != http.StatusAccepted {
+		t.Errorf("expected status %d for valid token, got %d", http.StatusAccepted, code)
+	}
+
+	// the post boot token is still valid once the token TTL passed
+	mgr.urlTokenTTL = -time.Minute
+	ctx, _, err := mgr.templateData(*host, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.verifyHostToken("test1234", host.InternalAddr, ctx.Token); !IsInvalidToken(err) {
+		t.Fatalf("expected expired token, got %#v", err)
+	}
+	postBootURL, err := url.Parse(ctx.PostBootURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := bootComplete(postBootURL.Query().Get("token")); code != http.StatusAccepted {
+		t.Errorf("expected status %d for post boot token after TTL, got %d", http.StatusAccepted, code)
+	}
+
+	// admin calls without a token keep working, eg. of mayuctl
+	router := mux.NewRouter()
+	mgr.defineAdminRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(tsURL.Port(), 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New("http", tsURL.Hostname(), uint16(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.BootComplete("test1234", hostmgr.Host{FlatcarVersion: "1.2.3"}); err != nil {
+		t.Fatalf("expected boot complete via client to succeed: %s", err)
+	}
+	host, _ = h.cluster.HostWithSerial("test1234")
+	if host.State != hostmgr.Running || host.FlatcarVersion != "1.2.3" {
+		t.Errorf("expected host to be running version 1.2.3, got %v %s", host.State, host.FlatcarVersion)
+	}
+}
+
+func TestPreregisteredOnly(t *testing.T) {
+	h := setUp(t)
+	defer tearDown(h)
+
+	config := configOK + `
+serials: ["PreReg"]
+`
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config_serials.yaml"), []byte(config), 0644); err != nil { // nolint
+		t.Fatal(err)
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		t.Fatalf("failed to create logger cluster: %s", err)
+	}
+	h.pxeCfg.Logger = logger
+	h.pxeCfg.ConfigFile = filepath.Join(h.dir, "config_serials.yaml")
+	h.pxeCfg.PreregisteredOnly = true
+	mgr, err := PXEManager(h.pxeCfg, h.cluster)
+	if err != nil {
+		t.Fatalf("unable to create a pxe manager: %s\n", err)
+	}
+
+	w := httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript?serial=unknown", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for boot script of unknown host, got %d", http.StatusForbidden, w.Code)
+	}
+	w = httptest.NewRecorder()
+	mgr.ignitionGenerator(w, httptest.NewRequest("GET", "http://127.0.0.1:4080/ignition?serial=unknown", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for ignition of unknown host, got %d", http.StatusForbidden, w.Code)
+	}
+	w = httptest.NewRecorder()
+	mgr.setInventory("unknown", w, httptest.NewRequest("PUT", "http://127.0.0.1:4080/admin/host/unknown/set_inventory", strings.NewReader(`{}`)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for inventory of unknown host, got %d", http.StatusForbidden, w.Code)
+	}
+	if _, exists := h.cluster.HostWithSerial("unknown"); exists {
+		t.Fatalf("expected unknown host not to be created")
+	}
+
+	w = httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript?serial=prereg", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "flatcar.config.url=") {
+		t.Errorf("expected boot script of pre-registered host, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	mgr.ignitionGenerator(w, httptest.NewRequest("GET", "http://127.0.0.1:4080/ignition?serial=prereg", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+
+	// known hosts keep booting
+	w = httptest.NewRecorder()
+	mgr.ipxeBootScript(w, httptest.NewRequest("GET", "http://127.0.0.1:4081/ipxebootscript?serial=prereg", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected boot script of known host, got %d", w.Code)
+	}
+}
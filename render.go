@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/giantswarm/micrologger"
+	"github.com/spf13/cobra"
+
+	"github.com/giantswarm/mayu/hostmgr"
+	"github.com/giantswarm/mayu/pxemgr"
+)
+
+var (
+	renderCmd = &cobra.Command{
+		Use:   "render",
+		Short: "Render the Ignition config of a host without changing its state",
+		Long: `Render the Ignition config of a host without changing its state.
+
+The config is rendered exactly like the host would receive it when requesting
+it now, except for encrypted values, which are masked. Hosts not known yet are
+rendered as they would be created. Use --diff to compare the config with the
+one the host received last.
+
+This command operates on the cluster directory directly. While mayu is
+running, use GET /admin/host/<serial>/ignition?dry_run=true instead.`,
+		Args: cobra.NoArgs,
+		Run:  renderRun,
+	}
+
+	renderFlags = struct {
+		serial  string
+		profile string
+		diff    bool
+	}{}
+)
+
+func init() {
+	renderCmd.Flags().StringVar(&renderFlags.serial, "serial", "", "Serial of the host to render")
+	renderCmd.Flags().StringVar(&renderFlags.profile, "profile", "", "Profile to render instead of the one of the host")
+	renderCmd.Flags().BoolVar(&renderFlags.diff, "diff", false, "Show the changes against the config the host received last")
+
+	mainCmd.AddCommand(renderCmd)
+}
+
+func renderRun(cmd *cobra.Command, args []string) {
+	if renderFlags.serial == "" {
+		log.Fatal("--serial is required")
+	}
+
+	logger, err := micrologger.New(micrologger.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lock, err := hostmgr.LockClusterDir(globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	storage, err := hostmgr.NewStorage(globalFlags.storage, globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !hostmgr.ClusterExists(storage) {
+		log.Fatalf("no cluster found in %s", globalFlags.clusterDir)
+	}
+	cluster, err := hostmgr.OpenClusterWithStorage(storage, false, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cluster.Close()
+
+	conf := pxeManagerConfiguration(logger)
+	conf.ReadOnly = true
+	pxeManager, err := pxemgr.PXEManager(conf, cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = pxeManager.WriteIgnitionPreview(renderFlags.serial, renderFlags.profile, renderFlags.diff, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+
+	"github.com/giantswarm/mayu/pxemgr"
+)
+
+var (
+	secretCmd = &cobra.Command{
+		Use:   "secret",
+		Short: "Manage encrypted values of templates_env and host overrides",
+	}
+
+	secretEncryptCmd = &cobra.Command{
+		Use:   "encrypt [value]",
+		Short: "Encrypt a value for use in templates_env or host overrides",
+		Long: `Encrypt a value for use in templates_env or host overrides.
+
+The value is read from stdin unless given as argument. It is encrypted to the
+age recipients given by --recipient, or to the identities of the
+--secrets-key-file in case no recipient is given.`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  secretEncryptRun,
+	}
+
+	secretEncryptFlags = struct {
+		recipients []string
+	}{}
+)
+
+func init() {
+	secretEncryptCmd.Flags().StringSliceVarP(&secretEncryptFlags.recipients, "recipient", "r", nil, "age recipient to encrypt to, eg. age1...")
+
+	secretCmd.AddCommand(secretEncryptCmd)
+	mainCmd.AddCommand(secretCmd)
+}
+
+func secretEncryptRun(cmd *cobra.Command, args []string) {
+	var recipients []age.Recipient
+	for _, r := range secretEncryptFlags.recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		if globalFlags.secretsKeyFile == "" {
+			log.Fatal("either --recipient or --secrets-key-file is required")
+		}
+		identities, err := pxemgr.LoadSecretsKey(globalFlags.secretsKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, identity := range identities {
+			if x25519, ok := identity.(*age.X25519Identity); ok {
+				recipients = append(recipients, x25519.Recipient())
+			}
+		}
+	}
+
+	var plaintext []byte
+	if len(args) == 1 {
+		plaintext = []byte(args[0])
+	} else {
+		var err error
+		plaintext, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	secret, err := pxemgr.EncryptSecret(plaintext, recipients...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(secret)
+}
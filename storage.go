@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/giantswarm/mayu/hostmgr"
+)
+
+var (
+	storageCmd = &cobra.Command{
+		Use:   "storage",
+		Short: "Manage the storage backend of the cluster directory",
+	}
+
+	storageMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy the cluster state from one storage backend to another",
+		Long: `Copy the cluster state from one storage backend to another.
+
+Stop mayu before migrating and start it with --storage set to the target
+backend afterwards. The source backend is left untouched.`,
+		Run: storageMigrateRun,
+	}
+
+	storageMigrateFlags = struct {
+		from string
+		to   string
+	}{}
+)
+
+func init() {
+	storageMigrateCmd.Flags().StringVar(&storageMigrateFlags.from, "from", hostmgr.StorageDir, "Storage backend to read the cluster state from (dir or bolt)")
+	storageMigrateCmd.Flags().StringVar(&storageMigrateFlags.to, "to", hostmgr.StorageBolt, "Storage backend to write the cluster state to (dir or bolt)")
+
+	storageCmd.AddCommand(storageMigrateCmd)
+	mainCmd.AddCommand(storageCmd)
+}
+
+func storageMigrateRun(cmd *cobra.Command, args []string) {
+	if storageMigrateFlags.from == storageMigrateFlags.to {
+		log.Fatalf("source and target storage are both '%s'", storageMigrateFlags.from)
+	}
+
+	lock, err := hostmgr.LockClusterDir(globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	from, err := hostmgr.NewStorage(storageMigrateFlags.from, globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer from.Close()
+
+	if !hostmgr.ClusterExists(from) {
+		log.Fatalf("no cluster found in '%s' storage of %s", storageMigrateFlags.from, globalFlags.clusterDir)
+	}
+
+	to, err := hostmgr.NewStorage(storageMigrateFlags.to, globalFlags.clusterDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer to.Close()
+
+	err = hostmgr.MigrateStorage(from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("migrated cluster state of %s from '%s' to '%s' storage", globalFlags.clusterDir, storageMigrateFlags.from, storageMigrateFlags.to)
+}